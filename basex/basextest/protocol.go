@@ -0,0 +1,566 @@
+package basextest
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"basexservice.evalgo.org/basex"
+)
+
+// Codes of the client/server protocol
+const (
+	codeQuery   = 0x00
+	codeClose   = 0x02
+	codeBind    = 0x03
+	codeResults = 0x04
+	codeExecute = 0x05
+	codeReplace = 0x0c
+	codeStore   = 0x0d
+)
+
+// ProtocolServer is a fake BaseX server speaking the client/server protocol
+type ProtocolServer struct {
+	*Store
+	// URL is the basex://host:port URL of the server
+	URL string
+
+	listener net.Listener
+	connsMu  sync.Mutex
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+// NewProtocolServer starts a fake BaseX protocol server with no databases.
+// It is closed when the test ends.
+func NewProtocolServer(t testing.TB) *ProtocolServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start fake BaseX protocol server: %v", err)
+	}
+	s := &ProtocolServer{
+		Store:    newStore(),
+		URL:      "basex://" + listener.Addr().String(),
+		listener: listener,
+		conns:    make(map[net.Conn]bool),
+	}
+	go s.accept()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Client returns a basex client connected to the fake
+func (s *ProtocolServer) Client() basex.Client {
+	client, err := basex.NewSessionClient(basex.Config{URL: s.URL, Username: s.Username, Password: s.Password})
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// Sessions returns the number of open client connections
+func (s *ProtocolServer) Sessions() int {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	return len(s.conns)
+}
+
+// Close stops the server and closes all client connections
+func (s *ProtocolServer) Close() {
+	s.connsMu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.connsMu.Unlock()
+	_ = s.listener.Close()
+}
+
+func (s *ProtocolServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.connsMu.Lock()
+		if s.closed {
+			s.connsMu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.connsMu.Unlock()
+
+		go func() {
+			defer func() {
+				s.connsMu.Lock()
+				delete(s.conns, conn)
+				s.connsMu.Unlock()
+				_ = conn.Close()
+			}()
+			s.serve(conn)
+		}()
+	}
+}
+
+// protocolSession is the state of a client connection
+type protocolSession struct {
+	r *bufio.Reader
+	w *bufio.Writer
+
+	database   string
+	parser     string
+	parameters []basex.Parameter
+	options    []basex.Parameter
+	queries    map[string]*Query
+	nextID     int
+}
+
+func (s *ProtocolServer) serve(conn net.Conn) {
+	session := &protocolSession{
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		queries: make(map[string]*Query),
+	}
+	if !s.login(session) {
+		return
+	}
+
+	for {
+		code, err := session.r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch code {
+		case codeQuery:
+			err = s.query(session)
+		case codeClose, codeBind, codeResults, codeExecute:
+			err = s.queryCommand(session, code)
+		case codeReplace, codeStore:
+			err = s.store(session, code)
+		default:
+			_ = session.r.UnreadByte()
+			err = s.command(session)
+		}
+		if err == nil {
+			err = session.w.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// login runs the digest authentication of the protocol
+func (s *ProtocolServer) login(session *protocolSession) bool {
+	nonce := make([]byte, 8)
+	_, _ = rand.Read(nonce)
+	realm, nonceHex := "BaseX", hex.EncodeToString(nonce)
+	session.writeString(realm + ":" + nonceHex)
+	if session.w.Flush() != nil {
+		return false
+	}
+
+	username, err1 := session.readString()
+	hash, err2 := session.readString()
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	ok := username == s.Username && hash == md5Hex(md5Hex(username+":"+realm+":"+s.Password)+nonceHex)
+	if ok {
+		_ = session.w.WriteByte(0)
+	} else {
+		_ = session.w.WriteByte(1)
+	}
+	return session.w.Flush() == nil && ok
+}
+
+// command runs a command sent as string
+func (s *ProtocolServer) command(session *protocolSession) error {
+	text, err := session.readString()
+	if err != nil {
+		return err
+	}
+	keyword, arg := parseCommand(text)
+	request := Request{Method: keyword, Path: session.database}
+	switch keyword {
+	case "CREATE DB", "DROP DB", "OPEN":
+		request.Path = arg
+	case "DELETE":
+		request.Path = joinPath(session.database, arg)
+	case "":
+		request.Method = "COMMAND"
+		request.Query = &Query{Database: session.database, Text: text, Command: true}
+	}
+	s.record(request)
+
+	if response, ok := s.takeFailure(request.Method, request.Path); ok {
+		session.writeCommandResult("", response.Body, false)
+		return nil
+	}
+
+	if request.Query != nil {
+		response := s.answer(request.Query)
+		if response.Status >= 400 {
+			session.writeCommandResult("", response.Body, false)
+		} else {
+			session.writeCommandResult(response.Body, "", true)
+		}
+		return nil
+	}
+
+	s.mu.Lock()
+	info, failure := s.runCommand(session, keyword, arg)
+	s.mu.Unlock()
+	if failure != "" {
+		session.writeCommandResult("", failure, false)
+	} else {
+		session.writeCommandResult("", info, true)
+	}
+	return nil
+}
+
+// runCommand runs the commands the fake implements. It returns the info
+// of a successful command or the error message of a failed one.
+func (s *ProtocolServer) runCommand(session *protocolSession, keyword, arg string) (info, failure string) {
+	switch keyword {
+	case "OPEN":
+		if _, ok := s.databases[arg]; !ok {
+			return "", fmt.Sprintf("Database '%s' was not found.", arg)
+		}
+		session.database = arg
+		return fmt.Sprintf("Database '%s' was opened.", arg), ""
+	case "CLOSE":
+		session.database = ""
+		return "", ""
+	case "CREATE DB":
+		s.databases[arg] = make(map[string]*Resource)
+		session.database = arg
+		return fmt.Sprintf("Database '%s' created.", arg), ""
+	case "DROP DB":
+		if _, ok := s.databases[arg]; !ok {
+			return "", fmt.Sprintf("Database '%s' was not found.", arg)
+		}
+		if session.database == arg {
+			return "", fmt.Sprintf("Database '%s' is opened by another process.", arg)
+		}
+		delete(s.databases, arg)
+		return fmt.Sprintf("Database '%s' was dropped.", arg), ""
+	case "DELETE":
+		resources, ok := s.databases[session.database]
+		if !ok {
+			return "", "No database opened."
+		}
+		return fmt.Sprintf("%d resource(s) deleted.", deleteResources(resources, arg)), ""
+	case "SET":
+		name, value, _ := strings.Cut(arg, " ")
+		value = unquote(value)
+		switch strings.ToUpper(name) {
+		case "SERIALIZER":
+			session.parameters = parseSerializer(value)
+		case "PARSER":
+			session.parser = value
+		default:
+			session.options = append(session.options, basex.Parameter{Name: strings.ToLower(name), Value: value})
+		}
+		return "", ""
+	}
+	return "", ""
+}
+
+// query registers a query and returns its id
+func (s *ProtocolServer) query(session *protocolSession) error {
+	text, err := session.readString()
+	if err != nil {
+		return err
+	}
+	session.nextID++
+	id := strconv.Itoa(session.nextID)
+	session.queries[id] = &Query{
+		Database:   session.database,
+		Text:       text,
+		Parameters: append([]basex.Parameter(nil), session.parameters...),
+		Options:    append([]basex.Parameter(nil), session.options...),
+	}
+	session.writeData(id)
+	_ = session.w.WriteByte(0)
+	return nil
+}
+
+// queryCommand runs a protocol command on a registered query
+func (s *ProtocolServer) queryCommand(session *protocolSession, code byte) error {
+	id, err := session.readString()
+	if err != nil {
+		return err
+	}
+	var args []string
+	if code == codeBind {
+		for i := 0; i < 3; i++ {
+			arg, err := session.readString()
+			if err != nil {
+				return err
+			}
+			args = append(args, arg)
+		}
+	}
+
+	query, ok := session.queries[id]
+	if !ok {
+		if code == codeResults {
+			_ = session.w.WriteByte(0)
+		} else {
+			session.writeData("")
+		}
+		session.writeError(fmt.Sprintf("Unknown query id: %s", id))
+		return nil
+	}
+
+	switch code {
+	case codeClose:
+		delete(session.queries, id)
+		session.writeData("")
+		_ = session.w.WriteByte(0)
+	case codeBind:
+		query.Variables = append(query.Variables, basex.Variable{Name: strings.TrimPrefix(args[0], "$"), Value: args[1], Type: args[2]})
+		session.writeData("")
+		_ = session.w.WriteByte(0)
+	case codeResults, codeExecute:
+		response := s.run(query)
+		if response.Status >= 400 {
+			if code == codeResults {
+				_ = session.w.WriteByte(0)
+			} else {
+				session.writeData("")
+			}
+			session.writeError(response.Body)
+			return nil
+		}
+		if code == codeExecute {
+			session.writeData(response.Body + strings.Join(response.Items, ""))
+			_ = session.w.WriteByte(0)
+			return nil
+		}
+		items := response.Items
+		if len(items) == 0 && response.Body != "" {
+			items = []string{response.Body}
+		}
+		for _, item := range items {
+			itemType := basex.TypeItem
+			if strings.HasPrefix(item, "<") {
+				itemType = basex.TypeElement
+			}
+			_ = session.w.WriteByte(byte(itemType))
+			session.writeData(item)
+		}
+		_ = session.w.WriteByte(0)
+		_ = session.w.WriteByte(0)
+	}
+	return nil
+}
+
+// run records and answers a query
+func (s *ProtocolServer) run(query *Query) Response {
+	s.record(Request{Method: "QUERY", Path: query.Database, Query: query})
+	if response, ok := s.takeFailure("QUERY", query.Database); ok {
+		return response
+	}
+	if strings.Contains(query.Text, "db:content-type($db, $path)") {
+		return s.getDocument(query)
+	}
+	return s.answer(query)
+}
+
+// getDocument answers the query of SessionClient.GetDocument from the
+// stored resources
+func (s *ProtocolServer) getDocument(query *Query) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resource, ok := s.databases[query.Variable("db")][query.Variable("path")]
+	if !ok {
+		return Response{}
+	}
+	return Response{Items: []string{resource.ContentType, string(resource.Content)}}
+}
+
+// store handles REPLACE and STORE
+func (s *ProtocolServer) store(session *protocolSession, code byte) error {
+	path, err := session.readString()
+	if err != nil {
+		return err
+	}
+	content, err := session.readData()
+	if err != nil {
+		return err
+	}
+
+	method := "REPLACE"
+	contentType := "application/xml"
+	if code == codeStore {
+		method, contentType = "STORE", "application/octet-stream"
+	} else if session.parser == "json" {
+		contentType = "application/json"
+	}
+	request := Request{Method: method, Path: joinPath(session.database, path), Body: content}
+	s.record(request)
+
+	if response, ok := s.takeFailure(request.Method, request.Path); ok {
+		session.writeString(response.Body)
+		_ = session.w.WriteByte(1)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resources, ok := s.databases[session.database]
+	switch {
+	case !ok:
+		session.writeString("No database opened.")
+		_ = session.w.WriteByte(1)
+	case contentType == "application/xml" && checkWellFormed(content) != nil:
+		session.writeString(fmt.Sprintf("\"%s\" (Line 1): %v", path, checkWellFormed(content)))
+		_ = session.w.WriteByte(1)
+	default:
+		resources[path] = newResource(contentType, content)
+		session.writeString(fmt.Sprintf("Resource(s) added to database '%s'.", session.database))
+		_ = session.w.WriteByte(0)
+	}
+	return nil
+}
+
+func (session *protocolSession) writeString(value string) {
+	_, _ = session.w.WriteString(value)
+	_ = session.w.WriteByte(0)
+}
+
+// writeData writes an escaped zero-terminated result
+func (session *protocolSession) writeData(value string) {
+	for i := 0; i < len(value); i++ {
+		if value[i] == 0x00 || value[i] == 0xff {
+			_ = session.w.WriteByte(0xff)
+		}
+		_ = session.w.WriteByte(value[i])
+	}
+	_ = session.w.WriteByte(0)
+}
+
+// writeError writes the failure status and message of a query command
+func (session *protocolSession) writeError(message string) {
+	_ = session.w.WriteByte(1)
+	session.writeString(message)
+}
+
+// writeCommandResult writes the result, info and status of a command
+func (session *protocolSession) writeCommandResult(result, info string, ok bool) {
+	session.writeData(result)
+	session.writeString(info)
+	if ok {
+		_ = session.w.WriteByte(0)
+	} else {
+		_ = session.w.WriteByte(1)
+	}
+}
+
+func (session *protocolSession) readString() (string, error) {
+	value, err := session.r.ReadString(0)
+	if err != nil {
+		return "", err
+	}
+	return value[:len(value)-1], nil
+}
+
+// readData reads escaped zero-terminated content
+func (session *protocolSession) readData() ([]byte, error) {
+	var data []byte
+	for {
+		b, err := session.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return data, nil
+		}
+		if b == 0xff {
+			if b, err = session.r.ReadByte(); err != nil {
+				return nil, err
+			}
+		}
+		data = append(data, b)
+	}
+}
+
+// parseCommand splits the commands implemented by the fake into keyword
+// and argument. Other commands return an empty keyword and are answered by
+// the query handlers.
+func parseCommand(text string) (keyword, arg string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	upper := strings.ToUpper(fields[0])
+	if (upper == "CREATE" || upper == "DROP") && len(fields) > 1 && strings.EqualFold(fields[1], "DB") {
+		rest := strings.TrimSpace(text[strings.Index(strings.ToUpper(text), "DB")+2:])
+		return upper + " DB", unquote(rest)
+	}
+	switch upper {
+	case "OPEN", "CLOSE", "DELETE":
+		return upper, unquote(strings.TrimSpace(text[len(fields[0]):]))
+	case "SET":
+		return upper, strings.TrimSpace(text[len(fields[0]):])
+	}
+	return "", ""
+}
+
+// unquote removes the double quotes around a command argument
+func unquote(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		return strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+	}
+	return value
+}
+
+// parseSerializer parses the value of SET SERIALIZER, in which commas of
+// values are doubled
+func parseSerializer(value string) []basex.Parameter {
+	var params []basex.Parameter
+	var current strings.Builder
+	flush := func() {
+		if name, value, ok := strings.Cut(current.String(), "="); ok {
+			params = append(params, basex.Parameter{Name: name, Value: value})
+		}
+		current.Reset()
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] == ',' {
+			if i+1 < len(value) && value[i+1] == ',' {
+				current.WriteByte(',')
+				i++
+				continue
+			}
+			flush()
+			continue
+		}
+		current.WriteByte(value[i])
+	}
+	flush()
+	return params
+}
+
+func joinPath(db, path string) string {
+	if path == "" {
+		return db
+	}
+	return db + "/" + path
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,272 @@
+// Package basextest provides in-process fakes of the BaseX REST API and
+// client/server protocol for tests. They keep databases and resources in
+// memory, answer queries and commands with scripted responses and can fail
+// requests on demand.
+package basextest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"basexservice.evalgo.org/basex"
+)
+
+// Server is a fake BaseX HTTP server
+type Server struct {
+	*httptest.Server
+	*Store
+}
+
+// NewServer starts a fake BaseX server with no databases. It is closed
+// when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	s := &Server{Store: newStore()}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Client returns a basex client connected to the fake
+func (s *Server) Client() basex.Client {
+	return basex.NewClient(basex.Config{
+		URL:        s.URL,
+		Username:   s.Username,
+		Password:   s.Password,
+		HTTPClient: s.Server.Client(),
+	})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	request := Request{Method: r.Method, Path: r.URL.Path, Body: body}
+
+	username, password, ok := r.BasicAuth()
+	if !ok || username != s.Username || password != s.Password {
+		s.record(request)
+		http.Error(w, "Access denied: "+username+".", http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path != "/rest" && !strings.HasPrefix(r.URL.Path, "/rest/") {
+		s.record(request)
+		http.NotFound(w, r)
+		return
+	}
+	db, path, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/rest"), "/"), "/")
+
+	if r.Method == "POST" {
+		query, err := parseEnvelope(db, body)
+		if err != nil {
+			s.record(request)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request.Query = query
+	}
+	s.record(request)
+
+	if response, ok := s.takeFailure(r.Method, r.URL.Path); ok {
+		writeResponse(w, response)
+		return
+	}
+
+	switch {
+	case r.Method == "POST":
+		writeResponse(w, s.answer(request.Query))
+	case r.Method == "GET":
+		s.get(w, db, path)
+	case r.Method == "PUT":
+		s.put(w, db, path, r.Header.Get("Content-Type"), body)
+	case r.Method == "DELETE":
+		s.delete(w, db, path)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, db, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if db == "" {
+		listing := databasesListing{Count: len(s.databases)}
+		for _, name := range sortedKeys(s.databases) {
+			listing.Databases = append(listing.Databases, databaseEntry{
+				Resources: len(s.databases[name]),
+				Size:      databaseSize(s.databases[name]),
+				Name:      name,
+			})
+		}
+		writeXML(w, listing)
+		return
+	}
+
+	resources, ok := s.databases[db]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Database '%s' was not found.", db), http.StatusNotFound)
+		return
+	}
+	if resource, ok := resources[path]; ok && path != "" {
+		w.Header().Set("Content-Type", resource.ContentType)
+		_, _ = w.Write(resource.Content)
+		return
+	}
+
+	// A directory or the database root lists the resources below it
+	listing := databaseListing{Name: db}
+	for _, name := range sortedKeys(resources) {
+		if path != "" && !strings.HasPrefix(name, strings.TrimSuffix(path, "/")+"/") {
+			continue
+		}
+		listing.Resources = append(listing.Resources, resourceEntry{
+			Type:        resourceType(resources[name].ContentType),
+			ContentType: resources[name].ContentType,
+			Size:        len(resources[name].Content),
+			Path:        name,
+		})
+	}
+	if path != "" && len(listing.Resources) == 0 {
+		http.Error(w, fmt.Sprintf("No resources found at '%s'.", path), http.StatusNotFound)
+		return
+	}
+	listing.Count = len(listing.Resources)
+	writeXML(w, listing)
+}
+
+func (s *Server) put(w http.ResponseWriter, db, path, contentType string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if db == "" {
+		http.Error(w, "Database name is required.", http.StatusBadRequest)
+		return
+	}
+	if path == "" {
+		s.databases[db] = make(map[string]*Resource)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, "Database '%s' created.", db)
+		return
+	}
+
+	resources, ok := s.databases[db]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Database '%s' was not found.", db), http.StatusNotFound)
+		return
+	}
+	if contentType == "" {
+		contentType = "application/xml"
+	}
+	if resourceType(contentType) == "xml" && !strings.Contains(contentType, "json") {
+		if err := checkWellFormed(body); err != nil {
+			http.Error(w, fmt.Sprintf("\"%s\" (Line 1): %v", path, err), http.StatusBadRequest)
+			return
+		}
+	}
+	resources[path] = newResource(contentType, body)
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, "Resource(s) added to database '%s'.", db)
+}
+
+func (s *Server) delete(w http.ResponseWriter, db, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resources, ok := s.databases[db]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Database '%s' was not found.", db), http.StatusNotFound)
+		return
+	}
+	if path == "" {
+		delete(s.databases, db)
+		_, _ = fmt.Fprintf(w, "Database '%s' was dropped.", db)
+		return
+	}
+
+	deleted := deleteResources(resources, path)
+	_, _ = fmt.Fprintf(w, "%d resource(s) deleted from database '%s'.", deleted, db)
+}
+
+// envelope is the body of a REST query or command
+type envelope struct {
+	XMLName    xml.Name
+	Text       string            `xml:"text"`
+	Parameters []basex.Parameter `xml:"parameter"`
+	Options    []basex.Parameter `xml:"option"`
+	Variables  []basex.Variable  `xml:"variable"`
+}
+
+func parseEnvelope(db string, body []byte) (*Query, error) {
+	var e envelope
+	if err := xml.Unmarshal(body, &e); err != nil {
+		return nil, fmt.Errorf("invalid request body: %v", err)
+	}
+	if e.XMLName.Space != "http://basex.org/rest" || (e.XMLName.Local != "query" && e.XMLName.Local != "command") {
+		return nil, fmt.Errorf("unexpected request element {%s}%s", e.XMLName.Space, e.XMLName.Local)
+	}
+	return &Query{
+		Database:   db,
+		Text:       e.Text,
+		Command:    e.XMLName.Local == "command",
+		Variables:  e.Variables,
+		Parameters: e.Parameters,
+		Options:    e.Options,
+	}, nil
+}
+
+// Listing formats of GET /rest and GET /rest/{db}
+type databasesListing struct {
+	XMLName   xml.Name        `xml:"http://basex.org/rest databases"`
+	Count     int             `xml:"resources,attr"`
+	Databases []databaseEntry `xml:"http://basex.org/rest database"`
+}
+
+type databaseEntry struct {
+	Resources int    `xml:"resources,attr"`
+	Size      int    `xml:"size,attr"`
+	Name      string `xml:",chardata"`
+}
+
+type databaseListing struct {
+	XMLName   xml.Name        `xml:"http://basex.org/rest database"`
+	Name      string          `xml:"name,attr"`
+	Count     int             `xml:"resources,attr"`
+	Resources []resourceEntry `xml:"http://basex.org/rest resource"`
+}
+
+type resourceEntry struct {
+	Type        string `xml:"type,attr"`
+	ContentType string `xml:"content-type,attr"`
+	Size        int    `xml:"size,attr"`
+	Path        string `xml:",chardata"`
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(data)
+}
+
+func writeResponse(w http.ResponseWriter, response Response) {
+	if response.ContentType == "" {
+		response.ContentType = "application/xml"
+	}
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+	if response.Body == "" {
+		response.Body = strings.Join(response.Items, "")
+	}
+	w.Header().Set("Content-Type", response.ContentType)
+	w.WriteHeader(response.Status)
+	_, _ = io.WriteString(w, response.Body)
+}
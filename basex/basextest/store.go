@@ -0,0 +1,381 @@
+package basextest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"basexservice.evalgo.org/basex"
+)
+
+// Default credentials accepted by a new server
+const (
+	DefaultUsername = "admin"
+	DefaultPassword = "admin"
+)
+
+// Resource is a resource stored in a fake database
+type Resource struct {
+	ContentType string
+	Content     []byte
+	Modified    time.Time
+}
+
+// newResource returns a resource modified now
+func newResource(contentType string, content []byte) *Resource {
+	return &Resource{ContentType: contentType, Content: content, Modified: time.Now().UTC()}
+}
+
+// Query is a query or command received by the fake
+type Query struct {
+	// Database is the database context of the request, empty for /rest
+	// and for sessions without an open database
+	Database string
+	Text     string
+	// Command is set for <command> requests and protocol commands
+	Command    bool
+	Variables  []basex.Variable
+	Parameters []basex.Parameter
+	Options    []basex.Parameter
+}
+
+// Variable returns the value bound to an external variable
+func (q *Query) Variable(name string) string {
+	for _, variable := range q.Variables {
+		if variable.Name == name {
+			return variable.Value
+		}
+	}
+	return ""
+}
+
+// Response is a scripted answer. A zero Status means 200 OK.
+type Response struct {
+	Status      int
+	ContentType string
+	Body        string
+	// Items are sent one by one by the protocol server, which sends Body as
+	// a single item otherwise. The HTTP server concatenates them if Body is
+	// empty.
+	Items []string
+}
+
+// QueryFunc answers a query or command
+type QueryFunc func(query *Query) Response
+
+// Request is a request received by the fake
+type Request struct {
+	// Method is the HTTP method, or the command keyword (e.g. "QUERY",
+	// "REPLACE", "DROP DB") for the protocol server
+	Method string
+	// Path is the URL path, or "db" or "db/path" for the protocol server
+	Path string
+	Body []byte
+	// Query is the parsed envelope of POST requests and protocol queries
+	Query *Query
+}
+
+// Store holds the databases, scripted responses and request log of a fake
+// server
+type Store struct {
+	Username string
+	Password string
+
+	mu        sync.Mutex
+	databases map[string]map[string]*Resource
+	handlers  []queryHandler
+	failures  []failure
+	requests  []Request
+}
+
+type queryHandler struct {
+	match string
+	fn    QueryFunc
+}
+
+type failure struct {
+	method   string
+	path     string
+	response Response
+}
+
+func newStore() *Store {
+	return &Store{
+		Username:  DefaultUsername,
+		Password:  DefaultPassword,
+		databases: make(map[string]map[string]*Resource),
+	}
+}
+
+// CreateDatabase adds an empty database, replacing an existing one
+func (s *Store) CreateDatabase(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.databases[name] = make(map[string]*Resource)
+}
+
+// PutResource stores a resource, creating the database if needed
+func (s *Store) PutResource(db, path, contentType string, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.databases[db] == nil {
+		s.databases[db] = make(map[string]*Resource)
+	}
+	s.databases[db][path] = newResource(contentType, content)
+}
+
+// DeleteResource deletes a resource, or all resources below a directory
+// path, and returns the number of deleted resources
+func (s *Store) DeleteResource(db, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return deleteResources(s.databases[db], path)
+}
+
+// Resource returns a stored resource
+func (s *Store) Resource(db, path string) (*Resource, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resource, ok := s.databases[db][path]
+	return resource, ok
+}
+
+// HasDatabase reports whether a database exists
+func (s *Store) HasDatabase(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.databases[name]
+	return ok
+}
+
+// Resources returns the sorted resource paths of a database
+func (s *Store) Resources(db string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.databases[db])
+}
+
+// HandleQuery answers the queries and commands whose text contains match
+// with fn. An empty match answers all of them. Handlers registered later
+// take precedence.
+func (s *Store) HandleQuery(match string, fn QueryFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, queryHandler{match: match, fn: fn})
+}
+
+// RespondToQuery answers the queries whose text contains match with body
+func (s *Store) RespondToQuery(match, body string) {
+	s.HandleQuery(match, func(*Query) Response {
+		return Response{Body: body}
+	})
+}
+
+// FailNext answers the next request with the given method and path (e.g.
+// "PUT", "/rest/db/doc.xml", or "REPLACE", "db/doc.xml" for the protocol
+// server) with an error. An empty method or path matches any.
+func (s *Store) FailNext(method, path string, status int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{
+		method:   method,
+		path:     path,
+		response: Response{Status: status, ContentType: "text/plain", Body: message},
+	})
+}
+
+// Requests returns the requests received so far
+func (s *Store) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Queries returns the queries and commands received so far
+func (s *Store) Queries() []*Query {
+	var queries []*Query
+	for _, request := range s.Requests() {
+		if request.Query != nil {
+			queries = append(queries, request.Query)
+		}
+	}
+	return queries
+}
+
+func (s *Store) record(request Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, request)
+}
+
+func (s *Store) takeFailure(method, path string) (Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.failures {
+		if (f.method == "" || f.method == method) && (f.path == "" || f.path == path) {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			return f.response, true
+		}
+	}
+	return Response{}, false
+}
+
+// answer runs the newest handler matching the query
+func (s *Store) answer(query *Query) Response {
+	s.mu.Lock()
+	var fn QueryFunc
+	for i := len(s.handlers) - 1; i >= 0; i-- {
+		if strings.Contains(query.Text, s.handlers[i].match) {
+			fn = s.handlers[i].fn
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if fn == nil && strings.Contains(query.Text, "db:list-details(") {
+		return s.listDetails(query)
+	}
+	if fn == nil {
+		return Response{
+			Status:      http.StatusBadRequest,
+			ContentType: "text/plain",
+			Body:        fmt.Sprintf("Stopped at ., 1/1:\n[basextest:unscripted] No response scripted for %q", query.Text),
+		}
+	}
+	return fn(query)
+}
+
+// listDetails answers the db:list-details queries of ListDatabases and
+// ListDocuments from the stored databases
+func (s *Store) listDetails(query *Query) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var listing detailsListing
+	if !strings.Contains(query.Text, "db:list-details($db, $prefix)") {
+		for _, name := range sortedKeys(s.databases) {
+			listing.Databases = append(listing.Databases, databaseDetails{
+				Resources: len(s.databases[name]),
+				Size:      databaseSize(s.databases[name]),
+				Modified:  formatModified(databaseModified(s.databases[name])),
+				Name:      name,
+			})
+		}
+	} else {
+		resources, ok := s.databases[query.Variable("db")]
+		if !ok {
+			return Response{}
+		}
+		for _, path := range sortedKeys(resources) {
+			if !strings.HasPrefix(path, query.Variable("prefix")) {
+				continue
+			}
+			listing.Resources = append(listing.Resources, resourceDetails{
+				ContentType: resources[path].ContentType,
+				Size:        len(resources[path].Content),
+				Modified:    formatModified(resources[path].Modified),
+				Path:        path,
+			})
+		}
+	}
+
+	data, err := xml.Marshal(listing)
+	if err != nil {
+		return Response{Status: http.StatusInternalServerError, ContentType: "text/plain", Body: err.Error()}
+	}
+	return Response{Body: string(data)}
+}
+
+// detailsListing wraps the elements returned by db:list-details
+type detailsListing struct {
+	XMLName   xml.Name          `xml:"list"`
+	Databases []databaseDetails `xml:"database"`
+	Resources []resourceDetails `xml:"resource"`
+}
+
+type databaseDetails struct {
+	Resources int    `xml:"resources,attr"`
+	Size      int    `xml:"size,attr"`
+	Modified  string `xml:"modified-date,attr,omitempty"`
+	Name      string `xml:",chardata"`
+}
+
+type resourceDetails struct {
+	ContentType string `xml:"content-type,attr"`
+	Size        int    `xml:"size,attr"`
+	Modified    string `xml:"modified-date,attr,omitempty"`
+	Path        string `xml:",chardata"`
+}
+
+// deleteResources deletes the resource at path and the resources below it
+func deleteResources(resources map[string]*Resource, path string) int {
+	deleted := 0
+	for name := range resources {
+		if name == path || strings.HasPrefix(name, strings.TrimSuffix(path, "/")+"/") {
+			delete(resources, name)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// resourceType returns the BaseX resource type stored for a content type
+func resourceType(contentType string) string {
+	if strings.Contains(contentType, "xml") || strings.Contains(contentType, "json") {
+		return "xml"
+	}
+	return "binary"
+}
+
+// checkWellFormed rejects XML that BaseX could not parse
+func checkWellFormed(data []byte) error {
+	decoder := xml.NewDecoder(strings.NewReader(string(data)))
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func databaseSize(resources map[string]*Resource) int {
+	size := 0
+	for _, resource := range resources {
+		size += len(resource.Content)
+	}
+	return size
+}
+
+// databaseModified returns the time the newest resource was modified
+func databaseModified(resources map[string]*Resource) time.Time {
+	var modified time.Time
+	for _, resource := range resources {
+		if resource.Modified.After(modified) {
+			modified = resource.Modified
+		}
+	}
+	return modified
+}
+
+// formatModified formats a time as the modified-date of db:list-details
+func formatModified(modified time.Time) string {
+	if modified.IsZero() {
+		return ""
+	}
+	return modified.Format("2006-01-02T15:04:05.000Z")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,234 @@
+// Package basex is a client for the BaseX XML database. It covers the
+// operations basexservice needs (databases, documents, queries, commands and
+// XSLT transforms) behind the Client interface, so other services can reuse
+// it and tests can substitute a fake. RESTClient talks to the BaseX HTTP
+// server and SessionClient to the client/server protocol on port 1984.
+package basex
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client is the set of BaseX operations used by the service
+type Client interface {
+	Databases
+	Documents
+	Queries
+	Commands
+	Transforms
+}
+
+// Databases manages BaseX databases
+type Databases interface {
+	// CreateDatabase creates an empty database, replacing an existing one
+	CreateDatabase(ctx context.Context, name string) error
+	// DropDatabase deletes a database
+	DropDatabase(ctx context.Context, name string) error
+	// ListDatabases returns the databases of the server
+	ListDatabases(ctx context.Context) ([]DatabaseInfo, error)
+}
+
+// DatabaseInfo describes a database
+type DatabaseInfo struct {
+	Name string
+	// Resources is the number of resources stored in the database
+	Resources int
+	// Size is the size of the database in bytes
+	Size     int64
+	Modified time.Time
+}
+
+// Documents manages the resources stored in a database
+type Documents interface {
+	// PutDocument stores a resource. XML and JSON content is parsed by
+	// BaseX, other content types are stored as raw resources.
+	PutDocument(ctx context.Context, db, path string, content io.Reader, contentType string) error
+	// DeleteDocument deletes a resource
+	DeleteDocument(ctx context.Context, db, path string) error
+	// GetDocument reads a resource. XML documents are serialized, other
+	// resources are returned as stored. The caller must close the body.
+	GetDocument(ctx context.Context, db, path string) (*Document, error)
+	// ListDocuments returns the resources of a database whose paths start
+	// with prefix, sorted by path
+	ListDocuments(ctx context.Context, db, prefix string) ([]DocumentInfo, error)
+}
+
+// Document is a resource read from a database
+type Document struct {
+	// ContentType is the media type of the resource, application/xml for
+	// XML documents
+	ContentType string
+	Body        io.ReadCloser
+}
+
+// DocumentInfo describes a resource stored in a database
+type DocumentInfo struct {
+	Path        string
+	ContentType string
+	// Size is the number of bytes of binary resources and the number of
+	// nodes of XML documents, as reported by BaseX
+	Size     int64
+	Modified time.Time
+}
+
+// Queries runs XQueries. db sets the database context for doc() calls and
+// may be empty.
+type Queries interface {
+	// Query runs a query and returns its serialized result
+	Query(ctx context.Context, db string, query *Query) ([]byte, error)
+	// QueryStream runs a query and returns the unread result, so large
+	// results can be piped to their destination. The caller must close it.
+	// If ctx ends before the result is closed, the query is stopped in BaseX.
+	QueryStream(ctx context.Context, db string, query *Query) (io.ReadCloser, error)
+}
+
+// Commands runs BaseX commands such as LIST or INFO DB
+type Commands interface {
+	// Command runs a command and returns its output
+	Command(ctx context.Context, command string) ([]byte, error)
+}
+
+// Transforms runs XSLT pipelines inside BaseX
+type Transforms interface {
+	// Transform applies the stylesheets stored in db to a source document
+	Transform(ctx context.Context, db string, transform *Transform) (*TransformResult, error)
+}
+
+// Config holds the connection settings of a client
+type Config struct {
+	// URL is the base URL of the BaseX HTTP server, without the /rest path,
+	// or basex://host:port for the client/server protocol
+	URL      string
+	Username string
+	Password string
+	// HTTPClient is used for all REST requests. It defaults to
+	// DefaultHTTPClient.
+	HTTPClient *http.Client
+	// MaxIdleSessions limits the pooled protocol sessions per server and
+	// user. It defaults to DefaultMaxIdleSessions.
+	MaxIdleSessions int
+	// Dialer connects protocol sessions, e.g. to restrict the addresses
+	// they reach through its Control function. Sessions are pooled per
+	// dialer, so clients with different dialers never share a session. It
+	// defaults to a zero net.Dialer.
+	Dialer *net.Dialer
+	// OnBackgroundError is called with the errors of requests no caller is
+	// waiting for, such as stopping a cancelled query. They are dropped if
+	// it is nil.
+	OnBackgroundError func(err error)
+}
+
+// Open returns the client for the scheme of config.URL: a SessionClient for
+// basex:// URLs and a RESTClient otherwise
+func Open(config Config) (Client, error) {
+	if strings.HasPrefix(config.URL, "basex://") {
+		return NewSessionClient(config)
+	}
+	return NewClient(config), nil
+}
+
+// DefaultHTTPClient is shared by clients configured without an HTTP client,
+// so connections to a BaseX server are pooled across clients. It has no
+// timeout of its own; deadlines come from the request contexts.
+var DefaultHTTPClient = &http.Client{Transport: NewTransport()}
+
+// NewTransport returns an HTTP transport tuned for talking to a few BaseX
+// servers with many concurrent requests
+func NewTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   32,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// RESTClient implements Client on top of the BaseX REST API
+type RESTClient struct {
+	url      string
+	username string
+	password string
+	http     *http.Client
+	onError  func(err error)
+}
+
+// NewClient returns a client for the BaseX REST API
+func NewClient(config Config) *RESTClient {
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = DefaultHTTPClient
+	}
+	return &RESTClient{
+		url:      strings.TrimSuffix(config.URL, "/"),
+		username: config.Username,
+		password: config.Password,
+		http:     httpClient,
+		onError:  config.OnBackgroundError,
+	}
+}
+
+var _ Client = (*RESTClient)(nil)
+
+// restURL returns the REST URL of a database or resource path, escaping
+// each of its segments
+func (c *RESTClient) restURL(path string) string {
+	if path == "" {
+		return c.url + "/rest"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return c.url + "/rest/" + strings.Join(segments, "/")
+}
+
+// do sends a request and returns the response if BaseX accepted it. Error
+// responses are returned as *Error, naming op.
+func (c *RESTClient) do(ctx context.Context, op, method, url string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", op, err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.SetBasicAuth(c.username, c.password)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", op, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer func() { _ = resp.Body.Close() }()
+		message, _ := io.ReadAll(resp.Body)
+		return nil, newError(op, resp.StatusCode, string(message))
+	}
+
+	return resp, nil
+}
+
+// exec sends a request and discards the response body
+func (c *RESTClient) exec(ctx context.Context, op, method, url string, body io.Reader, contentType string) error {
+	resp, err := c.do(ctx, op, method, url, body, contentType)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
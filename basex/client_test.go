@@ -0,0 +1,349 @@
+package basex_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"basexservice.evalgo.org/basex"
+	"basexservice.evalgo.org/basex/basextest"
+)
+
+func TestDatabasesAndDocuments(t *testing.T) {
+	server := basextest.NewServer(t)
+	client := server.Client()
+	ctx := context.Background()
+
+	if err := client.CreateDatabase(ctx, "db"); err != nil {
+		t.Fatalf("CreateDatabase: %v", err)
+	}
+	if err := client.PutDocument(ctx, "db", "a/doc.xml", strings.NewReader("<doc/>"), "application/xml"); err != nil {
+		t.Fatalf("PutDocument: %v", err)
+	}
+	resource, ok := server.Resource("db", "a/doc.xml")
+	if !ok || string(resource.Content) != "<doc/>" {
+		t.Fatalf("stored resource = %+v, %v", resource, ok)
+	}
+	server.PutResource("db", "raw.bin", "image/png", []byte{0x89, 'P', 'N', 'G'})
+	for path, want := range map[string]string{"a/doc.xml": "<doc/>", "raw.bin": "\x89PNG"} {
+		doc, err := client.GetDocument(ctx, "db", path)
+		if err != nil {
+			t.Fatalf("GetDocument %s: %v", path, err)
+		}
+		content, _ := io.ReadAll(doc.Body)
+		_ = doc.Body.Close()
+		if string(content) != want {
+			t.Errorf("GetDocument %s = %q, want %q", path, content, want)
+		}
+	}
+	if _, err := client.GetDocument(ctx, "db", "missing.xml"); !errors.Is(err, basex.ErrNotFound) {
+		t.Errorf("GetDocument of a missing resource: got %v, want ErrNotFound", err)
+	}
+
+	databases, err := client.ListDatabases(ctx)
+	if err != nil || len(databases) != 1 || databases[0].Name != "db" || databases[0].Resources != 2 || databases[0].Modified.IsZero() {
+		t.Errorf("ListDatabases = %+v, %v", databases, err)
+	}
+	documents, err := client.ListDocuments(ctx, "db", "a/")
+	if err != nil || len(documents) != 1 || documents[0].Path != "a/doc.xml" || documents[0].ContentType != "application/xml" {
+		t.Errorf("ListDocuments = %+v, %v", documents, err)
+	}
+	if _, err := client.ListDocuments(ctx, "missing", ""); !errors.Is(err, basex.ErrNotFound) {
+		t.Errorf("ListDocuments of a missing database: got %v, want ErrNotFound", err)
+	}
+
+	if err := client.DeleteDocument(ctx, "db", "a/doc.xml"); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	if _, ok := server.Resource("db", "a/doc.xml"); ok {
+		t.Fatal("resource still stored after DeleteDocument")
+	}
+
+	if err := client.DropDatabase(ctx, "db"); err != nil {
+		t.Fatalf("DropDatabase: %v", err)
+	}
+	if server.HasDatabase("db") {
+		t.Fatal("database still exists after DropDatabase")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := basextest.NewServer(t)
+	ctx := context.Background()
+
+	err := server.Client().PutDocument(ctx, "missing", "doc.xml", strings.NewReader("<doc/>"), "application/xml")
+	if !errors.Is(err, basex.ErrNotFound) {
+		t.Errorf("PutDocument into missing database: got %v, want ErrNotFound", err)
+	}
+
+	denied := basex.NewClient(basex.Config{URL: server.URL, Username: "admin", Password: "wrong"})
+	if err := denied.CreateDatabase(ctx, "db"); !errors.Is(err, basex.ErrUnauthorized) {
+		t.Errorf("CreateDatabase with wrong password: got %v, want ErrUnauthorized", err)
+	}
+
+	server.HandleQuery("1 +", func(*basextest.Query) basextest.Response {
+		return basextest.Response{Status: http.StatusBadRequest, Body: "Stopped at ., 1/4:\n[XPST0003] Incomplete expression."}
+	})
+	_, err = server.Client().Query(ctx, "", &basex.Query{Text: "1 +"})
+	var basexErr *basex.Error
+	if !errors.As(err, &basexErr) || basexErr.Code != "XPST0003" || !errors.Is(err, basex.ErrQuery) {
+		t.Errorf("Query with syntax error: got %#v", err)
+	}
+
+	server.CreateDatabase("db")
+	server.FailNext("DELETE", "/rest/db", http.StatusInternalServerError, "disk full")
+	err = server.Client().DropDatabase(ctx, "db")
+	if !errors.As(err, &basexErr) || basexErr.StatusCode != http.StatusInternalServerError || basexErr.Op != "delete database" {
+		t.Errorf("DropDatabase with scripted failure: got %v", err)
+	}
+}
+
+func TestClientsShareConnections(t *testing.T) {
+	var mu sync.Mutex
+	var remoteAddrs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		remoteAddrs = append(remoteAddrs, r.RemoteAddr)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	// Clients without an HTTP client of their own pool connections
+	for _, name := range []string{"a", "b", "c"} {
+		client := basex.NewClient(basex.Config{URL: server.URL, Username: "admin", Password: "admin"})
+		if err := client.CreateDatabase(context.Background(), name); err != nil {
+			t.Fatalf("CreateDatabase %s: %v", name, err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(remoteAddrs) != 3 || remoteAddrs[1] != remoteAddrs[0] || remoteAddrs[2] != remoteAddrs[0] {
+		t.Errorf("requests came from %v, want one connection", remoteAddrs)
+	}
+}
+
+func TestQueryEscaping(t *testing.T) {
+	server := basextest.NewServer(t)
+	server.HandleQuery("$id", func(*basextest.Query) basextest.Response {
+		return basextest.Response{}
+	})
+
+	// Query text and values are escaped, so they may contain a CDATA end
+	text := "declare variable $id external; '<![CDATA[x]]>', $id"
+	if _, err := server.Client().Query(context.Background(), "db", &basex.Query{
+		Text:      text,
+		Variables: []basex.Variable{{Name: "id", Value: "a]]>b<c/>", Type: "xs:string"}},
+	}); err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	q := server.Queries()[0]
+	if !strings.HasSuffix(q.Text, text) || q.Variable("id") != "a]]>b<c/>" {
+		t.Errorf("escaped query = %+v", q)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	server := basextest.NewServer(t)
+	server.HandleQuery("$id", func(q *basextest.Query) basextest.Response {
+		return basextest.Response{Body: "<concept id=\"" + q.Variable("id") + "\"/>"}
+	})
+
+	result, err := server.Client().Query(context.Background(), "db", &basex.Query{
+		Text:          "declare variable $id external; //concept[@id = $id]",
+		Variables:     []basex.Variable{{Name: "id", Value: "c42", Type: "xs:string"}},
+		Serialization: &basex.Serialization{Parameters: []basex.Parameter{{Name: "method", Value: "xml"}}},
+	})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if string(result) != `<concept id="c42"/>` {
+		t.Errorf("result = %s", result)
+	}
+
+	queries := server.Queries()
+	if len(queries) != 1 {
+		t.Fatalf("got %d queries, want 1", len(queries))
+	}
+	q := queries[0]
+	if q.Database != "db" || len(q.Parameters) != 1 || q.Parameters[0].Name != "method" || q.Variables[0].Type != "xs:string" {
+		t.Errorf("query envelope = %+v", q)
+	}
+}
+
+func TestQueryStreamStopsJobOnCancel(t *testing.T) {
+	server := basextest.NewServer(t)
+	release := make(chan struct{})
+	server.HandleQuery("slow", func(*basextest.Query) basextest.Response {
+		<-release
+		return basextest.Response{}
+	})
+	stopped := make(chan string, 1)
+	server.HandleQuery("jobs:stop", func(q *basextest.Query) basextest.Response {
+		stopped <- q.Variable("marker")
+		close(release)
+		return basextest.Response{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := server.Client().Query(ctx, "db", &basex.Query{Text: "slow"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Query: got %v, want deadline exceeded", err)
+	}
+
+	select {
+	case marker := <-stopped:
+		if !strings.Contains(server.Queries()[0].Text, marker) {
+			t.Errorf("stop query marker %q does not tag the cancelled query", marker)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled query was not stopped")
+	}
+}
+
+func TestQueryStreamReportsFailedStop(t *testing.T) {
+	server := basextest.NewServer(t)
+	release := make(chan struct{})
+	server.HandleQuery("slow", func(*basextest.Query) basextest.Response {
+		<-release
+		return basextest.Response{}
+	})
+	server.HandleQuery("jobs:stop", func(*basextest.Query) basextest.Response {
+		close(release)
+		return basextest.Response{Status: http.StatusForbidden, Body: "Permission needed"}
+	})
+	failed := make(chan error, 1)
+	client := basex.NewClient(basex.Config{
+		URL:               server.URL,
+		Username:          server.Username,
+		Password:          server.Password,
+		OnBackgroundError: func(err error) { failed <- err },
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Query(ctx, "db", &basex.Query{Text: "slow"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Query: got %v, want deadline exceeded", err)
+	}
+
+	select {
+	case err := <-failed:
+		if !strings.Contains(err.Error(), "failed to stop BaseX job") {
+			t.Errorf("background error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("failed stop was not reported")
+	}
+}
+
+func TestQueryStreamDoesNotStopConsumedJob(t *testing.T) {
+	server := basextest.NewServer(t)
+	server.RespondToQuery("fast", "<ok/>")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	body, err := server.Client().QueryStream(ctx, "db", &basex.Query{Text: "fast"})
+	if err != nil {
+		t.Fatalf("QueryStream: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	_ = body.Close()
+	cancel()
+
+	if string(data) != "<ok/>" {
+		t.Errorf("result = %s", data)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := len(server.Queries()); n != 1 {
+		t.Errorf("got %d queries, want no stop query after the result was consumed", n)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	server := basextest.NewServer(t)
+	server.RespondToQuery("LIST", "db 1 resource")
+
+	output, err := server.Client().Command(context.Background(), "LIST")
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+	if string(output) != "db 1 resource" || !server.Queries()[0].Command {
+		t.Errorf("output = %q, queries = %+v", output, server.Queries())
+	}
+}
+
+func TestTransform(t *testing.T) {
+	server := basextest.NewServer(t)
+	server.HandleQuery("xslt:transform-text", func(q *basextest.Query) basextest.Response {
+		if !strings.Contains(q.Text, "return <trace>") {
+			return basextest.Response{Body: "final"}
+		}
+		return basextest.Response{Body: `<trace><stage position="1" stylesheet="a.xsl">&lt;a/&gt;</stage><stage position="2" stylesheet="b.xsl">final</stage></trace>`}
+	})
+
+	transform := &basex.Transform{
+		Stylesheets: []string{"a.xsl", "b.xsl"},
+		XML:         "<in/>",
+		Params:      map[string]basex.Param{"n": {Value: "3", Type: "xs:integer"}, "nodes": {Value: "<x/><y/>", Fragment: true}},
+	}
+	result, err := server.Client().Transform(context.Background(), "db", transform)
+	if err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	if string(result.Output) != "final" || result.Stages != nil {
+		t.Errorf("result = %+v", result)
+	}
+
+	q := server.Queries()[0]
+	for _, want := range []string{`parse-xml($source)`, `xslt:transform($stage0, doc("db/a.xsl"), $params)`, `"nodes": parse-xml-fragment($param2)`} {
+		if !strings.Contains(q.Text, want) {
+			t.Errorf("transform query lacks %s:\n%s", want, q.Text)
+		}
+	}
+	if q.Variable("source") != "<in/>" || q.Variable("param1") != "3" {
+		t.Errorf("variables = %+v", q.Variables)
+	}
+
+	transform.Trace = true
+	result, err = server.Client().Transform(context.Background(), "db", transform)
+	if err != nil {
+		t.Fatalf("Transform with trace: %v", err)
+	}
+	if string(result.Output) != "final" || len(result.Stages) != 2 || result.Stages[0].Output != "<a/>" {
+		t.Errorf("traced result = %+v", result)
+	}
+}
+
+func TestStringLiteral(t *testing.T) {
+	if got := basex.StringLiteral(`a "b" & c`); got != `"a ""b"" &amp; c"` {
+		t.Errorf("StringLiteral = %s", got)
+	}
+}
+
+func TestSerializationFormat(t *testing.T) {
+	tests := []struct {
+		params []basex.Parameter
+		want   string
+	}{
+		{nil, "application/xml"},
+		{[]basex.Parameter{{Name: "method", Value: "json"}}, "application/json"},
+		{[]basex.Parameter{{Name: "method", Value: "json"}, {Name: "media-type", Value: "application/ld+json"}}, "application/ld+json"},
+	}
+	for _, tt := range tests {
+		s := &basex.Serialization{Parameters: tt.params}
+		if got := s.Format(); got != tt.want {
+			t.Errorf("Format(%v) = %s, want %s", tt.params, got, tt.want)
+		}
+	}
+
+	s := &basex.Serialization{Parameters: []basex.Parameter{{Name: "method", Value: "csv"}}}
+	s.SetMethod("xml")
+	if s.Format() != "text/csv" {
+		t.Error("SetMethod overrode an explicit method")
+	}
+}
@@ -0,0 +1,81 @@
+package basex
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// Sentinel errors matched by *Error through errors.Is
+var (
+	// ErrNotFound reports a missing database or resource
+	ErrNotFound = errors.New("basex: not found")
+	// ErrUnauthorized reports rejected credentials or missing permissions
+	ErrUnauthorized = errors.New("basex: unauthorized")
+	// ErrQuery reports a query BaseX could not compile or evaluate
+	ErrQuery = errors.New("basex: query failed")
+)
+
+// Error is a request BaseX answered with an error
+type Error struct {
+	// Op names the failed operation, e.g. "query" or "create database"
+	Op string
+	// StatusCode is the HTTP status of REST errors and 0 for protocol errors
+	StatusCode int
+	// Code is the XQuery error code reported by BaseX, e.g. XPST0003
+	Code    string
+	Message string
+}
+
+// errorCodePattern finds the error code in BaseX error messages such as
+// "Stopped at ., 1/5:\n[XPST0003] Unexpected end of query."
+var errorCodePattern = regexp.MustCompile(`\[(\w+(?::\w+)?)\]`)
+
+// newError builds the error of a failed response
+func newError(op string, statusCode int, message string) *Error {
+	err := &Error{Op: op, StatusCode: statusCode, Message: message}
+	if match := errorCodePattern.FindStringSubmatch(message); match != nil {
+		err.Code = match[1]
+	}
+	return err
+}
+
+// newProtocolError builds the error of a failed client/server protocol
+// request, which carries no status code
+func newProtocolError(op, message string) *Error {
+	return newError(op, 0, message)
+}
+
+// Messages of protocol errors that correspond to HTTP error statuses
+var (
+	notFoundPattern     = regexp.MustCompile(`(?i)\bnot found\b|\bwas not found\b`)
+	unauthorizedPattern = regexp.MustCompile(`(?i)^access denied|permission needed`)
+)
+
+func (e *Error) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("BaseX %s failed: %s", e.Op, e.Message)
+	}
+	return fmt.Sprintf("BaseX %s failed with status %d: %s", e.Op, e.StatusCode, e.Message)
+}
+
+// Is matches the sentinel errors of the package. Protocol errors are
+// matched by their message.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		if e.StatusCode == 0 {
+			return e.Code == "" && notFoundPattern.MatchString(e.Message)
+		}
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		if e.StatusCode == 0 {
+			return unauthorizedPattern.MatchString(e.Message)
+		}
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrQuery:
+		return e.Code != ""
+	}
+	return false
+}
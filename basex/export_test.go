@@ -0,0 +1,15 @@
+package basex
+
+// SetMaxSessionPools changes the number of session pools kept for the
+// duration of a test
+func SetMaxSessionPools(n int) (restore func()) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	previous := maxSessionPools
+	maxSessionPools = n
+	return func() {
+		poolsMu.Lock()
+		defer poolsMu.Unlock()
+		maxSessionPools = previous
+	}
+}
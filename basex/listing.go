@@ -0,0 +1,90 @@
+package basex
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// detailsListing is the result of listDatabasesQuery and listDocumentsQuery,
+// which wrap the elements returned by db:list-details
+type detailsListing struct {
+	Databases []struct {
+		Resources int    `xml:"resources,attr"`
+		Size      int64  `xml:"size,attr"`
+		Modified  string `xml:"modified-date,attr"`
+		Name      string `xml:",chardata"`
+	} `xml:"database"`
+	Resources []struct {
+		ContentType string `xml:"content-type,attr"`
+		Size        int64  `xml:"size,attr"`
+		Modified    string `xml:"modified-date,attr"`
+		Path        string `xml:",chardata"`
+	} `xml:"resource"`
+}
+
+// listDocuments returns the query listing the resources of db below prefix
+func listDocuments(db, prefix string) *Query {
+	return &Query{
+		Text: listDocumentsQuery,
+		Variables: []Variable{
+			{Name: "db", Value: db, Type: "xs:string"},
+			{Name: "prefix", Value: prefix, Type: "xs:string"},
+		},
+	}
+}
+
+// parseDatabases parses the result of listDatabasesQuery
+func parseDatabases(result []byte) ([]DatabaseInfo, error) {
+	var listing detailsListing
+	if err := xml.Unmarshal(result, &listing); err != nil {
+		return nil, fmt.Errorf("failed to parse database list: %w", err)
+	}
+	databases := make([]DatabaseInfo, 0, len(listing.Databases))
+	for _, database := range listing.Databases {
+		databases = append(databases, DatabaseInfo{
+			Name:      strings.TrimSpace(database.Name),
+			Resources: database.Resources,
+			Size:      database.Size,
+			Modified:  parseModified(database.Modified),
+		})
+	}
+	sort.Slice(databases, func(i, j int) bool { return databases[i].Name < databases[j].Name })
+	return databases, nil
+}
+
+// parseDocuments parses the result of listDocumentsQuery. BaseX matches
+// prefix itself; it is applied again so all clients agree.
+func parseDocuments(result []byte, prefix string) ([]DocumentInfo, error) {
+	var listing detailsListing
+	if err := xml.Unmarshal(result, &listing); err != nil {
+		return nil, fmt.Errorf("failed to parse document list: %w", err)
+	}
+	documents := make([]DocumentInfo, 0, len(listing.Resources))
+	for _, resource := range listing.Resources {
+		path := strings.TrimSpace(resource.Path)
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		documents = append(documents, DocumentInfo{
+			Path:        path,
+			ContentType: resource.ContentType,
+			Size:        resource.Size,
+			Modified:    parseModified(resource.Modified),
+		})
+	}
+	sort.Slice(documents, func(i, j int) bool { return documents[i].Path < documents[j].Path })
+	return documents, nil
+}
+
+// parseModified parses the modified-date of db:list-details. Servers that
+// do not report it leave the time zero.
+func parseModified(value string) time.Time {
+	modified, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return modified
+}
@@ -0,0 +1,293 @@
+package basex
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Codes of the BaseX client/server protocol. Commands without a code are
+// sent as plain strings.
+const (
+	codeQuery   byte = 0x00
+	codeClose   byte = 0x02
+	codeBind    byte = 0x03
+	codeResults byte = 0x04
+	codeExecute byte = 0x05
+	codeReplace byte = 0x0c
+	codeStore   byte = 0x0d
+)
+
+// ItemType is the type of a query result item as reported by the protocol
+type ItemType byte
+
+// Item types of the client/server protocol. Atomic types have ids of
+// TypeItem and above.
+const (
+	TypeFunction  ItemType = 7
+	TypeNode      ItemType = 8
+	TypeText      ItemType = 9
+	TypePI        ItemType = 10
+	TypeElement   ItemType = 11
+	TypeDocument  ItemType = 12
+	TypeAttribute ItemType = 14
+	TypeComment   ItemType = 15
+	TypeItem      ItemType = 32
+)
+
+// IsNode reports whether items of the type are nodes
+func (t ItemType) IsNode() bool {
+	return t >= TypeNode && t < TypeItem
+}
+
+// session is an authenticated connection to a BaseX server speaking the
+// client/server protocol on port 1984
+type session struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+
+	// database is the database opened in the session, valid if known is set
+	database string
+	known    bool
+	// dirty sessions changed options that later queries must not inherit
+	dirty bool
+}
+
+// dialSession connects to address with dialer, if not nil, and logs in
+func dialSession(ctx context.Context, dialer *net.Dialer, address, username, password string) (*session, error) {
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to BaseX: %w", err)
+	}
+	s := &session{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), known: true}
+
+	// Bound the login by the dial context
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if err := s.login(username, password); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return s, nil
+}
+
+// login answers the greeting of the server. BaseX sends "realm:nonce" for
+// digest authentication and a bare timestamp for the legacy CRAM-MD5 scheme.
+func (s *session) login(username, password string) error {
+	greeting, err := s.readString()
+	if err != nil {
+		return fmt.Errorf("failed to read BaseX greeting: %w", err)
+	}
+
+	var hash string
+	if realm, nonce, ok := strings.Cut(greeting, ":"); ok {
+		hash = md5Hex(md5Hex(username+":"+realm+":"+password) + nonce)
+	} else {
+		hash = md5Hex(md5Hex(password) + greeting)
+	}
+
+	s.writeString(username)
+	s.writeString(hash)
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("failed to log in to BaseX: %w", err)
+	}
+	if ok, err := s.readStatus(); err != nil {
+		return fmt.Errorf("failed to log in to BaseX: %w", err)
+	} else if !ok {
+		return newProtocolError("login", "Access denied.")
+	}
+	return nil
+}
+
+// command runs a command and returns its result
+func (s *session) command(op, command string) ([]byte, error) {
+	s.writeString(command)
+	if err := s.w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", op, err)
+	}
+
+	result, err := s.readData(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", op, err)
+	}
+	info, err := s.readString()
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", op, err)
+	}
+	ok, err := s.readStatus()
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", op, err)
+	}
+	if !ok {
+		return nil, newProtocolError(op, info)
+	}
+	return result, nil
+}
+
+// exec sends a protocol code with its arguments and returns the result
+func (s *session) exec(op string, code byte, args ...string) ([]byte, error) {
+	_ = s.w.WriteByte(code)
+	for _, arg := range args {
+		s.writeString(arg)
+	}
+	if err := s.w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", op, err)
+	}
+
+	result, err := s.readData(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", op, err)
+	}
+	if err := s.readResult(op); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// send stores content at path with the REPLACE or STORE code
+func (s *session) send(op string, code byte, path string, content io.Reader) error {
+	_ = s.w.WriteByte(code)
+	s.writeString(path)
+	if err := s.writeData(content); err != nil {
+		return fmt.Errorf("failed to %s: %w", op, err)
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("failed to %s: %w", op, err)
+	}
+
+	info, err := s.readString()
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", op, err)
+	}
+	ok, err := s.readStatus()
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", op, err)
+	}
+	if !ok {
+		return newProtocolError(op, info)
+	}
+	return nil
+}
+
+// readResult reads the status byte after a result and, on failure, the
+// error message
+func (s *session) readResult(op string) error {
+	ok, err := s.readStatus()
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", op, err)
+	}
+	if ok {
+		return nil
+	}
+	message, err := s.readString()
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", op, err)
+	}
+	return newProtocolError(op, message)
+}
+
+// close ends the session
+func (s *session) close() error {
+	return s.conn.Close()
+}
+
+// writeString writes a zero-terminated string
+func (s *session) writeString(value string) {
+	_, _ = s.w.WriteString(value)
+	_ = s.w.WriteByte(0)
+}
+
+// writeData writes content with 0x00 and 0xFF escaped by 0xFF, followed by
+// the terminating zero
+func (s *session) writeData(content io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := content.Read(buf)
+		for _, b := range buf[:n] {
+			if b == 0x00 || b == 0xff {
+				_ = s.w.WriteByte(0xff)
+			}
+			_ = s.w.WriteByte(b)
+		}
+		if err == io.EOF {
+			return s.w.WriteByte(0)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readString reads a zero-terminated string that is not escaped
+func (s *session) readString() (string, error) {
+	value, err := s.r.ReadString(0)
+	if err != nil {
+		return "", err
+	}
+	return value[:len(value)-1], nil
+}
+
+// readData reads an escaped zero-terminated result. It is written to w if
+// w is set and returned otherwise.
+func (s *session) readData(w io.Writer) ([]byte, error) {
+	var data []byte
+	buf := make([]byte, 0, 4096)
+	flush := func() error {
+		if w != nil {
+			_, err := w.Write(buf)
+			buf = buf[:0]
+			return err
+		}
+		data = append(data, buf...)
+		buf = buf[:0]
+		return nil
+	}
+
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			err := flush()
+			return data, err
+		}
+		if b == 0xff {
+			if b, err = s.r.ReadByte(); err != nil {
+				return nil, err
+			}
+		}
+		buf = append(buf, b)
+		if len(buf) == cap(buf) {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// readStatus reads the status byte of a response
+func (s *session) readStatus() (bool, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	return b == 0, nil
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,255 @@
+package basex
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Query is an XQuery with its external variable bindings and serialization
+type Query struct {
+	Text      string
+	Variables []Variable
+	// Serialization is optional; BaseX defaults apply without it
+	Serialization *Serialization
+}
+
+// Variable binds a value to an external variable of a query
+type Variable struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+	// Type is the XQuery type the value is cast to, e.g. xs:integer
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// Parameter is a serialization parameter or database option of a query
+type Parameter struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// Serialization holds the serialization parameters and database options
+// sent with a query
+type Serialization struct {
+	Parameters []Parameter
+	Options    []Parameter
+}
+
+// serializationMethodFormats maps serialization methods to MIME types
+var serializationMethodFormats = map[string]string{
+	"xml":      "application/xml",
+	"xhtml":    "application/xhtml+xml",
+	"html":     "text/html",
+	"text":     "text/plain",
+	"json":     "application/json",
+	"csv":      "text/csv",
+	"adaptive": "text/plain",
+	"basex":    "text/plain",
+}
+
+// Parameter returns the value of a serialization parameter
+func (s *Serialization) Parameter(name string) string {
+	if s == nil {
+		return ""
+	}
+	for _, param := range s.Parameters {
+		if param.Name == name {
+			return param.Value
+		}
+	}
+	return ""
+}
+
+// SetMethod sets the serialization method unless one was already requested
+func (s *Serialization) SetMethod(method string) {
+	if s.Parameter("method") == "" {
+		s.Parameters = append(s.Parameters, Parameter{Name: "method", Value: method})
+	}
+}
+
+// Format returns the MIME type of the serialized result
+func (s *Serialization) Format() string {
+	if mediaType := s.Parameter("media-type"); mediaType != "" {
+		return mediaType
+	}
+	if format, ok := serializationMethodFormats[s.Parameter("method")]; ok {
+		return format
+	}
+	return "application/xml"
+}
+
+// queryEnvelope is the request body of a BaseX REST query
+type queryEnvelope struct {
+	XMLName    xml.Name    `xml:"http://basex.org/rest query"`
+	Text       string      `xml:"text"`
+	Parameters []Parameter `xml:"parameter"`
+	Options    []Parameter `xml:"option"`
+	Variables  []Variable  `xml:"variable"`
+}
+
+// commandEnvelope is the request body of a BaseX REST command
+type commandEnvelope struct {
+	XMLName xml.Name `xml:"http://basex.org/rest command"`
+	Text    string   `xml:"text"`
+}
+
+// buildQueryEnvelope serializes a query, its variable bindings and its
+// serialization settings. The query text is escaped rather than wrapped in
+// CDATA so it may contain "]]>".
+func buildQueryEnvelope(text string, query *Query) ([]byte, error) {
+	envelope := queryEnvelope{Text: text, Variables: query.Variables}
+	if query.Serialization != nil {
+		envelope.Parameters = query.Serialization.Parameters
+		envelope.Options = query.Serialization.Options
+	}
+	return xml.Marshal(envelope)
+}
+
+// Query runs a query via POST /rest/{db} and returns its serialized result
+func (c *RESTClient) Query(ctx context.Context, db string, query *Query) ([]byte, error) {
+	body, err := c.QueryStream(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = body.Close() }()
+
+	result, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query result: %w", err)
+	}
+
+	return result, nil
+}
+
+// QueryStream runs a query via POST /rest/{db} and returns the unread result.
+// The query is tagged with a job marker so it can be found among the BaseX
+// jobs and stopped when ctx ends before the result has been closed.
+func (c *RESTClient) QueryStream(ctx context.Context, db string, query *Query) (io.ReadCloser, error) {
+	marker, err := newJobMarker()
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		c.stopJob(marker)
+	})
+
+	body, err := c.postQuery(ctx, db, "(: "+marker+" :)\n"+query.Text, query)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	return &jobBody{ReadCloser: body, stop: stop}, nil
+}
+
+// postQuery sends the query text with the bindings of query and returns the
+// unread response body
+func (c *RESTClient) postQuery(ctx context.Context, db, text string, query *Query) (io.ReadCloser, error) {
+	envelope, err := buildQueryEnvelope(text, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query request: %w", err)
+	}
+
+	// BaseX reports query errors before it starts serializing the result
+	resp, err := c.do(ctx, "query", "POST", c.restURL(db), bytes.NewReader(envelope), "application/xml")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Command runs a BaseX command via POST /rest and returns its output
+func (c *RESTClient) Command(ctx context.Context, command string) ([]byte, error) {
+	envelope, err := xml.Marshal(commandEnvelope{Text: command})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build command request: %w", err)
+	}
+
+	resp, err := c.do(ctx, "command", "POST", c.restURL(""), bytes.NewReader(envelope), "application/xml")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	output, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command output: %w", err)
+	}
+	return output, nil
+}
+
+// stopJobTimeout bounds the request that stops a cancelled BaseX job
+const stopJobTimeout = 10 * time.Second
+
+// stopJobQuery stops the running jobs whose query text contains the marker.
+// The marker is bound as a variable so this query does not match itself.
+const stopJobQuery = `declare variable $marker external;
+for $job in jobs:list-details()[contains(., $marker)]
+return jobs:stop($job/@id)`
+
+// getDocumentQuery returns the content type and the content of a resource,
+// or nothing if it does not exist. Binary resources are read with
+// db:get-binary, or db:retrieve before BaseX 10.
+const getDocumentQuery = `declare variable $db external;
+declare variable $path external;
+if (db:exists($db) and db:exists($db, $path)) then (
+  let $type := db:content-type($db, $path)
+  return ($type, if ($type = 'application/xml') then doc($db || '/' || $path) else (
+    function-lookup(xs:QName('db:get-binary'), 2),
+    function-lookup(xs:QName('db:retrieve'), 2)
+  )[1]($db, $path))
+) else ()`
+
+// listDatabasesQuery returns the details of all databases
+const listDatabasesQuery = `<list>{ db:list-details() }</list>`
+
+// listDocumentsQuery returns the details of the resources of a database
+// whose paths start with $prefix, or nothing if the database does not exist
+const listDocumentsQuery = `declare variable $db external;
+declare variable $prefix external;
+if (db:exists($db)) then <list>{ db:list-details($db, $prefix) }</list> else ()`
+
+// newJobMarker returns a unique marker to tag a BaseX query with
+func newJobMarker() (string, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to create job marker: %w", err)
+	}
+	return "basexservice-job:" + hex.EncodeToString(id), nil
+}
+
+// jobBody is the result of a tagged query. Closing it means the result has
+// been consumed, so the job no longer needs to be stopped.
+type jobBody struct {
+	io.ReadCloser
+	stop func() bool
+	once sync.Once
+}
+
+func (b *jobBody) Close() error {
+	b.once.Do(func() { b.stop() })
+	return b.ReadCloser.Close()
+}
+
+// stopJob stops the BaseX jobs tagged with marker. It runs after the request
+// context has ended and therefore uses a context of its own.
+func (c *RESTClient) stopJob(marker string) {
+	ctx, cancel := context.WithTimeout(context.Background(), stopJobTimeout)
+	defer cancel()
+
+	query := &Query{Variables: []Variable{{Name: "marker", Value: marker, Type: "xs:string"}}}
+	body, err := c.postQuery(ctx, "", stopJobQuery, query)
+	if err != nil {
+		if c.onError != nil {
+			c.onError(fmt.Errorf("failed to stop BaseX job %s: %w", marker, err))
+		}
+		return
+	}
+	_, _ = io.Copy(io.Discard, body)
+	_ = body.Close()
+}
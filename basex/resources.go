@@ -0,0 +1,59 @@
+package basex
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// CreateDatabase creates a database via PUT /rest/{db}
+func (c *RESTClient) CreateDatabase(ctx context.Context, name string) error {
+	return c.exec(ctx, "create database", "PUT", c.restURL(name), nil, "")
+}
+
+// DropDatabase deletes a database via DELETE /rest/{db}
+func (c *RESTClient) DropDatabase(ctx context.Context, name string) error {
+	return c.exec(ctx, "delete database", "DELETE", c.restURL(name), nil, "")
+}
+
+// PutDocument stores a resource via PUT /rest/{db}/{path}
+func (c *RESTClient) PutDocument(ctx context.Context, db, path string, content io.Reader, contentType string) error {
+	return c.exec(ctx, "upload", "PUT", c.restURL(db+"/"+path), content, contentType)
+}
+
+// DeleteDocument deletes a resource via DELETE /rest/{db}/{path}
+func (c *RESTClient) DeleteDocument(ctx context.Context, db, path string) error {
+	return c.exec(ctx, "delete document", "DELETE", c.restURL(db+"/"+path), nil, "")
+}
+
+// GetDocument reads a resource via GET /rest/{db}/{path}
+func (c *RESTClient) GetDocument(ctx context.Context, db, path string) (*Document, error) {
+	resp, err := c.do(ctx, "get document", "GET", c.restURL(db+"/"+path), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Document{ContentType: resp.Header.Get("Content-Type"), Body: resp.Body}, nil
+}
+
+// ListDatabases lists the databases with db:list-details
+func (c *RESTClient) ListDatabases(ctx context.Context) ([]DatabaseInfo, error) {
+	result, err := c.Query(ctx, "", &Query{Text: listDatabasesQuery})
+	if err != nil {
+		return nil, err
+	}
+	return parseDatabases(result)
+}
+
+// ListDocuments lists the resources of a database with db:list-details
+func (c *RESTClient) ListDocuments(ctx context.Context, db, prefix string) ([]DocumentInfo, error) {
+	result, err := c.Query(ctx, "", listDocuments(db, prefix))
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(result)) == 0 {
+		return nil, newError("list documents", http.StatusNotFound, fmt.Sprintf("Database '%s' was not found.", db))
+	}
+	return parseDocuments(result, prefix)
+}
@@ -0,0 +1,639 @@
+package basex
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPort is the port of the BaseX client/server protocol
+const DefaultPort = "1984"
+
+// DefaultMaxIdleSessions is the number of idle sessions kept per server
+// and user unless Config.MaxIdleSessions says otherwise
+const DefaultMaxIdleSessions = 8
+
+// sessionIdleTimeout closes pooled sessions the server may have dropped
+const sessionIdleTimeout = 5 * time.Minute
+
+// SessionClient implements Client on top of the BaseX client/server
+// protocol. It does not need the BaseX HTTP server. Sessions are pooled per
+// server and user and shared by all clients.
+type SessionClient struct {
+	pool    *sessionPool
+	onError func(err error)
+}
+
+// NewSessionClient returns a client for a basex://host:port URL
+func NewSessionClient(config Config) (*SessionClient, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BaseX URL %q: %w", config.URL, err)
+	}
+	if u.Scheme != "basex" || u.Host == "" {
+		return nil, fmt.Errorf("invalid BaseX URL %q: want basex://host:port", config.URL)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), DefaultPort)
+	}
+
+	return &SessionClient{
+		pool:    sharedPool(config.Dialer, address, config.Username, config.Password, config.MaxIdleSessions),
+		onError: config.OnBackgroundError,
+	}, nil
+}
+
+var _ Client = (*SessionClient)(nil)
+
+// CreateDatabase creates a database with CREATE DB
+func (c *SessionClient) CreateDatabase(ctx context.Context, name string) error {
+	return c.withSession(ctx, "create database", func(s *session) error {
+		if _, err := s.command("create database", "CREATE DB "+commandArg(name)); err != nil {
+			return err
+		}
+		s.database, s.known = name, true
+		return nil
+	})
+}
+
+// DropDatabase deletes a database with DROP DB
+func (c *SessionClient) DropDatabase(ctx context.Context, name string) error {
+	return c.withSession(ctx, "delete database", func(s *session) error {
+		// BaseX refuses to drop a database opened by the session itself
+		if err := s.use("delete database", ""); err != nil {
+			return err
+		}
+		_, err := s.command("delete database", "DROP DB "+commandArg(name))
+		return err
+	})
+}
+
+// PutDocument stores a resource. XML and JSON content replaces the
+// document at path; other content types are stored as raw resources.
+func (c *SessionClient) PutDocument(ctx context.Context, db, path string, content io.Reader, contentType string) error {
+	return c.withSession(ctx, "upload", func(s *session) error {
+		if err := s.use("upload", db); err != nil {
+			return err
+		}
+		switch {
+		case strings.Contains(contentType, "json"):
+			if _, err := s.command("upload", "SET PARSER json"); err != nil {
+				return err
+			}
+			err := s.send("upload", codeReplace, path, content)
+			if _, resetErr := s.command("upload", "SET PARSER xml"); resetErr != nil {
+				s.dirty = true
+			}
+			return err
+		case contentType == "" || strings.Contains(contentType, "xml"):
+			return s.send("upload", codeReplace, path, content)
+		default:
+			return s.send("upload", codeStore, path, content)
+		}
+	})
+}
+
+// DeleteDocument deletes a resource with DELETE
+func (c *SessionClient) DeleteDocument(ctx context.Context, db, path string) error {
+	return c.withSession(ctx, "delete document", func(s *session) error {
+		if err := s.use("delete document", db); err != nil {
+			return err
+		}
+		_, err := s.command("delete document", "DELETE "+commandArg(path))
+		return err
+	})
+}
+
+// GetDocument reads a resource with a query returning its content type and
+// content
+func (c *SessionClient) GetDocument(ctx context.Context, db, path string) (*Document, error) {
+	results, err := c.Iterate(ctx, "", &Query{
+		Text: getDocumentQuery,
+		Variables: []Variable{
+			{Name: "db", Value: db, Type: "xs:string"},
+			{Name: "path", Value: path, Type: "xs:string"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = results.Close() }()
+
+	var items [][]byte
+	for results.Next() {
+		items = append(items, results.Item().Value)
+	}
+	if err := results.Err(); err != nil {
+		return nil, err
+	}
+	if len(items) != 2 {
+		return nil, newProtocolError("get document", fmt.Sprintf("Resource '%s/%s' was not found.", db, path))
+	}
+	return &Document{ContentType: string(items[0]), Body: io.NopCloser(bytes.NewReader(items[1]))}, nil
+}
+
+// ListDatabases lists the databases with db:list-details
+func (c *SessionClient) ListDatabases(ctx context.Context) ([]DatabaseInfo, error) {
+	result, err := c.Query(ctx, "", &Query{Text: listDatabasesQuery})
+	if err != nil {
+		return nil, err
+	}
+	return parseDatabases(result)
+}
+
+// ListDocuments lists the resources of a database with db:list-details
+func (c *SessionClient) ListDocuments(ctx context.Context, db, prefix string) ([]DocumentInfo, error) {
+	result, err := c.Query(ctx, "", listDocuments(db, prefix))
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(result)) == 0 {
+		return nil, newProtocolError("list documents", fmt.Sprintf("Database '%s' was not found.", db))
+	}
+	return parseDocuments(result, prefix)
+}
+
+// Query runs a query and returns its serialized result
+func (c *SessionClient) Query(ctx context.Context, db string, query *Query) ([]byte, error) {
+	body, err := c.QueryStream(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = body.Close() }()
+
+	result, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// QueryStream runs a query and returns its items as they arrive, separated
+// by the item-separator serialization parameter. Without one, adjacent
+// atomic values are separated by a space.
+func (c *SessionClient) QueryStream(ctx context.Context, db string, query *Query) (io.ReadCloser, error) {
+	results, err := c.Iterate(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read ahead to the first item so errors raised before the result is
+	// serialized are reported here, as by the REST API
+	reader := &resultReader{results: results, separator: query.Serialization.Parameter("item-separator")}
+	if reader.more = results.Next(); !reader.more && results.Err() != nil {
+		_ = results.Close()
+		return nil, results.Err()
+	}
+	return reader, nil
+}
+
+// Iterate runs a query and returns an iterator over its items. The session
+// running the query is held until the results are closed. The query is
+// tagged with a job marker so it can be stopped when ctx ends before the
+// results have been closed.
+func (c *SessionClient) Iterate(ctx context.Context, db string, query *Query) (*Results, error) {
+	marker, err := newJobMarker()
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Closing the connection interrupts the client; the job keeps running
+	// in BaseX until it is stopped
+	stop := context.AfterFunc(ctx, func() {
+		_ = s.close()
+		c.stopJob(marker)
+	})
+
+	id, err := s.prepare(db, "(: "+marker+" :)\n"+query.Text, query)
+	if err == nil {
+		_ = s.w.WriteByte(codeResults)
+		s.writeString(id)
+		if flushErr := s.w.Flush(); flushErr != nil {
+			err = fmt.Errorf("failed to query: %w", flushErr)
+		}
+	}
+	if err != nil {
+		if !stop() {
+			return nil, fmt.Errorf("failed to query: %w", ctx.Err())
+		}
+		c.pool.put(s, err)
+		return nil, err
+	}
+
+	return &Results{ctx: ctx, pool: c.pool, session: s, id: id, stop: stop}, nil
+}
+
+// Command runs a BaseX command and returns its output
+func (c *SessionClient) Command(ctx context.Context, command string) ([]byte, error) {
+	var output []byte
+	err := c.withSession(ctx, "command", func(s *session) error {
+		// Commands may open or close databases
+		s.known = false
+		var err error
+		output, err = s.command("command", command)
+		return err
+	})
+	return output, err
+}
+
+// Transform runs the pipeline as a single query against db
+func (c *SessionClient) Transform(ctx context.Context, db string, transform *Transform) (*TransformResult, error) {
+	return RunTransform(ctx, c, db, transform)
+}
+
+// withSession runs fn on a pooled session. The session is closed if ctx
+// ends first, which interrupts fn.
+func (c *SessionClient) withSession(ctx context.Context, op string, fn func(s *session) error) error {
+	s, err := c.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = s.close() })
+
+	err = fn(s)
+	if !stop() {
+		return fmt.Errorf("failed to %s: %w", op, ctx.Err())
+	}
+	c.pool.put(s, err)
+	return err
+}
+
+// stopJob stops the BaseX jobs tagged with marker. It runs after the query
+// context has ended and therefore uses a context of its own.
+func (c *SessionClient) stopJob(marker string) {
+	ctx, cancel := context.WithTimeout(context.Background(), stopJobTimeout)
+	defer cancel()
+
+	query := &Query{Variables: []Variable{{Name: "marker", Value: marker, Type: "xs:string"}}}
+	err := c.withSession(ctx, "stop job", func(s *session) error {
+		id, err := s.prepare("", stopJobQuery, query)
+		if err != nil {
+			return err
+		}
+		_, err = s.exec("stop job", codeExecute, id)
+		_, _ = s.exec("stop job", codeClose, id)
+		return err
+	})
+	if err != nil && c.onError != nil {
+		c.onError(fmt.Errorf("failed to stop BaseX job %s: %w", marker, err))
+	}
+}
+
+// use opens db in the session, or closes the open database if db is empty
+func (s *session) use(op, db string) error {
+	if s.known && s.database == db {
+		return nil
+	}
+	command := "CLOSE"
+	if db != "" {
+		command = "OPEN " + commandArg(db)
+	}
+	s.known = false
+	if _, err := s.command(op, command); err != nil {
+		return err
+	}
+	s.database, s.known = db, true
+	return nil
+}
+
+// prepare opens db, applies the serialization of query, registers text as
+// query and binds its variables. It returns the query id.
+func (s *session) prepare(db, text string, query *Query) (string, error) {
+	if err := s.use("query", db); err != nil {
+		return "", err
+	}
+
+	// The item separator is applied by the client, which receives the
+	// items one by one
+	var params []string
+	if query.Serialization != nil {
+		for _, param := range query.Serialization.Parameters {
+			if param.Name != "item-separator" {
+				params = append(params, param.Name+"="+strings.ReplaceAll(param.Value, ",", ",,"))
+			}
+		}
+	}
+	if _, err := s.command("query", "SET SERIALIZER "+commandArg(strings.Join(params, ","))); err != nil {
+		return "", err
+	}
+	if query.Serialization != nil {
+		for _, option := range query.Serialization.Options {
+			s.dirty = true
+			if _, err := s.command("query", "SET "+option.Name+" "+commandArg(option.Value)); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	id, err := s.exec("query", codeQuery, text)
+	if err != nil {
+		return "", err
+	}
+	for _, variable := range query.Variables {
+		if _, err := s.exec("query", codeBind, string(id), variable.Name, variable.Value, variable.Type); err != nil {
+			_, _ = s.exec("query", codeClose, string(id))
+			return "", err
+		}
+	}
+	return string(id), nil
+}
+
+// commandArg quotes a command argument unless it is a plain name
+func commandArg(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\r\n\"") {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// Item is an item of a query result, serialized as requested by the query
+type Item struct {
+	Type  ItemType
+	Value []byte
+}
+
+// Results iterates over the items of a query result:
+//
+//	results, err := client.Iterate(ctx, "db", query)
+//	if err != nil { ... }
+//	defer results.Close()
+//	for results.Next() {
+//		item := results.Item()
+//		...
+//	}
+//	if err := results.Err(); err != nil { ... }
+type Results struct {
+	ctx     context.Context
+	pool    *sessionPool
+	session *session
+	id      string
+	stop    func() bool
+
+	item Item
+	err  error
+	done bool
+}
+
+// Next reads the next item. It returns false at the end of the result or
+// on error.
+func (r *Results) Next() bool {
+	if r.done {
+		return false
+	}
+
+	itemType, err := r.session.r.ReadByte()
+	if err == nil && itemType == 0 {
+		// The end of the result is followed by the status of the query
+		if err = r.session.readResult("query"); err == nil {
+			_, err = r.session.exec("query", codeClose, r.id)
+		}
+		r.finish(err)
+		return false
+	}
+
+	var value []byte
+	if err == nil {
+		value, err = r.session.readData(nil)
+	}
+	if err != nil {
+		r.finish(fmt.Errorf("failed to read query result: %w", err))
+		return false
+	}
+	r.item = Item{Type: ItemType(itemType), Value: value}
+	return true
+}
+
+// Item returns the current item
+func (r *Results) Item() Item {
+	return r.item
+}
+
+// Err returns the error that ended the iteration, if any
+func (r *Results) Err() error {
+	return r.err
+}
+
+// Close releases the session. Closing the results before the last item has
+// been read discards the session, whose connection is still receiving them.
+func (r *Results) Close() error {
+	if !r.done {
+		r.done = true
+		if r.stop() {
+			_ = r.session.close()
+		}
+	}
+	return nil
+}
+
+// finish ends the iteration and returns the session to the pool
+func (r *Results) finish(err error) {
+	r.done = true
+	if !r.stop() {
+		// ctx ended and the session has been closed
+		r.err = fmt.Errorf("failed to query: %w", r.ctx.Err())
+		return
+	}
+	r.err = err
+	r.pool.put(r.session, err)
+}
+
+// resultReader concatenates the items of a result
+type resultReader struct {
+	results   *Results
+	separator string
+	// more is set while the current item has not been read
+	more    bool
+	pending []byte
+	last    ItemType
+	started bool
+}
+
+func (r *resultReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if !r.more {
+			if r.more = r.results.Next(); !r.more {
+				if err := r.results.Err(); err != nil {
+					return 0, err
+				}
+				return 0, io.EOF
+			}
+		}
+		item := r.results.Item()
+		r.more = false
+
+		var buf bytes.Buffer
+		if r.started {
+			switch {
+			case r.separator != "":
+				buf.WriteString(r.separator)
+			case !item.Type.IsNode() && !r.last.IsNode():
+				buf.WriteByte(' ')
+			}
+		}
+		buf.Write(item.Value)
+		r.pending, r.last, r.started = buf.Bytes(), item.Type, true
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *resultReader) Close() error {
+	return r.results.Close()
+}
+
+// sessionPool keeps idle sessions to one server for one user
+type sessionPool struct {
+	dialer   *net.Dialer
+	address  string
+	username string
+	password string
+	maxIdle  int
+
+	mu     sync.Mutex
+	idle   []idleSession
+	used   time.Time
+	closed bool
+}
+
+type idleSession struct {
+	session *session
+	since   time.Time
+}
+
+// poolKey identifies the pool of a dialer, server and user. The server and
+// user are hashed, so the map of pools does not hold the passwords.
+type poolKey struct {
+	dialer *net.Dialer
+	hash   [sha256.Size]byte
+}
+
+func newPoolKey(dialer *net.Dialer, address, username, password string) poolKey {
+	return poolKey{dialer: dialer, hash: sha256.Sum256([]byte(address + "\x00" + username + "\x00" + password))}
+}
+
+// maxSessionPools bounds the pools kept for different servers and users.
+// Beyond it the least recently used pools are closed.
+var maxSessionPools = 64
+
+var (
+	poolsMu sync.Mutex
+	pools   = make(map[poolKey]*sessionPool)
+)
+
+// sharedPool returns the pool of a dialer, server and user. maxIdle applies
+// when the pool is created. Pools unused for sessionIdleTimeout are closed.
+func sharedPool(dialer *net.Dialer, address, username, password string, maxIdle int) *sessionPool {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	now := time.Now()
+	key := newPoolKey(dialer, address, username, password)
+	pool, ok := pools[key]
+	if !ok {
+		if maxIdle <= 0 {
+			maxIdle = DefaultMaxIdleSessions
+		}
+		pool = &sessionPool{dialer: dialer, address: address, username: username, password: password, maxIdle: maxIdle}
+		pools[key] = pool
+	}
+	pool.touch(now)
+	evictPools(now)
+	return pool
+}
+
+// evictPools closes the pools unused for sessionIdleTimeout and the least
+// recently used ones beyond maxSessionPools. poolsMu must be held.
+func evictPools(now time.Time) {
+	for key, pool := range pools {
+		if now.Sub(pool.lastUsed()) >= sessionIdleTimeout {
+			delete(pools, key)
+			pool.close()
+		}
+	}
+	for len(pools) > maxSessionPools {
+		var oldestKey poolKey
+		var oldest *sessionPool
+		for key, pool := range pools {
+			if oldest == nil || pool.lastUsed().Before(oldest.lastUsed()) {
+				oldestKey, oldest = key, pool
+			}
+		}
+		delete(pools, oldestKey)
+		oldest.close()
+	}
+}
+
+// touch records that the pool is in use
+func (p *sessionPool) touch(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.used = now
+}
+
+func (p *sessionPool) lastUsed() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.used
+}
+
+// close closes the idle sessions. Clients still holding the pool log in
+// new sessions, which are closed after use.
+func (p *sessionPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, idle := range p.idle {
+		_ = idle.session.close()
+	}
+	p.idle, p.closed = nil, true
+}
+
+// get returns an idle session or logs in a new one
+func (p *sessionPool) get(ctx context.Context) (*session, error) {
+	p.mu.Lock()
+	for len(p.idle) > 0 {
+		last := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if time.Since(last.since) < sessionIdleTimeout {
+			p.mu.Unlock()
+			return last.session, nil
+		}
+		_ = last.session.close()
+	}
+	p.mu.Unlock()
+
+	return dialSession(ctx, p.dialer, p.address, p.username, p.password)
+}
+
+// put returns a session after use. Sessions with changed options, broken
+// connections or beyond the idle limit are closed.
+func (p *sessionPool) put(s *session, err error) {
+	var basexErr *Error
+	if s.dirty || (err != nil && !errors.As(err, &basexErr)) {
+		_ = s.close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= p.maxIdle {
+		_ = s.close()
+		return
+	}
+	p.used = time.Now()
+	p.idle = append(p.idle, idleSession{session: s, since: p.used})
+}
@@ -0,0 +1,320 @@
+package basex_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"basexservice.evalgo.org/basex"
+	"basexservice.evalgo.org/basex/basextest"
+)
+
+func TestSessionDatabasesAndDocuments(t *testing.T) {
+	server := basextest.NewProtocolServer(t)
+	client := server.Client()
+	ctx := context.Background()
+
+	if err := client.CreateDatabase(ctx, "db"); err != nil {
+		t.Fatalf("CreateDatabase: %v", err)
+	}
+	if err := client.PutDocument(ctx, "db", "a/doc.xml", strings.NewReader("<doc/>"), "application/xml"); err != nil {
+		t.Fatalf("PutDocument: %v", err)
+	}
+	binary := []byte{0x00, 0xff, 'x', 0x00}
+	if err := client.PutDocument(ctx, "db", "raw.bin", bytes.NewReader(binary), "application/octet-stream"); err != nil {
+		t.Fatalf("PutDocument binary: %v", err)
+	}
+	if resource, ok := server.Resource("db", "raw.bin"); !ok || !bytes.Equal(resource.Content, binary) {
+		t.Fatalf("binary resource = %+v, want escaped bytes to round-trip", resource)
+	}
+	if err := client.PutDocument(ctx, "db", "bad.xml", strings.NewReader("<doc>"), "application/xml"); err == nil {
+		t.Fatal("PutDocument accepted malformed XML")
+	}
+
+	doc, err := client.GetDocument(ctx, "db", "raw.bin")
+	if err != nil {
+		t.Fatalf("GetDocument: %v", err)
+	}
+	content, _ := io.ReadAll(doc.Body)
+	_ = doc.Body.Close()
+	if doc.ContentType != "application/octet-stream" || !bytes.Equal(content, binary) {
+		t.Errorf("GetDocument = %s %q, want the stored bytes", doc.ContentType, content)
+	}
+	if doc, err = client.GetDocument(ctx, "db", "a/doc.xml"); err != nil || doc.ContentType != "application/xml" {
+		t.Fatalf("GetDocument XML = %+v, %v", doc, err)
+	}
+	_ = doc.Body.Close()
+	if _, err := client.GetDocument(ctx, "db", "missing.xml"); !errors.Is(err, basex.ErrNotFound) {
+		t.Errorf("GetDocument of a missing resource: got %v, want ErrNotFound", err)
+	}
+
+	documents, err := client.ListDocuments(ctx, "db", "")
+	if err != nil || len(documents) != 2 || documents[0].Path != "a/doc.xml" || documents[1].Size != int64(len(binary)) {
+		t.Errorf("ListDocuments = %+v, %v", documents, err)
+	}
+	if databases, err := client.ListDatabases(ctx); err != nil || len(databases) != 1 || databases[0].Resources != 2 {
+		t.Errorf("ListDatabases = %+v, %v", databases, err)
+	}
+	if _, err := client.ListDocuments(ctx, "missing", ""); !errors.Is(err, basex.ErrNotFound) {
+		t.Errorf("ListDocuments of a missing database: got %v, want ErrNotFound", err)
+	}
+
+	if err := client.DeleteDocument(ctx, "db", "a/doc.xml"); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	if _, ok := server.Resource("db", "a/doc.xml"); ok {
+		t.Fatal("resource still stored after DeleteDocument")
+	}
+	if err := client.DropDatabase(ctx, "db"); err != nil {
+		t.Fatalf("DropDatabase: %v", err)
+	}
+	if server.HasDatabase("db") {
+		t.Fatal("database still exists after DropDatabase")
+	}
+
+	// All requests ran on one pooled session
+	if n := server.Sessions(); n != 1 {
+		t.Errorf("got %d sessions, want 1", n)
+	}
+}
+
+func TestSessionErrors(t *testing.T) {
+	server := basextest.NewProtocolServer(t)
+	ctx := context.Background()
+
+	err := server.Client().PutDocument(ctx, "missing", "doc.xml", strings.NewReader("<doc/>"), "application/xml")
+	if !errors.Is(err, basex.ErrNotFound) {
+		t.Errorf("PutDocument into missing database: got %v, want ErrNotFound", err)
+	}
+
+	denied, err := basex.NewSessionClient(basex.Config{URL: server.URL, Username: "admin", Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := denied.CreateDatabase(ctx, "db"); !errors.Is(err, basex.ErrUnauthorized) {
+		t.Errorf("CreateDatabase with wrong password: got %v, want ErrUnauthorized", err)
+	}
+
+	server.HandleQuery("1 +", func(*basextest.Query) basextest.Response {
+		return basextest.Response{Status: 400, Body: "Stopped at ., 1/4:\n[XPST0003] Incomplete expression."}
+	})
+	_, err = server.Client().Query(ctx, "", &basex.Query{Text: "1 +"})
+	var basexErr *basex.Error
+	if !errors.As(err, &basexErr) || basexErr.Code != "XPST0003" || !errors.Is(err, basex.ErrQuery) || errors.Is(err, basex.ErrNotFound) {
+		t.Errorf("Query with syntax error: got %#v", err)
+	}
+
+	if _, err := basex.NewSessionClient(basex.Config{URL: "http://localhost:8080"}); err == nil {
+		t.Error("NewSessionClient accepted an HTTP URL")
+	}
+}
+
+func TestSessionQuery(t *testing.T) {
+	server := basextest.NewProtocolServer(t)
+	server.CreateDatabase("db")
+	server.HandleQuery("$id", func(q *basextest.Query) basextest.Response {
+		return basextest.Response{Items: []string{"<concept id=\"" + q.Variable("id") + "\"/>", "<concept/>"}}
+	})
+	server.HandleQuery("1 to 3", func(*basextest.Query) basextest.Response {
+		return basextest.Response{Items: []string{"1", "2", "3"}}
+	})
+
+	client := server.Client()
+	result, err := client.Query(context.Background(), "db", &basex.Query{
+		Text:      "declare variable $id external; //concept[@id = $id]",
+		Variables: []basex.Variable{{Name: "id", Value: "c42", Type: "xs:string"}},
+		Serialization: &basex.Serialization{Parameters: []basex.Parameter{
+			{Name: "method", Value: "xml"},
+			{Name: "item-separator", Value: "\n"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if string(result) != "<concept id=\"c42\"/>\n<concept/>" {
+		t.Errorf("result = %q", result)
+	}
+	q := server.Queries()[0]
+	if q.Database != "db" || q.Variables[0].Type != "xs:string" || len(q.Parameters) != 1 || q.Parameters[0].Value != "xml" {
+		t.Errorf("query = %+v", q)
+	}
+
+	// Adjacent atomic values are separated by a space
+	result, err = client.Query(context.Background(), "", &basex.Query{Text: "1 to 3"})
+	if err != nil || string(result) != "1 2 3" {
+		t.Errorf("atomic result = %q, %v", result, err)
+	}
+	if q := server.Queries()[1]; q.Database != "" {
+		t.Errorf("query without database ran against %q", q.Database)
+	}
+}
+
+func TestSessionIterate(t *testing.T) {
+	server := basextest.NewProtocolServer(t)
+	server.HandleQuery("//item", func(*basextest.Query) basextest.Response {
+		return basextest.Response{Items: []string{"<a/>", "b\x00\xff"}}
+	})
+
+	client := server.Client().(*basex.SessionClient)
+	results, err := client.Iterate(context.Background(), "", &basex.Query{Text: "//item"})
+	if err != nil {
+		t.Fatalf("Iterate: %v", err)
+	}
+	defer func() { _ = results.Close() }()
+
+	var items []basex.Item
+	for results.Next() {
+		items = append(items, results.Item())
+	}
+	if err := results.Err(); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	if len(items) != 2 || !items[0].Type.IsNode() || items[1].Type.IsNode() || string(items[1].Value) != "b\x00\xff" {
+		t.Errorf("items = %+v", items)
+	}
+}
+
+func TestSessionQueryStreamStopsJobOnCancel(t *testing.T) {
+	server := basextest.NewProtocolServer(t)
+	release := make(chan struct{})
+	server.HandleQuery("slow", func(*basextest.Query) basextest.Response {
+		<-release
+		return basextest.Response{}
+	})
+	stopped := make(chan string, 1)
+	server.HandleQuery("jobs:stop", func(q *basextest.Query) basextest.Response {
+		stopped <- q.Variable("marker")
+		close(release)
+		return basextest.Response{}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := server.Client().Query(ctx, "", &basex.Query{Text: "slow"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Query: got %v, want deadline exceeded", err)
+	}
+
+	select {
+	case marker := <-stopped:
+		if !strings.Contains(server.Queries()[0].Text, marker) {
+			t.Errorf("stop query marker %q does not tag the cancelled query", marker)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled query was not stopped")
+	}
+}
+
+func TestSessionPoolReusesSessions(t *testing.T) {
+	server := basextest.NewProtocolServer(t)
+	server.RespondToQuery("fast", "<ok/>")
+	client := server.Client()
+
+	body, err := client.QueryStream(context.Background(), "", &basex.Query{Text: "fast"})
+	if err != nil {
+		t.Fatalf("QueryStream: %v", err)
+	}
+	// A second query while the first result is unread needs its own session
+	if _, err := client.Query(context.Background(), "", &basex.Query{Text: "fast"}); err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	_ = body.Close()
+	if string(data) != "<ok/>" {
+		t.Errorf("result = %s", data)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := client.Query(context.Background(), "", &basex.Query{Text: "fast"}); err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+	}
+	if n := server.Sessions(); n != 2 {
+		t.Errorf("got %d sessions, want the 2 pooled ones", n)
+	}
+}
+
+func TestSessionDialer(t *testing.T) {
+	server := basextest.NewProtocolServer(t)
+	server.RespondToQuery("fast", "<ok/>")
+	var dialed []string
+	dialer := &net.Dialer{Control: func(network, address string, _ syscall.RawConn) error {
+		dialed = append(dialed, address)
+		return errors.New("address not allowed")
+	}}
+
+	// A session pooled for the default dialer is not handed to the client
+	// with its own dialer
+	if _, err := server.Client().Query(context.Background(), "", &basex.Query{Text: "fast"}); err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	client, err := basex.NewSessionClient(basex.Config{URL: server.URL, Username: server.Username, Password: server.Password, Dialer: dialer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Query(context.Background(), "", &basex.Query{Text: "fast"}); err == nil || !strings.Contains(err.Error(), "address not allowed") {
+		t.Errorf("Query through the refusing dialer: %v", err)
+	}
+	if len(dialed) != 1 || dialed[0] != strings.TrimPrefix(server.URL, "basex://") {
+		t.Errorf("dialed %v", dialed)
+	}
+}
+
+func TestSessionPoolsAreEvicted(t *testing.T) {
+	defer basex.SetMaxSessionPools(1)()
+	first := basextest.NewProtocolServer(t)
+	second := basextest.NewProtocolServer(t)
+	first.RespondToQuery("fast", "<ok/>")
+	second.RespondToQuery("fast", "<ok/>")
+
+	if _, err := first.Client().Query(context.Background(), "", &basex.Query{Text: "fast"}); err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if n := first.Sessions(); n != 1 {
+		t.Fatalf("got %d sessions, want the pooled one", n)
+	}
+
+	// Using another server evicts the least recently used pool, closing
+	// its idle session
+	if _, err := second.Client().Query(context.Background(), "", &basex.Query{Text: "fast"}); err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for first.Sessions() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("evicted pool kept %d sessions open", first.Sessions())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionCommand(t *testing.T) {
+	server := basextest.NewProtocolServer(t)
+	server.RespondToQuery("LIST", "db 1 resource")
+
+	output, err := server.Client().Command(context.Background(), "LIST")
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+	if string(output) != "db 1 resource" || !server.Queries()[0].Command {
+		t.Errorf("output = %q, queries = %+v", output, server.Queries())
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if client, err := basex.Open(basex.Config{URL: "basex://localhost"}); err != nil {
+		t.Errorf("Open basex URL: %v", err)
+	} else if _, ok := client.(*basex.SessionClient); !ok {
+		t.Errorf("Open basex URL returned %T", client)
+	}
+	if client, err := basex.Open(basex.Config{URL: "http://localhost:8080"}); err != nil {
+		t.Errorf("Open HTTP URL: %v", err)
+	} else if _, ok := client.(*basex.RESTClient); !ok {
+		t.Errorf("Open HTTP URL returned %T", client)
+	}
+}
@@ -0,0 +1,159 @@
+package basex
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Transform is an XSLT pipeline run inside BaseX. Intermediate results stay
+// server-side.
+type Transform struct {
+	// Stylesheets are the database resources applied in order
+	Stylesheets []string
+	// Resource names the source document in the database. XML holds an
+	// inline source document instead.
+	Resource string
+	XML      string
+	// Params are bound to the xsl:params of every stage
+	Params map[string]Param
+	// Trace returns the output of every stage in TransformResult.Stages
+	Trace bool
+}
+
+// Param is a typed xsl:param value
+type Param struct {
+	Value string
+	// Type is the XQuery type the value is cast to, e.g. xs:integer
+	Type string
+	// Fragment parses the value as XML fragment, so node sets may have
+	// several roots. Type is ignored.
+	Fragment bool
+}
+
+// TransformResult is the output of a pipeline
+type TransformResult struct {
+	// Output is the result of the final stage, serialized as declared by
+	// its xsl:output
+	Output []byte
+	// Stages holds the output of every stage if tracing was requested
+	Stages []Stage
+}
+
+// Stage is the trace entry of one pipeline stage
+type Stage struct {
+	Position   int    `xml:"position,attr" json:"position"`
+	Stylesheet string `xml:"stylesheet,attr" json:"stylesheet"`
+	Output     string `xml:",chardata" json:"output"`
+}
+
+// Transform runs the pipeline as a single query against db
+func (c *RESTClient) Transform(ctx context.Context, db string, transform *Transform) (*TransformResult, error) {
+	return RunTransform(ctx, c, db, transform)
+}
+
+// RunTransform runs a pipeline through the Queries of any client
+func RunTransform(ctx context.Context, queries Queries, db string, transform *Transform) (*TransformResult, error) {
+	if len(transform.Stylesheets) == 0 {
+		return nil, fmt.Errorf("transform requires at least one stylesheet")
+	}
+
+	output, err := queries.Query(ctx, db, BuildTransformQuery(db, transform))
+	if err != nil {
+		return nil, err
+	}
+	if !transform.Trace {
+		return &TransformResult{Output: output}, nil
+	}
+
+	stages, err := parseTransformTrace(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transformation trace: %w", err)
+	}
+	return &TransformResult{Output: []byte(stages[len(stages)-1].Output), Stages: stages}, nil
+}
+
+// BuildTransformQuery builds the XQuery that applies the stylesheets stored
+// in db to the source document in order. Intermediate stages are kept as
+// nodes; the final stage uses xslt:transform-text so the result is serialized
+// as declared by the stylesheet's xsl:output. With trace enabled the output of
+// every stage is returned in a <trace> element. Inline XML and parameter
+// values are bound as external variables rather than spliced into the query.
+func BuildTransformQuery(db string, transform *Transform) *Query {
+	var prolog, query strings.Builder
+	var variables []Variable
+
+	input := fmt.Sprintf("doc(%s)", StringLiteral(db+"/"+transform.Resource))
+	if transform.Resource == "" {
+		prolog.WriteString("declare variable $source external;\n")
+		variables = append(variables, Variable{Name: "source", Value: transform.XML, Type: "xs:string"})
+		input = "parse-xml($source)"
+	}
+	fmt.Fprintf(&query, "let $stage0 := %s\n", input)
+
+	// Parameters are bound to every stage of the pipeline
+	names := make([]string, 0, len(transform.Params))
+	for name := range transform.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]string, 0, len(names))
+	for i, name := range names {
+		param := transform.Params[name]
+		variable := fmt.Sprintf("param%d", i+1)
+		fmt.Fprintf(&prolog, "declare variable $%s external;\n", variable)
+
+		value := "$" + variable
+		variableType := param.Type
+		if param.Fragment {
+			value = fmt.Sprintf("parse-xml-fragment($%s)", variable)
+			variableType = "xs:string"
+		}
+		variables = append(variables, Variable{Name: variable, Value: param.Value, Type: variableType})
+		entries = append(entries, fmt.Sprintf("%s: %s", StringLiteral(name), value))
+	}
+	fmt.Fprintf(&query, "let $params := map { %s }\n", strings.Join(entries, ", "))
+
+	stylesheets := transform.Stylesheets
+	last := len(stylesheets)
+	for i, stylesheet := range stylesheets[:last-1] {
+		fmt.Fprintf(&query, "let $stage%d := xslt:transform($stage%d, doc(%s), $params)\n", i+1, i, StringLiteral(db+"/"+stylesheet))
+	}
+	fmt.Fprintf(&query, "let $output := xslt:transform-text($stage%d, doc(%s), $params)\n", last-1, StringLiteral(db+"/"+stylesheets[last-1]))
+
+	if !transform.Trace {
+		query.WriteString("return $output")
+		return &Query{Text: prolog.String() + query.String(), Variables: variables}
+	}
+
+	query.WriteString("return <trace>{\n")
+	for i, stylesheet := range stylesheets[:last-1] {
+		fmt.Fprintf(&query, "  element stage { attribute position { %d }, attribute stylesheet { %s }, serialize($stage%d) },\n", i+1, StringLiteral(stylesheet), i+1)
+	}
+	fmt.Fprintf(&query, "  element stage { attribute position { %d }, attribute stylesheet { %s }, $output }\n", last, StringLiteral(stylesheets[last-1]))
+	query.WriteString("}</trace>")
+	return &Query{Text: prolog.String() + query.String(), Variables: variables}
+}
+
+// parseTransformTrace parses the <trace> element returned by a traced pipeline
+func parseTransformTrace(data []byte) ([]Stage, error) {
+	var trace struct {
+		Stages []Stage `xml:"stage"`
+	}
+	if err := xml.Unmarshal(data, &trace); err != nil {
+		return nil, err
+	}
+	if len(trace.Stages) == 0 {
+		return nil, fmt.Errorf("trace contains no stages")
+	}
+	return trace.Stages, nil
+}
+
+// StringLiteral quotes s as an XQuery string literal
+func StringLiteral(s string) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, `"`, `""`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"basexservice.evalgo.org/basex"
+)
+
+// allowlistLookupTimeout bounds the DNS lookup of hosts checked against
+// CIDR entries
+const allowlistLookupTimeout = 5 * time.Second
+
+// hostAllowlist restricts the servers basexservice sends requests to. An
+// entry is a base URL (http://basex:8080), a hostname (basex.internal or
+// *.basex.internal), or an IP address or CIDR (10.0.0.0/8).
+type hostAllowlist struct {
+	env   string
+	urls  []*url.URL
+	hosts []string
+	nets  []*net.IPNet
+}
+
+// baseXAllowlist returns the allowlist of BaseX servers named by requests,
+// from BASEX_ALLOWED_URLS. It is nil if any server is allowed.
+func baseXAllowlist() (*hostAllowlist, error) {
+	return allowlistFromEnv("BASEX_ALLOWED_URLS")
+}
+
+// s3ServiceAllowlist returns the allowlist of s3service endpoints, from
+// BASEX_ALLOWED_S3_SERVICES. It is nil if any endpoint is allowed.
+func s3ServiceAllowlist() (*hostAllowlist, error) {
+	return allowlistFromEnv("BASEX_ALLOWED_S3_SERVICES")
+}
+
+// allowlistFromEnv parses the comma-separated entries of an environment
+// variable
+func allowlistFromEnv(env string) (*hostAllowlist, error) {
+	value := os.Getenv(env)
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	list := &hostAllowlist{env: env}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case strings.Contains(entry, "://"):
+			u, err := url.Parse(entry)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("invalid URL %q in %s", entry, env)
+			}
+			list.urls = append(list.urls, u)
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q in %s", entry, env)
+			}
+			list.nets = append(list.nets, network)
+		case net.ParseIP(entry) != nil:
+			ip := net.ParseIP(entry)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			list.nets = append(list.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		default:
+			list.hosts = append(list.hosts, strings.ToLower(entry))
+		}
+	}
+	return list, nil
+}
+
+// check returns an error unless rawURL matches an entry. Hostnames checked
+// against CIDR entries must resolve to allowed addresses only. byAddress
+// reports that rawURL only matched a CIDR entry. Connections to it must then
+// be made with allowlistDialer, as the host may resolve differently by the
+// time it is connected to.
+func (l *hostAllowlist) check(rawURL string) (byAddress bool, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false, fmt.Errorf("invalid url %q", rawURL)
+	}
+	host := strings.ToLower(u.Hostname())
+
+	for _, allowed := range l.urls {
+		prefix := strings.TrimSuffix(allowed.Path, "/")
+		if strings.EqualFold(allowed.Scheme, u.Scheme) && strings.EqualFold(hostPort(allowed), hostPort(u)) &&
+			(prefix == "" || u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/")) {
+			return false, nil
+		}
+	}
+	for _, allowed := range l.hosts {
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return false, nil
+		}
+	}
+	if len(l.nets) > 0 && l.allowsAddresses(host) {
+		return true, nil
+	}
+	return false, fmt.Errorf("%s://%s is not allowed by %s", u.Scheme, u.Host, l.env)
+}
+
+// allowsAddresses reports whether host is, or resolves only to, addresses
+// within the allowed networks
+func (l *hostAllowlist) allowsAddresses(host string) bool {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), allowlistLookupTimeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return false
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		if !l.allowsIP(ip) {
+			return false
+		}
+	}
+	return len(ips) > 0
+}
+
+// allowsIP reports whether ip is within the allowed networks
+func (l *hostAllowlist) allowsIP(ip net.IP) bool {
+	for _, network := range l.nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowlistDialer connects to the BaseX servers that requests name and that
+// BASEX_ALLOWED_URLS only allows through CIDR entries. It checks every
+// address it connects to against the current entries, so a hostname that
+// resolves to another address after it was checked, e.g. through DNS
+// rebinding, cannot reach other networks.
+var allowlistDialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+	Control:   controlAllowedAddress,
+}
+
+// allowlistHTTPClient sends REST requests through allowlistDialer. It uses
+// no proxy, as the address checked must be that of the server.
+var allowlistHTTPClient = func() *http.Client {
+	transport := basex.NewTransport()
+	transport.Proxy = nil
+	transport.DialContext = allowlistDialer.DialContext
+	return &http.Client{Transport: transport}
+}()
+
+// controlAllowedAddress refuses connections to addresses outside the CIDR
+// entries of BASEX_ALLOWED_URLS
+func controlAllowedAddress(network, address string, _ syscall.RawConn) error {
+	list, err := baseXAllowlist()
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if list == nil || !list.allowsIP(net.ParseIP(host)) {
+		return fmt.Errorf("address %s is not allowed by BASEX_ALLOWED_URLS", host)
+	}
+	return nil
+}
+
+// hostPort returns the host and port of u, filling in the default port of
+// the scheme
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return net.JoinHostPort(u.Hostname(), "80")
+	case "https":
+		return net.JoinHostPort(u.Hostname(), "443")
+	case "basex":
+		return net.JoinHostPort(u.Hostname(), "1984")
+	}
+	return u.Host
+}
+
+// checkAllowed checks rawURL against an allowlist, which may be nil
+func checkAllowed(allowlist func() (*hostAllowlist, error), rawURL string) error {
+	list, err := allowlist()
+	if err != nil || list == nil {
+		return err
+	}
+	_, err = list.check(rawURL)
+	return err
+}
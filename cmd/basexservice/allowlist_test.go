@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"basexservice.evalgo.org/basex"
+	"basexservice.evalgo.org/basex/basextest"
+	"eve.evalgo.org/semantic"
+)
+
+func TestHostAllowlist(t *testing.T) {
+	t.Setenv("BASEX_ALLOWED_URLS", "http://basex:8080/rest, basex://iqs, *.basex.internal, localhost, 10.0.0.0/8, 192.168.1.5")
+	list, err := baseXAllowlist()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, allowed := range []string{
+		"http://basex:8080/rest",
+		"http://BASEX:8080/rest/db",
+		"basex://iqs:1984",
+		"http://prod.basex.internal:8080",
+		"http://localhost:9999",
+		"http://10.1.2.3:8080",
+		"basex://192.168.1.5",
+	} {
+		if _, err := list.check(allowed); err != nil {
+			t.Errorf("%s: %v", allowed, err)
+		}
+	}
+	for _, rejected := range []string{
+		"http://basex:8081/rest",
+		"https://basex:8080/rest",
+		"http://basex:8080/restricted",
+		"basex://iqs:1985",
+		"http://basex.internal.evil.com",
+		"http://169.254.169.254/latest/meta-data",
+		"http://192.168.1.6",
+		"not a url",
+	} {
+		if _, err := list.check(rejected); err == nil {
+			t.Errorf("%s was allowed", rejected)
+		}
+	}
+
+	t.Setenv("BASEX_ALLOWED_URLS", "10.0.0.0/33")
+	if _, err := baseXAllowlist(); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+	t.Setenv("BASEX_ALLOWED_URLS", "")
+	if list, err := baseXAllowlist(); list != nil || err != nil {
+		t.Errorf("empty allowlist = %v, %v", list, err)
+	}
+}
+
+func TestAllowlistRejectsRequestURLs(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("//item", "<item/>")
+	t.Setenv("BASEX_ALLOWED_URLS", "http://basex.internal:8080")
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "//item",
+		"target": catalog(server, "db"),
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if message := errorMessage(action); !strings.Contains(message, "is not allowed by BASEX_ALLOWED_URLS") {
+		t.Errorf("error = %q", message)
+	}
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/queries", restCredentials(server, map[string]interface{}{
+		"query":    "//item",
+		"database": "db",
+	}))
+	requireStatus(t, decodeAction(t, rec), "FailedActionStatus")
+	if len(server.Requests()) != 0 {
+		t.Errorf("requests reached a server off the allowlist: %+v", server.Requests())
+	}
+
+	// Servers configured by the operator are trusted
+	useDefaultConnection(t, server.URL, server.Username, server.Password)
+	action = serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "//item",
+		"target": map[string]interface{}{"@type": "DataCatalog", "identifier": "db"},
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+
+	t.Setenv("BASEX_ALLOWED_URLS", "127.0.0.0/8")
+	action = serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "//item",
+		"target": catalog(server, "db"),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+}
+
+func TestAllowlistChecksConnectedAddresses(t *testing.T) {
+	_, server := newTestService(t)
+	protocolServer := basextest.NewProtocolServer(t)
+	server.RespondToQuery("//item", "<item/>")
+	protocolServer.RespondToQuery("//item", "<item/>")
+
+	// Hosts allowed by name are connected to as usual, hosts allowed by
+	// address through the checking dialer
+	t.Setenv("BASEX_ALLOWED_URLS", server.URL+",127.0.0.0/8")
+	if connection, _, err := resolveConnection(&semantic.XMLDatabase{URL: server.URL}); err != nil || connection.CheckAddresses {
+		t.Errorf("allowed by url: %+v, %v", connection, err)
+	}
+	if connection, _, err := resolveConnection(&semantic.XMLDatabase{URL: protocolServer.URL}); err != nil || !connection.CheckAddresses {
+		t.Errorf("allowed by address: %+v, %v", connection, err)
+	}
+
+	// The address connected to is checked again, as the host may resolve
+	// differently by then
+	t.Setenv("BASEX_ALLOWED_URLS", "10.0.0.0/8")
+	for _, url := range []string{server.URL, protocolServer.URL} {
+		client, err := newBaseXClient(baseXConnection{URL: url, Username: server.Username, Password: server.Password, CheckAddresses: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Query(context.Background(), "db", &basex.Query{Text: "//item"}); err == nil || !strings.Contains(err.Error(), "is not allowed by BASEX_ALLOWED_URLS") {
+			t.Errorf("%s: query through the checking dialer: %v", url, err)
+		}
+	}
+	if len(server.Requests()) != 0 || protocolServer.Sessions() != 0 {
+		t.Errorf("a server outside the allowed networks was reached")
+	}
+
+	t.Setenv("BASEX_ALLOWED_URLS", "127.0.0.0/8")
+	client, err := newBaseXClient(baseXConnection{URL: server.URL, Username: server.Username, Password: server.Password, CheckAddresses: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Query(context.Background(), "db", &basex.Query{Text: "//item"}); err != nil {
+		t.Errorf("query to an allowed address: %v", err)
+	}
+}
+
+func TestS3ServiceAllowlist(t *testing.T) {
+	t.Setenv("S3_SERVICE_URL", "http://s3service:8092")
+	t.Setenv("BASEX_ALLOWED_S3_SERVICES", "http://s3.internal:8092")
+	if _, err := s3ServiceEndpoint(); err == nil || !strings.Contains(err.Error(), "BASEX_ALLOWED_S3_SERVICES") {
+		t.Errorf("s3service off the allowlist: %v", err)
+	}
+	if _, err := downloadFromS3("s3://bucket/doc.xml", "application/xml"); err == nil {
+		t.Error("download went to an s3service off the allowlist")
+	}
+
+	t.Setenv("BASEX_ALLOWED_S3_SERVICES", "s3service")
+	if endpoint, err := s3ServiceEndpoint(); err != nil || endpoint != "http://s3service:8092" {
+		t.Errorf("s3ServiceEndpoint = %q, %v", endpoint, err)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	evehttp "eve.evalgo.org/http"
+	"eve.evalgo.org/semantic"
+	"github.com/labstack/echo/v4"
+)
+
+// apiKeyHeader carries the API key of a request
+const apiKeyHeader = "x-api-key"
+
+// readOnlyScopeKey carries the name of a read-only API key to the query
+// handler, which refuses updating queries for it
+const readOnlyScopeKey = "basexservice.readOnlyScope"
+
+// errUnknownAPIKey rejects requests without a configured API key
+var errUnknownAPIKey = errors.New("missing or unknown API key")
+
+// readActionTypes are the action types that only read
+var readActionTypes = []string{"SearchAction", "ReadAction", "DownloadAction"}
+
+// apiKeyScope is an API key defined in BASEX_API_KEYS_FILE and what it may
+// do. Empty lists allow everything.
+type apiKeyScope struct {
+	Name string
+	key  string
+	// Actions are the allowed action types, e.g. SearchAction
+	Actions []string
+	// Databases are path.Match patterns of the allowed database names
+	Databases []string
+	// Connections are the allowed connection profiles. Actions not using a
+	// profile are rejected if set.
+	Connections []string
+}
+
+// apiKeyFile is the YAML or JSON layout of the API key file
+type apiKeyFile struct {
+	Keys map[string]struct {
+		Key         string   `yaml:"key" json:"key"`
+		Actions     []string `yaml:"actions" json:"actions"`
+		Databases   []string `yaml:"databases" json:"databases"`
+		Connections []string `yaml:"connections" json:"connections"`
+	} `yaml:"keys" json:"keys"`
+}
+
+// apiKeyRegistry holds the scoped API keys loaded from the key file
+type apiKeyRegistry struct {
+	file configFile
+	mu   sync.RWMutex
+	keys []*apiKeyScope
+}
+
+// apiKeys is the registry used by the API key middleware and
+// handleSemanticAction
+var apiKeys = &apiKeyRegistry{}
+
+// load reads the keys from path and replaces the current ones. The current
+// keys are kept if the file is invalid.
+func (r *apiKeyRegistry) load(path string) error {
+	data, err := r.file.read(path)
+	if err != nil {
+		return fmt.Errorf("failed to read API keys: %w", err)
+	}
+	keys, err := parseAPIKeys(path, data)
+	if err != nil {
+		return fmt.Errorf("invalid API keys in %s: %w", path, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = keys
+	return nil
+}
+
+// reload reads the key file again
+func (r *apiKeyRegistry) reload() error {
+	path := r.file.name()
+	if path == "" {
+		return nil
+	}
+	return r.load(path)
+}
+
+// reloadAndLog reloads the keys and logs the outcome
+func (r *apiKeyRegistry) reloadAndLog(logger configLogger) {
+	if err := r.reload(); err != nil {
+		logger.Warnf("%v, keeping the previous API keys", err)
+		return
+	}
+	logger.Infof("Reloaded %d API keys", r.count())
+}
+
+// watch reloads the keys when the file changes until stop is closed
+func (r *apiKeyRegistry) watch(interval time.Duration, stop <-chan struct{}, logger configLogger) {
+	r.file.watch(interval, stop, func() { r.reloadAndLog(logger) })
+}
+
+// configured reports whether scoped keys are in use
+func (r *apiKeyRegistry) configured() bool {
+	return r.file.name() != ""
+}
+
+// count returns the number of keys
+func (r *apiKeyRegistry) count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.keys)
+}
+
+// lookup returns the scope of key, or nil if key is unknown
+func (r *apiKeyRegistry) lookup(key string) *apiKeyScope {
+	if key == "" {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var found *apiKeyScope
+	for _, scope := range r.keys {
+		// Compare every key in constant time, so timing reveals nothing
+		if subtle.ConstantTimeCompare([]byte(scope.key), []byte(key)) == 1 {
+			found = scope
+		}
+	}
+	return found
+}
+
+// parseAPIKeys parses a key file. Keys may be secret references such as
+// env:BASEX_SECRET_INGEST_KEY.
+func parseAPIKeys(filename string, data []byte) ([]*apiKeyScope, error) {
+	var file apiKeyFile
+	if err := unmarshalConfig(filename, data, &file); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*apiKeyScope, 0, len(file.Keys))
+	seen := make(map[string]string, len(file.Keys))
+	for name, entry := range file.Keys {
+		key, err := resolveSecret(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", name, err)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("key %q has no key", name)
+		}
+		if other, ok := seen[key]; ok {
+			return nil, fmt.Errorf("keys %q and %q are the same", other, name)
+		}
+		seen[key] = name
+		for _, pattern := range entry.Databases {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("key %q: invalid database pattern %q", name, pattern)
+			}
+		}
+		keys = append(keys, &apiKeyScope{
+			Name:        name,
+			key:         key,
+			Actions:     entry.Actions,
+			Databases:   entry.Databases,
+			Connections: entry.Connections,
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// scopedAPIKeyMiddleware accepts the shared BASEX_API_KEY and, if a key file is
+// loaded, its scoped keys. What a scoped key may do is checked by
+// authorizeAction once the action is parsed.
+func scopedAPIKeyMiddleware(sharedKey string) echo.MiddlewareFunc {
+	shared := evehttp.APIKeyMiddleware(sharedKey)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		checkShared := shared(next)
+		return func(c echo.Context) error {
+			if !apiKeys.configured() {
+				return checkShared(c)
+			}
+			if apiKeys.lookup(c.Request().Header.Get(apiKeyHeader)) != nil {
+				return next(c)
+			}
+			if sharedKey == "" {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": errUnknownAPIKey.Error()})
+			}
+			return checkShared(c)
+		}
+	}
+}
+
+// authorizeAction checks action against the scope of the request's API key.
+// It returns an echo.HTTPError, so that REST adapters going through
+// callSemanticHandler are held to the same scopes.
+func authorizeAction(c echo.Context, action *semantic.SemanticAction) error {
+	if !apiKeys.configured() {
+		return nil
+	}
+	key := c.Request().Header.Get(apiKeyHeader)
+	if shared := os.Getenv("BASEX_API_KEY"); shared != "" && subtle.ConstantTimeCompare([]byte(shared), []byte(key)) == 1 {
+		return nil
+	}
+	scope := apiKeys.lookup(key)
+	if scope == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, errUnknownAPIKey.Error())
+	}
+	if err := scope.allows(action); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("API key %q: %v", scope.Name, err))
+	}
+	if scope.readOnly() {
+		c.Set(readOnlyScopeKey, scope.Name)
+	}
+	return nil
+}
+
+// readOnly reports whether the scope only allows actions that read
+func (s *apiKeyScope) readOnly() bool {
+	if len(s.Actions) == 0 {
+		return false
+	}
+	for _, actionType := range s.Actions {
+		if !slices.Contains(readActionTypes, actionType) {
+			return false
+		}
+	}
+	return true
+}
+
+// allows returns an error unless the action type, every database the action
+// names and the connection profiles they use are within the scope
+func (s *apiKeyScope) allows(action *semantic.SemanticAction) error {
+	if len(s.Actions) > 0 && !slices.Contains(s.Actions, action.Type) {
+		return fmt.Errorf("%s is not allowed", action.Type)
+	}
+
+	// XQuery can open any database, so keys limited to databases may only
+	// run stored queries
+	if len(s.Databases) > 0 {
+		if kind := actionXQuery(action); kind != "" {
+			return fmt.Errorf("%s XQuery is not allowed for keys limited to databases", kind)
+		}
+	}
+
+	for _, database := range actionDatabases(action) {
+		profile, err := profileForDatabase(database)
+		if err != nil {
+			return err
+		}
+		if len(s.Connections) > 0 {
+			if profile == nil {
+				return errors.New("only connection profiles are allowed")
+			}
+			if !slices.Contains(s.Connections, profile.Name) {
+				return fmt.Errorf("connection profile %q is not allowed", profile.Name)
+			}
+		}
+		if err := s.allowsDatabase(database.Identifier); err != nil {
+			return err
+		}
+	}
+
+	// Stored-query actions work on the system database
+	if action.Object != nil && action.Object.Type == "SoftwareSourceCode" {
+		return s.allowsDatabase(storedQueryDatabase())
+	}
+	return nil
+}
+
+// allowsDatabase returns an error unless name matches a database pattern
+func (s *apiKeyScope) allowsDatabase(name string) error {
+	if len(s.Databases) == 0 {
+		return nil
+	}
+	for _, pattern := range s.Databases {
+		if matched, _ := path.Match(pattern, name); matched {
+			return nil
+		}
+	}
+	return fmt.Errorf("database %q is not allowed", name)
+}
+
+// actionXQuery returns what XQuery the request supplies: a query, a stored
+// query to register or a patch. It returns "" for actions without XQuery.
+func actionXQuery(action *semantic.SemanticAction) string {
+	if semantic.GetQueryFromAction(action) != "" {
+		return "query"
+	}
+	if action.Type == "CreateAction" && action.Object != nil && action.Object.Type == "SoftwareSourceCode" {
+		return "stored query"
+	}
+	if action.Type == "UpdateAction" {
+		// Invalid patches are refused too rather than left to the handler
+		if patch, err := getDocumentPatch(action); patch != nil || err != nil {
+			return "patch"
+		}
+	}
+	return ""
+}
+
+// actionDatabases returns copies of the DataCatalogs and Databases in the
+// target, object, result and toLocation of an action
+func actionDatabases(action *semantic.SemanticAction) []*semantic.XMLDatabase {
+	var databases []*semantic.XMLDatabase
+	for _, key := range []string{"target", "object", "result", "toLocation"} {
+		if database := actionDatabase(action, key); database != nil {
+			databases = append(databases, database)
+		}
+	}
+	return databases
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"basexservice.evalgo.org/basex/basextest"
+	"github.com/labstack/echo/v4"
+)
+
+// useAPIKeys loads the API key file content for the test
+func useAPIKeys(t *testing.T, content string) {
+	t.Helper()
+	previous := apiKeys
+	apiKeys = &apiKeyRegistry{}
+	t.Cleanup(func() { apiKeys = previous })
+
+	if err := apiKeys.load(writeFile(t, t.TempDir(), "keys.yaml", content)); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+}
+
+// newKeyedTestService is newTestService behind the API key middleware
+func newKeyedTestService(t *testing.T, sharedKey string) (*echo.Echo, *basextest.Server) {
+	t.Helper()
+	t.Setenv("BASEX_API_KEY", sharedKey)
+	e := echo.New()
+	e.JSONSerializer = redactingJSONSerializer{}
+	apiGroup := e.Group("/v1/api")
+	middleware := scopedAPIKeyMiddleware(sharedKey)
+	apiGroup.POST("/semantic/action", handleSemanticAction, middleware)
+	registerRESTEndpoints(apiGroup, middleware)
+	return e, basextest.NewServer(t)
+}
+
+const testAPIKeys = `keys:
+  reader:
+    key: reader-key
+    actions: [SearchAction]
+    databases: ["iqs-*"]
+  admin:
+    key: env:BASEX_SECRET_ADMIN_KEY
+`
+
+func TestScopedAPIKeys(t *testing.T) {
+	e, server := newKeyedTestService(t, "shared-key")
+	server.RespondToQuery("//item", "<item/>")
+	server.CreateDatabase("iqs-prod")
+	server.CreateDatabase("other")
+	scriptStoredQueryRegistry(server)
+	scriptUpdatingCheck(server)
+	putStoredQuery(t, server, &storedQuery{Name: "items", Version: "1", Text: "//item"})
+	t.Setenv("BASEX_SECRET_ADMIN_KEY", "admin-key")
+	useAPIKeys(t, testAPIKeys)
+
+	search := func(key, db string) *http.Response {
+		rec := serve(t, e, http.MethodPost, "/v1/api/semantic/action", map[string]interface{}{
+			"@type":      "SearchAction",
+			"instrument": map[string]interface{}{"@type": "SoftwareSourceCode", "identifier": "items"},
+			"target":     catalog(server, db),
+		}, "x-api-key", key)
+		return rec.Result()
+	}
+
+	for _, tc := range []struct {
+		key, db string
+		status  int
+	}{
+		{"reader-key", "iqs-prod", http.StatusOK},
+		{"reader-key", "other", http.StatusForbidden},
+		{"admin-key", "other", http.StatusOK},
+		{"shared-key", "other", http.StatusOK},
+		{"", "iqs-prod", http.StatusUnauthorized},
+		{"wrong-key", "iqs-prod", http.StatusUnauthorized},
+	} {
+		if got := search(tc.key, tc.db).StatusCode; got != tc.status {
+			t.Errorf("%q on %s: status %d, want %d", tc.key, tc.db, got, tc.status)
+		}
+	}
+
+	// REST adapters are held to the same scopes
+	rec := serve(t, e, http.MethodDelete, "/v1/api/databases/iqs-prod?baseUrl="+server.URL, nil, "x-api-key", "reader-key")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "DeleteAction is not allowed") {
+		t.Errorf("REST delete with reader key = %d %s", rec.Code, rec.Body.String())
+	}
+	if !server.HasDatabase("iqs-prod") {
+		t.Error("database was dropped")
+	}
+	rec = serve(t, e, http.MethodDelete, "/v1/api/databases/iqs-prod?baseUrl="+server.URL, nil,
+		append(basicAuth(server.Username, server.Password), "x-api-key", "admin-key")...)
+	if rec.Code != http.StatusOK || server.HasDatabase("iqs-prod") {
+		t.Errorf("REST delete with admin key = %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestScopedAPIKeyQueries(t *testing.T) {
+	e, server := newKeyedTestService(t, "")
+	server.RespondToQuery("//item", "<item/>")
+	server.CreateDatabase("iqs-prod")
+	server.CreateDatabase("other")
+	scriptStoredQueryRegistry(server)
+	scriptUpdatingCheck(server)
+	var drops []string
+	server.HandleQuery("db:drop(", func(q *basextest.Query) basextest.Response {
+		drops = append(drops, q.Text)
+		return basextest.Response{}
+	})
+	putStoredQuery(t, server, &storedQuery{Name: "drop", Version: "1", Text: "db:drop('other')"})
+	t.Setenv("BASEX_SECRET_ADMIN_KEY", "admin-key")
+	useAPIKeys(t, testAPIKeys+`  searcher:
+    key: searcher-key
+    actions: [SearchAction]
+`)
+
+	search := func(key string, action map[string]interface{}) *httptest.ResponseRecorder {
+		action["@type"] = "SearchAction"
+		action["target"] = catalog(server, "iqs-prod")
+		return serve(t, e, http.MethodPost, "/v1/api/semantic/action", action, "x-api-key", key)
+	}
+	dropQuery := map[string]interface{}{"query": "db:drop('other')"}
+	dropStored := map[string]interface{}{"instrument": map[string]interface{}{"@type": "SoftwareSourceCode", "identifier": "drop"}}
+
+	for _, tc := range []struct {
+		name, key string
+		action    map[string]interface{}
+		status    int
+		message   string
+	}{
+		// Keys limited to databases run no XQuery of their own
+		{"reader query", "reader-key", map[string]interface{}{"query": "//item"}, http.StatusForbidden, "query XQuery is not allowed"},
+		{"reader drop", "reader-key", dropQuery, http.StatusForbidden, "query XQuery is not allowed"},
+		// and read-only keys no updating queries, stored or not
+		{"reader stored drop", "reader-key", dropStored, http.StatusForbidden, "updating queries are not allowed"},
+		{"searcher drop", "searcher-key", dropQuery, http.StatusForbidden, "updating queries are not allowed"},
+		{"searcher stored drop", "searcher-key", dropStored, http.StatusForbidden, "updating queries are not allowed"},
+		{"searcher query", "searcher-key", map[string]interface{}{"query": "//item"}, http.StatusOK, ""},
+	} {
+		rec := search(tc.key, tc.action)
+		if rec.Code != tc.status || !strings.Contains(rec.Body.String(), tc.message) {
+			t.Errorf("%s: %d %s", tc.name, rec.Code, rec.Body.String())
+		}
+	}
+	if len(drops) != 0 || !server.HasDatabase("other") {
+		t.Errorf("updating queries ran: %q", drops)
+	}
+
+	// Patches and stored query registrations are XQuery too
+	useAPIKeys(t, `keys:
+  writer:
+    key: writer-key
+    databases: ["iqs-*", "`+storedQueryDatabase()+`"]
+`)
+	for name, action := range map[string]map[string]interface{}{
+		"patch": {
+			"@type":      "UpdateAction",
+			"object":     map[string]interface{}{"@type": "DigitalDocument", "identifier": "doc.xml"},
+			"instrument": map[string]interface{}{"@type": "SoftwareSourceCode", "programmingLanguage": "XQuery", "text": "db:drop('other')"},
+			"target":     catalog(server, "iqs-prod"),
+		},
+		"registration": {
+			"@type":  "CreateAction",
+			"object": map[string]interface{}{"@type": "SoftwareSourceCode", "identifier": "drop", "version": "2", "text": "db:drop('other')"},
+			"target": registryCatalog(server),
+		},
+	} {
+		rec := serve(t, e, http.MethodPost, "/v1/api/semantic/action", action, "x-api-key", "writer-key")
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "not allowed for keys limited to databases") {
+			t.Errorf("%s: %d %s", name, rec.Code, rec.Body.String())
+		}
+	}
+	if len(drops) != 0 {
+		t.Errorf("updating queries ran: %q", drops)
+	}
+}
+
+func TestScopedAPIKeyConnections(t *testing.T) {
+	e, server := newKeyedTestService(t, "")
+	server.RespondToQuery("//item", "<item/>")
+	useConnectionProfiles(t, "connections.yaml", testProfiles(server))
+	scriptStoredQueryRegistry(server)
+	scriptUpdatingCheck(server)
+	putStoredQuery(t, server, &storedQuery{Name: "items", Version: "1", Text: "//item"})
+	useAPIKeys(t, `keys:
+  prod:
+    key: prod-key
+    connections: [prod]
+    databases: [IQS]
+`)
+
+	for _, tc := range []struct {
+		target map[string]interface{}
+		status int
+	}{
+		{map[string]interface{}{"@type": "DataCatalog", "url": "connection://prod"}, http.StatusOK},
+		{map[string]interface{}{"@type": "DataCatalog", "identifier": "prod"}, http.StatusOK},
+		{map[string]interface{}{"@type": "DataCatalog", "url": "connection://prod", "identifier": "other"}, http.StatusForbidden},
+		{map[string]interface{}{"@type": "DataCatalog", "url": "connection://scratch", "identifier": "IQS"}, http.StatusForbidden},
+		{catalog(server, "IQS"), http.StatusForbidden},
+	} {
+		rec := serve(t, e, http.MethodPost, "/v1/api/semantic/action", map[string]interface{}{
+			"@type":      "SearchAction",
+			"instrument": map[string]interface{}{"@type": "SoftwareSourceCode", "identifier": "items"},
+			"target":     tc.target,
+		}, "x-api-key", "prod-key")
+		if rec.Code != tc.status {
+			t.Errorf("%v: status %d, want %d: %s", tc.target, rec.Code, tc.status, rec.Body.String())
+		}
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := parseAPIKeys("keys.json", []byte(`{"keys": {"ingest": {"key": "k", "actions": ["UploadAction"]}}}`))
+	if err != nil || len(keys) != 1 || keys[0].Name != "ingest" || keys[0].Actions[0] != "UploadAction" {
+		t.Fatalf("parseAPIKeys = %+v, %v", keys, err)
+	}
+
+	for _, content := range []string{
+		"keys:\n  a:\n    actions: [SearchAction]\n",
+		"keys:\n  a:\n    key: same\n  b:\n    key: same\n",
+		"keys:\n  a:\n    key: k\n    databases: [\"[\"]\n",
+		"keys:\n  a:\n    key: k\n    scopes: [all]\n",
+		"keys:\n  a:\n    key: env:BASEX_SECRET_UNSET_KEY\n",
+	} {
+		if _, err := parseAPIKeys("keys.yaml", []byte(content)); err == nil {
+			t.Errorf("accepted %q", content)
+		}
+	}
+}
+
+func TestAPIKeyFileFailsClosed(t *testing.T) {
+	e, server := newKeyedTestService(t, "")
+	previous := apiKeys
+	apiKeys = &apiKeyRegistry{}
+	t.Cleanup(func() { apiKeys = previous })
+	if err := apiKeys.load(writeFile(t, t.TempDir(), "keys.yaml", "keys: [")); err == nil {
+		t.Fatal("invalid file loaded")
+	}
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/semantic/action", map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "//item",
+		"target": catalog(server, "db"),
+	})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if len(server.Requests()) != 0 {
+		t.Errorf("BaseX was called: %+v", server.Requests())
+	}
+}
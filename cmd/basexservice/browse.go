@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"basexservice.evalgo.org/basex"
+	"eve.evalgo.org/semantic"
+	"github.com/labstack/echo/v4"
+)
+
+// databaseEntry is the JSON-LD description of a database
+type databaseEntry struct {
+	Context    string `json:"@context,omitempty"`
+	Type       string `json:"@type"`
+	Identifier string `json:"identifier"`
+	Name       string `json:"name"`
+	// ContentSize is the size of the database in bytes
+	ContentSize int64 `json:"contentSize"`
+	// NumberOfItems is the number of resources in the database
+	NumberOfItems int    `json:"numberOfItems"`
+	DateModified  string `json:"dateModified,omitempty"`
+}
+
+// documentEntry is the JSON-LD description of a stored resource
+type documentEntry struct {
+	Type           string `json:"@type"`
+	Identifier     string `json:"identifier"`
+	Name           string `json:"name"`
+	EncodingFormat string `json:"encodingFormat,omitempty"`
+	// ContentSize is in bytes for binary resources and in nodes for XML
+	// documents, as reported by BaseX
+	ContentSize           int64         `json:"contentSize"`
+	DateModified          string        `json:"dateModified,omitempty"`
+	IncludedInDataCatalog *catalogEntry `json:"includedInDataCatalog"`
+}
+
+// catalogEntry references the database holding a resource
+type catalogEntry struct {
+	Type       string `json:"@type"`
+	Identifier string `json:"identifier"`
+}
+
+func newDatabaseEntry(info basex.DatabaseInfo) *databaseEntry {
+	return &databaseEntry{
+		Type:          "DataCatalog",
+		Identifier:    info.Name,
+		Name:          info.Name,
+		ContentSize:   info.Size,
+		NumberOfItems: info.Resources,
+		DateModified:  formatDateModified(info.Modified),
+	}
+}
+
+func newDocumentEntry(db string, info basex.DocumentInfo) *documentEntry {
+	return &documentEntry{
+		Type:                  "Dataset",
+		Identifier:            info.Path,
+		Name:                  path.Base(info.Path),
+		EncodingFormat:        info.ContentType,
+		ContentSize:           info.Size,
+		DateModified:          formatDateModified(info.Modified),
+		IncludedInDataCatalog: &catalogEntry{Type: "DataCatalog", Identifier: db},
+	}
+}
+
+// formatDateModified formats a modification time, which servers that do
+// not report one leave zero
+func formatDateModified(modified time.Time) string {
+	if modified.IsZero() {
+		return ""
+	}
+	return modified.UTC().Format(time.RFC3339)
+}
+
+// executeBrowseAction handles SearchAction on a DataCatalog or Database
+// without a query. It lists the databases of the server, or the documents of
+// the database named by the identifier, filtered by the prefix property and
+// paged like query results.
+func executeBrowseAction(c echo.Context, action *semantic.SemanticAction, database *semantic.XMLDatabase) error {
+	client, err := clientForDatabase(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
+	page, err := getQueryPage(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid pagination parameters", err)
+	}
+	prefix, _ := actionProperty(action, "prefix").(string)
+	if prefix == "" {
+		prefix, _ = actionAdditionalProperty(action, "prefix").(string)
+	}
+
+	raw, _ := c.Get(rawResultKey).(bool)
+	var items []interface{}
+	if database.Identifier == "" {
+		databases, err := client.ListDatabases(ctx)
+		if err != nil {
+			return returnBaseXError(c, action, ctx, timeout, "Failed to list databases", err)
+		}
+		for _, info := range databases {
+			if strings.HasPrefix(info.Name, prefix) {
+				items = append(items, newDatabaseEntry(info))
+			}
+		}
+	} else {
+		documents, err := client.ListDocuments(ctx, database.Identifier, prefix)
+		if err != nil {
+			if raw && errors.Is(err, basex.ErrNotFound) {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+			}
+			return returnBaseXError(c, action, ctx, timeout, "Failed to list documents", err)
+		}
+		for _, info := range documents {
+			items = append(items, newDocumentEntry(database.Identifier, info))
+		}
+	}
+
+	total, nextCursor := len(items), ""
+	if page != nil {
+		start, end := page.bounds(total)
+		items = items[start:end]
+		nextCursor = page.nextCursor(total)
+	}
+	if items == nil {
+		items = []interface{}{}
+	}
+	list := newPageList(items, total, nextCursor)
+
+	// REST callers get the list itself
+	if raw {
+		setPageHeaders(c, total, nextCursor)
+		return c.JSON(http.StatusOK, list)
+	}
+
+	output, err := json.Marshal(list)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to encode list", err)
+	}
+	setActionProperty(action, "numberOfItems", total)
+	if nextCursor != "" {
+		setActionProperty(action, "nextCursor", nextCursor)
+	}
+	action.Result = &semantic.SemanticResult{
+		Type:   "ItemList",
+		Format: "application/ld+json",
+		Output: string(output),
+	}
+	semantic.SetSuccessOnAction(action)
+	return c.JSON(http.StatusOK, action)
+}
+
+// executeReadDatabaseAction handles ReadAction and DownloadAction on a
+// DataCatalog or Database, describing the database named by its identifier
+func executeReadDatabaseAction(c echo.Context, action *semantic.SemanticAction, database *semantic.XMLDatabase) error {
+	client, err := clientForDatabase(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	if database.Identifier == "" {
+		return semantic.ReturnActionError(c, action, "Database identifier is required", nil)
+	}
+
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
+	databases, err := client.ListDatabases(ctx)
+	if err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to list databases", err)
+	}
+	var entry *databaseEntry
+	for _, info := range databases {
+		if info.Name == database.Identifier {
+			entry = newDatabaseEntry(info)
+		}
+	}
+
+	raw, _ := c.Get(rawResultKey).(bool)
+	if entry == nil {
+		message := fmt.Sprintf("Database %q not found", database.Identifier)
+		if raw {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": message})
+		}
+		return semantic.ReturnActionError(c, action, message, nil)
+	}
+
+	// REST callers get the description itself
+	if raw {
+		entry.Context = "https://schema.org"
+		return c.JSON(http.StatusOK, entry)
+	}
+
+	output, err := json.Marshal(entry)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to encode database", err)
+	}
+	action.Result = &semantic.SemanticResult{
+		Type:   "DataCatalog",
+		Format: "application/ld+json",
+		Output: string(output),
+	}
+	semantic.SetSuccessOnAction(action)
+	return c.JSON(http.StatusOK, action)
+}
+
+// listDatabasesREST handles REST GET /v1/api/databases
+// Converts to SearchAction on a DataCatalog and delegates to semantic handler
+func listDatabasesREST(c echo.Context) error {
+	database, err := requestDatabaseObject(c, "")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	action := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "SearchAction",
+		"object":   database,
+	}
+	addBrowseParams(c, action)
+
+	return callSemanticHandlerWith(c, action, map[string]interface{}{rawResultKey: true})
+}
+
+// getDatabaseREST handles REST GET /v1/api/databases/:name
+// Converts to ReadAction on a DataCatalog and delegates to semantic handler
+func getDatabaseREST(c echo.Context) error {
+	database, err := requestDatabaseObject(c, c.Param("name"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	action := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "ReadAction",
+		"object":   database,
+	}
+
+	return callSemanticHandlerWith(c, action, map[string]interface{}{rawResultKey: true})
+}
+
+// listDocumentsREST handles REST GET /v1/api/databases/:name/documents
+// Converts to SearchAction on a DataCatalog and delegates to semantic handler
+func listDocumentsREST(c echo.Context) error {
+	name := c.Param("name")
+	if name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "database name is required"})
+	}
+	database, err := requestDatabaseObject(c, name)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	action := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "SearchAction",
+		"object":   database,
+	}
+	addBrowseParams(c, action)
+
+	return callSemanticHandlerWith(c, action, map[string]interface{}{rawResultKey: true})
+}
+
+// addBrowseParams copies the prefix and paging query parameters to action
+func addBrowseParams(c echo.Context, action map[string]interface{}) {
+	for _, key := range []string{"prefix", "offset", "limit", "cursor"} {
+		if value := c.QueryParam(key); value != "" {
+			action[key] = value
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// itemList decodes the ItemList of a browse response
+type itemList struct {
+	NumberOfItems   int                      `json:"numberOfItems"`
+	NextCursor      string                   `json:"nextCursor"`
+	ItemListElement []map[string]interface{} `json:"itemListElement"`
+}
+
+func TestBrowseREST(t *testing.T) {
+	e, server := newTestService(t)
+	server.PutResource("iqs", "docs/a.xml", "application/xml", []byte("<a/>"))
+	server.PutResource("iqs", "docs/b.xml", "application/xml", []byte("<b/>"))
+	server.PutResource("iqs", "images/logo.png", "image/png", testPNG)
+	server.CreateDatabase("scratch")
+	baseURL := "?baseUrl=" + server.URL
+	auth := basicAuth(server.Username, server.Password)
+
+	get := func(path string) (int, itemList, http.Header) {
+		rec := serve(t, e, http.MethodGet, path, nil, auth...)
+		var list itemList
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+				t.Fatalf("GET %s: %v: %s", path, err, rec.Body.String())
+			}
+		}
+		return rec.Code, list, rec.Header()
+	}
+
+	status, list, _ := get("/v1/api/databases" + baseURL)
+	if status != http.StatusOK || list.NumberOfItems != 2 || list.ItemListElement[0]["identifier"] != "iqs" || list.ItemListElement[0]["@type"] != "DataCatalog" {
+		t.Fatalf("databases = %d %+v", status, list)
+	}
+	if iqs := list.ItemListElement[0]; iqs["numberOfItems"] != float64(3) || iqs["dateModified"] == nil {
+		t.Errorf("iqs = %+v", iqs)
+	}
+	if _, list, _ = get("/v1/api/databases" + baseURL + "&prefix=scr"); list.NumberOfItems != 1 || list.ItemListElement[0]["identifier"] != "scratch" {
+		t.Errorf("databases with prefix = %+v", list)
+	}
+
+	rec := serve(t, e, http.MethodGet, "/v1/api/databases/iqs"+baseURL, nil, auth...)
+	var database map[string]interface{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &database)
+	if rec.Code != http.StatusOK || database["@type"] != "DataCatalog" || database["numberOfItems"] != float64(3) {
+		t.Errorf("database = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(t, e, http.MethodGet, "/v1/api/databases/missing"+baseURL, nil, auth...); rec.Code != http.StatusNotFound {
+		t.Errorf("missing database: status %d, want 404", rec.Code)
+	}
+
+	status, list, header := get("/v1/api/databases/iqs/documents" + baseURL + "&prefix=docs/&limit=1")
+	if status != http.StatusOK || list.NumberOfItems != 2 || len(list.ItemListElement) != 1 || list.NextCursor == "" {
+		t.Fatalf("documents = %d %+v", status, list)
+	}
+	if document := list.ItemListElement[0]; document["@type"] != "Dataset" || document["identifier"] != "docs/a.xml" || document["name"] != "a.xml" || document["encodingFormat"] != "application/xml" {
+		t.Errorf("document = %+v", document)
+	}
+	if header.Get("X-Total-Count") != "2" || header.Get("X-Next-Cursor") != list.NextCursor {
+		t.Errorf("page headers = %v", header)
+	}
+	if _, list, _ = get("/v1/api/databases/iqs/documents" + baseURL + "&prefix=docs/&limit=1&cursor=" + list.NextCursor); len(list.ItemListElement) != 1 || list.ItemListElement[0]["identifier"] != "docs/b.xml" || list.NextCursor != "" {
+		t.Errorf("second page = %+v", list)
+	}
+	if status, _, _ := get("/v1/api/databases/missing/documents" + baseURL); status != http.StatusNotFound {
+		t.Errorf("documents of a missing database: status %d, want 404", status)
+	}
+
+	// Credentials are not taken from the URL
+	for _, path := range []string{"/v1/api/databases", "/v1/api/databases/iqs", "/v1/api/databases/iqs/documents"} {
+		rec := serve(t, e, http.MethodGet, path+baseURL+"&username="+server.Username+"&password="+server.Password, nil)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "basic authentication") {
+			t.Errorf("%s with credentials in the URL = %d %s", path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestBrowseAction(t *testing.T) {
+	e, server := newTestService(t)
+	server.PutResource("iqs", "docs/a.xml", "application/xml", []byte("<a/>"))
+	server.PutResource("iqs", "images/logo.png", "image/png", testPNG)
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"object": catalog(server, "iqs"),
+		"prefix": "images/",
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	var list itemList
+	if err := json.Unmarshal([]byte(resultOutput(action)), &list); err != nil || list.NumberOfItems != 1 || list.ItemListElement[0]["contentSize"] != float64(len(testPNG)) {
+		t.Errorf("documents = %+v, %v", list, err)
+	}
+	if action["numberOfItems"] != float64(1) {
+		t.Errorf("numberOfItems = %v", action["numberOfItems"])
+	}
+
+	action = serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"object": catalog(server, ""),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if err := json.Unmarshal([]byte(resultOutput(action)), &list); err != nil || list.NumberOfItems != 1 || list.ItemListElement[0]["identifier"] != "iqs" {
+		t.Errorf("databases = %+v, %v", list, err)
+	}
+
+	action = serveAction(t, e, map[string]interface{}{
+		"@type":  "ReadAction",
+		"object": catalog(server, "iqs"),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if result := action["result"].(map[string]interface{}); result["@type"] != "DataCatalog" {
+		t.Errorf("result = %+v", result)
+	}
+
+	requireStatus(t, serveAction(t, e, map[string]interface{}{
+		"@type":  "ReadAction",
+		"object": catalog(server, "missing"),
+	}), "FailedActionStatus")
+}
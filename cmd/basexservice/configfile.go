@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// configFilePollInterval is how often configuration files are checked for
+// changes
+const configFilePollInterval = 5 * time.Second
+
+// configLogger logs the reloads of configuration files
+type configLogger interface {
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// configFile is an operator-provided configuration file that is reloaded
+// when it changes
+type configFile struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// read returns the content of the file at path and remembers it as the file
+// to watch, even if it cannot be read, so that a fixed version is picked up
+func (f *configFile) read(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	f.mu.Lock()
+	f.path = path
+	if err == nil {
+		f.modTime, f.size = info.ModTime(), info.Size()
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// name returns the path of the file, or "" if none was read
+func (f *configFile) name() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.path
+}
+
+// changed reports whether the file changed since it was read
+func (f *configFile) changed() bool {
+	f.mu.Lock()
+	path, modTime, size := f.path, f.modTime, f.size
+	f.mu.Unlock()
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && (!info.ModTime().Equal(modTime) || info.Size() != size)
+}
+
+// watch calls reload whenever the file changes until stop is closed
+func (f *configFile) watch(interval time.Duration, stop <-chan struct{}, reload func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if f.changed() {
+				reload()
+			}
+		}
+	}
+}
+
+// unmarshalConfig decodes a configuration file. Files ending in .json are
+// read as JSON, all others as YAML, where unknown fields are an error.
+func unmarshalConfig(path string, data []byte, v interface{}) error {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return json.Unmarshal(data, v)
+	}
+	return yaml.UnmarshalStrict(data, v)
+}
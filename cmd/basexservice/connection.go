@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"basexservice.evalgo.org/basex"
+	"eve.evalgo.org/semantic"
+)
+
+// errRequestCredentials rejects actions carrying their own BaseX url or
+// credentials while BASEX_FORBID_REQUEST_CREDENTIALS is set
+var errRequestCredentials = errors.New("per-request BaseX url and credentials are disabled on this server, omit them to use the default connection")
+
+// errSecretReferences rejects secret references sent to a server named by
+// the request while no BASEX_ALLOWED_URLS restricts those servers
+var errSecretReferences = errors.New("secret references are only resolved for connection profiles, BASEX_URL and servers allowed by BASEX_ALLOWED_URLS")
+
+// baseXConnection is the url and credentials of a BaseX server
+type baseXConnection struct {
+	URL      string
+	Username string
+	Password string
+	// CheckAddresses connects through allowlistDialer, for servers only
+	// allowed by a CIDR entry of BASEX_ALLOWED_URLS
+	CheckAddresses bool
+}
+
+// defaultConnection returns the server-side connection configured by
+// BASEX_URL, BASEX_USER and BASEX_PASSWORD. It is used by actions that do
+// not name a server.
+func defaultConnection() baseXConnection {
+	return baseXConnection{
+		URL:      os.Getenv("BASEX_URL"),
+		Username: os.Getenv("BASEX_USER"),
+		Password: os.Getenv("BASEX_PASSWORD"),
+	}
+}
+
+// requestCredentialsForbidden reports whether BASEX_FORBID_REQUEST_CREDENTIALS
+// is set. Values that are not a boolean forbid them, so a typo never opens
+// the policy up.
+func requestCredentialsForbidden() bool {
+	value := os.Getenv("BASEX_FORBID_REQUEST_CREDENTIALS")
+	if value == "" {
+		return false
+	}
+	forbidden, err := strconv.ParseBool(value)
+	return forbidden || err != nil
+}
+
+// resolveConnection returns the connection for database and the profile it
+// references, if any. The url and credentials of the action win over the
+// default connection or the profile. The default credentials are only sent
+// to the default server, never to a url given by the action.
+func resolveConnection(database *semantic.XMLDatabase) (baseXConnection, *connectionProfile, error) {
+	profile, err := profileForDatabase(database)
+	if err != nil {
+		return baseXConnection{}, nil, err
+	}
+	defaults := defaultConnection()
+	if profile != nil {
+		defaults = profile.baseXConnection
+	}
+
+	requested := *database
+	if profile != nil || requested.URL == "" {
+		requested.URL = defaults.URL
+	}
+	if requested.URL == "" {
+		return baseXConnection{}, nil, errors.New("no BaseX url given and BASEX_URL is not set")
+	}
+	baseURL, username, password, err := semantic.ExtractDatabaseCredentials(&requested)
+	if err != nil {
+		return baseXConnection{}, nil, err
+	}
+
+	// Profiles are server-side, so referencing one is not a request credential
+	fromRequest := profile == nil && database.URL != ""
+	if requestCredentialsForbidden() && (fromRequest || username != "" || password != "") {
+		return baseXConnection{}, nil, errRequestCredentials
+	}
+	// Servers named by the request must be on the allowlist. Secret
+	// references read the service's own secrets, so they are only resolved
+	// for servers the operator configured or allowed.
+	var checkAddresses bool
+	if fromRequest && baseURL != defaults.URL {
+		list, err := baseXAllowlist()
+		if err != nil {
+			return baseXConnection{}, nil, err
+		}
+		if list != nil {
+			if checkAddresses, err = list.check(baseURL); err != nil {
+				return baseXConnection{}, nil, err
+			}
+		} else if isServiceSecretReference(username) || isServiceSecretReference(password) {
+			return baseXConnection{}, nil, errSecretReferences
+		}
+	}
+	if baseURL == defaults.URL && username == "" && password == "" {
+		username, password = defaults.Username, defaults.Password
+	}
+
+	// Secret references are resolved only now, right before the call
+	if username, err = resolveSecret(username); err != nil {
+		return baseXConnection{}, nil, err
+	}
+	if password, err = resolveSecret(password); err != nil {
+		return baseXConnection{}, nil, err
+	}
+	return baseXConnection{URL: baseURL, Username: username, Password: password, CheckAddresses: checkAddresses}, profile, nil
+}
+
+// clientForDatabase returns a client for the server hosting database
+func clientForDatabase(database *semantic.XMLDatabase) (basex.Client, error) {
+	connection, profile, err := resolveConnection(database)
+	if err != nil {
+		return nil, err
+	}
+	return clientForConnection(connection, profile)
+}
+
+// clientForConnection returns a client for a resolved connection, held to
+// the profile it was resolved from
+func clientForConnection(connection baseXConnection, profile *connectionProfile) (basex.Client, error) {
+	client, err := newBaseXClient(connection)
+	if err != nil || profile == nil {
+		return client, err
+	}
+	return &profileClient{Client: client, profile: profile}, nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"basexservice.evalgo.org/basex"
+	"eve.evalgo.org/semantic"
+	"github.com/labstack/echo/v4"
+)
+
+// connectionScheme is the URL scheme referencing a connection profile, as
+// in connection://iqs-prod
+const connectionScheme = "connection://"
+
+// redactedSecret replaces passwords in listed profiles
+const redactedSecret = "********"
+
+// errReadOnly rejects writes through a read-only connection profile
+var errReadOnly = errors.New("connection profile is read-only")
+
+// connectionProfile is a named BaseX connection defined by the operator in
+// BASEX_CONNECTIONS_FILE
+type connectionProfile struct {
+	Name string
+	baseXConnection
+	// Timeout replaces BASEX_TIMEOUT for actions on the profile if set
+	Timeout *time.Duration
+	// Database is used when the DataCatalog names no database
+	Database string
+	ReadOnly bool
+}
+
+// connectionFile is the YAML or JSON layout of the profile file
+type connectionFile struct {
+	Connections map[string]struct {
+		URL      string      `yaml:"url" json:"url"`
+		Username string      `yaml:"username" json:"username"`
+		Password string      `yaml:"password" json:"password"`
+		Timeout  interface{} `yaml:"timeout" json:"timeout"`
+		Database string      `yaml:"database" json:"database"`
+		ReadOnly bool        `yaml:"readOnly" json:"readOnly"`
+	} `yaml:"connections" json:"connections"`
+}
+
+// connectionRegistry holds the profiles loaded from the profile file
+type connectionRegistry struct {
+	file     configFile
+	mu       sync.RWMutex
+	profiles map[string]*connectionProfile
+}
+
+// connectionProfiles is the registry used by the action handlers
+var connectionProfiles = &connectionRegistry{}
+
+// load reads the profiles from path and replaces the current ones. The
+// current profiles are kept if the file is invalid, and the file is watched
+// either way so that a fixed version is picked up.
+func (r *connectionRegistry) load(path string) error {
+	data, err := r.file.read(path)
+	if err != nil {
+		return fmt.Errorf("failed to read connection profiles: %w", err)
+	}
+	profiles, err := parseConnectionProfiles(path, data)
+	if err != nil {
+		return fmt.Errorf("invalid connection profiles in %s: %w", path, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.profiles = profiles
+	return nil
+}
+
+// reload reads the profile file again
+func (r *connectionRegistry) reload() error {
+	path := r.file.name()
+	if path == "" {
+		return nil
+	}
+	return r.load(path)
+}
+
+// reloadAndLog reloads the profiles and logs the outcome
+func (r *connectionRegistry) reloadAndLog(logger configLogger) {
+	if err := r.reload(); err != nil {
+		logger.Warnf("%v, keeping the previous connection profiles", err)
+		return
+	}
+	logger.Infof("Reloaded %d connection profiles", len(r.list()))
+}
+
+// changed reports whether the profile file changed since it was loaded
+func (r *connectionRegistry) changed() bool {
+	return r.file.changed()
+}
+
+// watch reloads the profiles when the file changes until stop is closed
+func (r *connectionRegistry) watch(interval time.Duration, stop <-chan struct{}, logger configLogger) {
+	r.file.watch(interval, stop, func() { r.reloadAndLog(logger) })
+}
+
+// get returns the profile called name
+func (r *connectionRegistry) get(name string) *connectionProfile {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.profiles[name]
+}
+
+// list returns the profiles sorted by name
+func (r *connectionRegistry) list() []*connectionProfile {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	profiles := make([]*connectionProfile, 0, len(r.profiles))
+	for _, profile := range r.profiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// parseConnectionProfiles parses a profile file
+func parseConnectionProfiles(path string, data []byte) (map[string]*connectionProfile, error) {
+	var file connectionFile
+	if err := unmarshalConfig(path, data, &file); err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string]*connectionProfile, len(file.Connections))
+	for name, entry := range file.Connections {
+		if name == "" || strings.ContainsAny(name, "/:") {
+			return nil, fmt.Errorf("invalid profile name %q", name)
+		}
+		if entry.URL == "" || strings.HasPrefix(entry.URL, connectionScheme) {
+			return nil, fmt.Errorf("profile %q needs the url of a BaseX server", name)
+		}
+		profile := &connectionProfile{
+			Name:            name,
+			baseXConnection: baseXConnection{URL: entry.URL, Username: entry.Username, Password: entry.Password},
+			Database:        entry.Database,
+			ReadOnly:        entry.ReadOnly,
+		}
+		if entry.Timeout != nil {
+			value := entry.Timeout
+			if n, ok := value.(int); ok {
+				value = float64(n)
+			}
+			timeout, err := parseTimeout(value)
+			if err != nil {
+				return nil, fmt.Errorf("profile %q: %w", name, err)
+			}
+			profile.Timeout = &timeout
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+// profileForDatabase returns the profile referenced by database, either by
+// a connection:// url or, without url, by an identifier naming a profile.
+// The identifier of database is set to the database of the profile if the
+// DataCatalog names none. It returns nil if no profile is referenced.
+func profileForDatabase(database *semantic.XMLDatabase) (*connectionProfile, error) {
+	if name, ok := strings.CutPrefix(database.URL, connectionScheme); ok {
+		profile := connectionProfiles.get(strings.TrimSuffix(name, "/"))
+		if profile == nil {
+			return nil, fmt.Errorf("unknown connection profile %q", name)
+		}
+		if database.Identifier == "" {
+			database.Identifier = profile.Database
+		}
+		return profile, nil
+	}
+	if database.URL == "" && database.Identifier != "" {
+		if profile := connectionProfiles.get(database.Identifier); profile != nil {
+			database.Identifier = profile.Database
+			return profile, nil
+		}
+	}
+	return nil, nil
+}
+
+// profileClient is the client for a connection profile. Writes fail on
+// read-only profiles.
+type profileClient struct {
+	basex.Client
+	profile *connectionProfile
+}
+
+func (c *profileClient) checkWritable() error {
+	if c.profile.ReadOnly {
+		return fmt.Errorf("%w: %s", errReadOnly, c.profile.Name)
+	}
+	return nil
+}
+
+// checkWritable returns an error if client uses a read-only connection
+// profile. Handlers that write call it before they start, so nothing is
+// written before a later step is refused.
+func checkWritable(client basex.Client) error {
+	if profile, ok := client.(*profileClient); ok {
+		return profile.checkWritable()
+	}
+	return nil
+}
+
+// checkQuery refuses updating queries on read-only profiles. BaseX is asked
+// whether the query is updating before it runs.
+func (c *profileClient) checkQuery(ctx context.Context, query *basex.Query) error {
+	if !c.profile.ReadOnly {
+		return nil
+	}
+	err := checkReadOnlyQuery(ctx, c.Client, query.Text)
+	if errors.Is(err, errUpdatingQuery) {
+		return fmt.Errorf("%w: %s refuses updating queries", errReadOnly, c.profile.Name)
+	}
+	return err
+}
+
+func (c *profileClient) Query(ctx context.Context, db string, query *basex.Query) ([]byte, error) {
+	if err := c.checkQuery(ctx, query); err != nil {
+		return nil, err
+	}
+	return c.Client.Query(ctx, db, query)
+}
+
+func (c *profileClient) QueryStream(ctx context.Context, db string, query *basex.Query) (io.ReadCloser, error) {
+	if err := c.checkQuery(ctx, query); err != nil {
+		return nil, err
+	}
+	return c.Client.QueryStream(ctx, db, query)
+}
+
+func (c *profileClient) CreateDatabase(ctx context.Context, name string) error {
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+	return c.Client.CreateDatabase(ctx, name)
+}
+
+func (c *profileClient) DropDatabase(ctx context.Context, name string) error {
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+	return c.Client.DropDatabase(ctx, name)
+}
+
+func (c *profileClient) PutDocument(ctx context.Context, db, path string, content io.Reader, contentType string) error {
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+	return c.Client.PutDocument(ctx, db, path, content, contentType)
+}
+
+func (c *profileClient) DeleteDocument(ctx context.Context, db, path string) error {
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+	return c.Client.DeleteDocument(ctx, db, path)
+}
+
+// Command is refused on read-only profiles, since commands may write
+func (c *profileClient) Command(ctx context.Context, command string) ([]byte, error) {
+	if err := c.checkWritable(); err != nil {
+		return nil, err
+	}
+	return c.Client.Command(ctx, command)
+}
+
+// listConnectionsREST handles REST GET /v1/api/connections. Passwords are
+// redacted.
+func listConnectionsREST(c echo.Context) error {
+	profiles := connectionProfiles.list()
+	items := make([]map[string]interface{}, 0, len(profiles))
+	for _, profile := range profiles {
+		properties := map[string]interface{}{
+			"readOnly": profile.ReadOnly,
+		}
+		if profile.Username != "" {
+			properties["username"] = profile.Username
+		}
+		if profile.Password != "" {
+			properties["password"] = redactedSecret
+		}
+		if profile.Database != "" {
+			properties["database"] = profile.Database
+		}
+		if profile.Timeout != nil {
+			properties["timeout"] = profile.Timeout.String()
+		}
+		items = append(items, map[string]interface{}{
+			"@type":              "DataCatalog",
+			"identifier":         profile.Name,
+			"url":                connectionScheme + profile.Name,
+			"sameAs":             redactURL(profile.URL),
+			"additionalProperty": properties,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"@context":        "https://schema.org",
+		"@type":           "ItemList",
+		"numberOfItems":   len(items),
+		"itemListElement": items,
+	})
+}
+
+// redactURL hides a password embedded in a URL
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redactedSecret
+	}
+	return u.Redacted()
+}
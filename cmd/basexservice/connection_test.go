@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// useDefaultConnection sets the server-side default connection
+func useDefaultConnection(t *testing.T, url, username, password string) {
+	t.Setenv("BASEX_URL", url)
+	t.Setenv("BASEX_USER", username)
+	t.Setenv("BASEX_PASSWORD", password)
+}
+
+func TestDefaultConnection(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("//item", "<item/>")
+	useDefaultConnection(t, server.URL, server.Username, server.Password)
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "//item",
+		"target": map[string]interface{}{"@type": "DataCatalog", "identifier": "db"},
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/queries", map[string]interface{}{
+		"query":    "//item",
+		"database": "db",
+	})
+	requireStatus(t, decodeAction(t, rec), "CompletedActionStatus")
+
+	// Per-request credentials still override the default ones
+	action = serveAction(t, e, map[string]interface{}{
+		"@type": "SearchAction",
+		"query": "//item",
+		"target": map[string]interface{}{
+			"@type":              "DataCatalog",
+			"identifier":         "db",
+			"additionalProperty": map[string]interface{}{"username": "admin", "password": "wrong"},
+		},
+	})
+	requireStatus(t, action, "FailedActionStatus")
+}
+
+func TestDefaultCredentialsStayWithDefaultServer(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("//item", "<item/>")
+	useDefaultConnection(t, "http://basex.internal:8080", server.Username, server.Password)
+
+	// A url given by the action must not receive the default credentials
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "//item",
+		"target": map[string]interface{}{"@type": "DataCatalog", "identifier": "db", "url": server.URL},
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if len(server.Queries()) != 0 {
+		t.Errorf("query ran with the default credentials: %+v", server.Queries())
+	}
+}
+
+func TestMissingConnection(t *testing.T) {
+	e, _ := newTestService(t)
+	useDefaultConnection(t, "", "", "")
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "//item",
+		"target": map[string]interface{}{"@type": "DataCatalog", "identifier": "db"},
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if message := errorMessage(action); !strings.Contains(message, "BASEX_URL") {
+		t.Errorf("error = %q", message)
+	}
+}
+
+func TestForbidRequestCredentials(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("//item", "<item/>")
+	useDefaultConnection(t, server.URL, server.Username, server.Password)
+	t.Setenv("BASEX_FORBID_REQUEST_CREDENTIALS", "true")
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "//item",
+		"target": catalog(server, "db"),
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if message := errorMessage(action); !strings.Contains(message, "disabled") {
+		t.Errorf("error = %q", message)
+	}
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/queries", map[string]interface{}{
+		"query":    "//item",
+		"database": "db",
+		"username": "admin",
+	})
+	requireStatus(t, decodeAction(t, rec), "FailedActionStatus")
+	if len(server.Queries()) != 0 {
+		t.Errorf("queries ran with per-request credentials: %+v", server.Queries())
+	}
+
+	action = serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "//item",
+		"target": map[string]interface{}{"@type": "DataCatalog", "identifier": "db"},
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+}
+
+func TestRequestCredentialsForbidden(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "false": false, "0": false, "true": true, "1": true, "yes please": true} {
+		t.Setenv("BASEX_FORBID_REQUEST_CREDENTIALS", value)
+		if got := requestCredentialsForbidden(); got != want {
+			t.Errorf("BASEX_FORBID_REQUEST_CREDENTIALS=%q: got %v, want %v", value, got, want)
+		}
+	}
+}
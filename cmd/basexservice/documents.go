@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"basexservice.evalgo.org/basex"
+	"eve.evalgo.org/semantic"
+	"github.com/labstack/echo/v4"
+)
+
+// executeDownloadAction handles DownloadAction and ReadAction on a
+// DigitalDocument, reading the resource named by its identifier back from
+// the database. The document is returned in the result, streamed to REST
+// callers, or written to the targetUrl (a local path or an s3:// URL).
+func executeDownloadActionImpl(c echo.Context, action *semantic.SemanticAction) error {
+	// ReadAction + DataCatalog = describe a database
+	if database := actionDatabase(action, "object"); database != nil {
+		return executeReadDatabaseAction(c, action, database)
+	}
+
+	xmlDoc, err := semantic.GetXMLDocumentFromAction(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract XML document", err)
+	}
+	if xmlDoc.Identifier == "" {
+		return semantic.ReturnActionError(c, action, "Document identifier is required", nil)
+	}
+
+	database, err := semantic.GetXMLDatabaseFromAction(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database", err)
+	}
+
+	client, err := clientForDatabase(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+
+	// Bound the BaseX requests by the action timeout and the client
+	// connection. Documents streamed to REST callers or written to a
+	// targetUrl are not bound by the default timeout.
+	raw, _ := c.Get(rawResultKey).(bool)
+	targetURL := semantic.GetTargetUrlFromAction(action)
+	newContext := baseXContext
+	if raw || targetURL != "" {
+		newContext = baseXStreamContext
+	}
+	ctx, cancel, timeout, err := newContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
+	doc, err := client.GetDocument(ctx, database.Identifier, xmlDoc.Identifier)
+	if err != nil {
+		if raw && errors.Is(err, basex.ErrNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return returnBaseXError(c, action, ctx, timeout, "Failed to read document", err)
+	}
+	defer func() { _ = doc.Body.Close() }()
+
+	contentType := doc.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	if targetURL != "" {
+		hash := sha256.New()
+		size, err := writeQueryStream(targetURL, contentType, io.TeeReader(doc.Body, hash))
+		if err != nil {
+			return returnBaseXError(c, action, ctx, timeout, "Failed to write document", err)
+		}
+		setActionProperty(action, "contentSize", size)
+		setActionProperty(action, "version", formatVersion(hash.Sum(nil)))
+
+		action.Result = &semantic.SemanticResult{
+			Type:   "MediaObject",
+			Format: contentType,
+			Output: targetURL, // contentUrl of the written document
+		}
+		semantic.SetSuccessOnAction(action)
+		return c.JSON(http.StatusOK, action)
+	}
+
+	// REST callers get the document as stored
+	if raw {
+		return c.Stream(http.StatusOK, contentType, doc.Body)
+	}
+
+	content, err := io.ReadAll(doc.Body)
+	if err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to read document", err)
+	}
+	setActionProperty(action, "contentSize", len(content))
+	sum := sha256.Sum256(content)
+	setActionProperty(action, "version", formatVersion(sum[:]))
+
+	// Binary resources do not fit into a JSON string as they are
+	output := string(content)
+	if !isTextMediaType(contentType) {
+		output = base64.StdEncoding.EncodeToString(content)
+		setActionProperty(action, "contentEncoding", "base64")
+	}
+
+	action.Result = &semantic.SemanticResult{
+		Type:   "MediaObject",
+		Format: contentType,
+		Output: output,
+	}
+	semantic.SetSuccessOnAction(action)
+	return c.JSON(http.StatusOK, action)
+}
+
+// isTextMediaType reports whether content of a media type is text, which
+// XML and JSON are
+func isTextMediaType(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "xml") || strings.Contains(contentType, "json")
+}
+
+// getDocumentREST handles REST GET /v1/api/databases/:name/documents/*
+// Converts to DownloadAction and delegates to semantic handler. The document
+// is returned as is, or written to the targetUrl query parameter.
+func getDocumentREST(c echo.Context) error {
+	// Routes match the escaped path if the request has one
+	path := c.Param("*")
+	if c.Request().URL.RawPath != "" {
+		unescaped, err := url.PathUnescape(path)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid document path: %v", err)})
+		}
+		path = unescaped
+	}
+	if path == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "document path is required"})
+	}
+	database, err := requestDatabaseObject(c, c.Param("name"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	action := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "DownloadAction",
+		"object": map[string]interface{}{
+			"@type":      "DigitalDocument",
+			"identifier": path,
+		},
+		"target": database,
+	}
+
+	if targetURL := c.QueryParam("targetUrl"); targetURL != "" {
+		action["targetUrl"] = targetURL
+		return callSemanticHandler(c, action)
+	}
+	return callSemanticHandlerWith(c, action, map[string]interface{}{rawResultKey: true})
+}
+
+// executeUpdateActionImpl handles UpdateAction. An XQuery Update or a list
+// of patch operations as instrument patches a document in place, and an
+// XSLT instrument is a transform, as documented for TransformAction.
+// Without an instrument it replaces a document like ReplaceAction.
+func executeUpdateActionImpl(c echo.Context, action *semantic.SemanticAction) error {
+	if actionProperty(action, "instrument") == nil {
+		return executeReplaceActionImpl(c, action)
+	}
+
+	patch, err := getDocumentPatch(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid patch", err)
+	}
+	if patch != nil {
+		return executePatchActionImpl(c, action, patch)
+	}
+	return executeTransformActionImpl(c, action)
+}
+
+// executeReplaceActionImpl handles ReplaceAction on a DigitalDocument. The
+// resource named by its identifier is replaced in one BaseX request with
+// the object's text or the file behind its contentUrl. createIfMissing false
+// refuses to create a missing resource, and version only replaces the
+// resource while its content hash is the given one.
+func executeReplaceActionImpl(c echo.Context, action *semantic.SemanticAction) error {
+	xmlDoc, err := semantic.GetXMLDocumentFromAction(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract XML document", err)
+	}
+	if xmlDoc.Identifier == "" {
+		return semantic.ReturnActionError(c, action, "Document identifier is required", nil)
+	}
+
+	createIfMissing := true
+	if value, ok := actionProperty(action, "createIfMissing").(bool); ok {
+		createIfMissing = value
+	}
+	version, _ := actionProperty(action, "version").(string)
+
+	content, err := readObjectContent(action, xmlDoc)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to read document content", err)
+	}
+	contentType := xmlDoc.EncodingFormat
+	if contentType == "" {
+		contentType = "application/xml"
+	}
+
+	database, err := semantic.GetXMLDatabaseFromAction(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database", err)
+	}
+
+	// Writes of the service to the database queue up behind each other
+	unlock, err := lockDatabases(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	defer unlock()
+
+	client, err := clientForDatabase(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
+	// Conditional replacements check and write in one XQuery Update, so no
+	// other BaseX client can change the document in between
+	if version != "" || !createIfMissing {
+		query := buildReplaceQuery(database.Identifier, xmlDoc.Identifier, content, contentType, version, createIfMissing)
+		_, err := client.Query(ctx, "", query)
+		var basexErr *basex.Error
+		switch {
+		case errors.As(err, &basexErr) && basexErr.Code == "bxs:missing":
+			return semantic.ReturnActionError(c, action, fmt.Sprintf("Document %q does not exist", xmlDoc.Identifier), nil)
+		case errors.As(err, &basexErr) && basexErr.Code == "bxs:conflict":
+			// The caller can retry against the current version
+			current := conflictVersion(basexErr.Message)
+			setActionProperty(action, "version", current)
+			return semantic.ReturnActionError(c, action, "Version conflict", fmt.Errorf("document %q is at version %s, not %s", xmlDoc.Identifier, current, version))
+		case err != nil:
+			return returnBaseXError(c, action, ctx, timeout, "Failed to replace document", err)
+		}
+	} else if err := client.PutDocument(ctx, database.Identifier, xmlDoc.Identifier, bytes.NewReader(content), contentType); err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to replace document", err)
+	}
+
+	// BaseX serializes XML documents anew, so the version is that of the
+	// stored document rather than of the content sent
+	current, err := documentVersion(ctx, client, database.Identifier, xmlDoc.Identifier)
+	if err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to read replaced document", err)
+	}
+	setActionProperty(action, "version", current)
+
+	semantic.SetSuccessOnAction(action)
+	return c.JSON(http.StatusOK, action)
+}
+
+// replaceQuery replaces a document unless it is missing and must not be
+// created, or its version differs from $replace-version. The version is
+// hashed from the document as BaseX serializes it for REST reads, and the
+// errors carry the bxs:missing and bxs:conflict codes, the latter with the
+// current version as message.
+const replaceQuery = `declare namespace bxs = 'urn:basexservice';
+declare variable $replace-db external;
+declare variable $replace-path external;
+declare variable $replace-content external;
+declare variable $replace-xml external;
+declare variable $replace-version external;
+declare variable $replace-create external;
+let $exists := db:exists($replace-db, $replace-path)
+let $current := if (not($exists)) then '' else lower-case(string(xs:hexBinary(hash:sha256(
+  if (db:content-type($replace-db, $replace-path) = 'application/xml')
+  then serialize(db:get($replace-db, $replace-path)[db:path(.) = $replace-path])
+  else db:get-binary($replace-db, $replace-path)
+))))
+return
+  if (not($exists) and (not($replace-create) or $replace-version != ''))
+  then error(xs:QName('bxs:missing'), 'document does not exist')
+  else if ($replace-version != '' and $current != $replace-version)
+  then error(xs:QName('bxs:conflict'), $current)
+  else if ($replace-xml)
+  then db:put($replace-db, parse-xml($replace-content), $replace-path)
+  else db:put-binary($replace-db, $replace-content, $replace-path)`
+
+// buildReplaceQuery builds the query replacing a document only while it is
+// at version. XML content types are stored as documents, all others as
+// binaries.
+func buildReplaceQuery(db, path string, content []byte, contentType, version string, createIfMissing bool) *basex.Query {
+	isXML := contentType == "application/xml" || contentType == "text/xml" || strings.HasSuffix(contentType, "+xml")
+	value, valueType := string(content), "xs:string"
+	if !isXML {
+		value, valueType = base64.StdEncoding.EncodeToString(content), "xs:base64Binary"
+	}
+	return &basex.Query{
+		Text: replaceQuery,
+		Variables: []basex.Variable{
+			{Name: "replace-db", Value: db, Type: "xs:string"},
+			{Name: "replace-path", Value: path, Type: "xs:string"},
+			{Name: "replace-content", Value: value, Type: valueType},
+			{Name: "replace-xml", Value: strconv.FormatBool(isXML), Type: "xs:boolean"},
+			{Name: "replace-version", Value: strings.TrimPrefix(strings.ToLower(strings.TrimSpace(version)), "sha256:"), Type: "xs:string"},
+			{Name: "replace-create", Value: strconv.FormatBool(createIfMissing), Type: "xs:boolean"},
+		},
+	}
+}
+
+// conflictVersion returns the current version reported by a bxs:conflict error
+func conflictVersion(message string) string {
+	if i := strings.Index(message, "[bxs:conflict]"); i >= 0 {
+		message = message[i+len("[bxs:conflict]"):]
+	}
+	return "sha256:" + strings.TrimSpace(message)
+}
+
+// readObjectContent returns the content of the action's object: its text,
+// or the local file or s3:// object named by its contentUrl
+func readObjectContent(action *semantic.SemanticAction, xmlDoc *semantic.XMLDocument) ([]byte, error) {
+	if object, ok := actionProperty(action, "object").(map[string]interface{}); ok {
+		if text, ok := object["text"].(string); ok {
+			return []byte(text), nil
+		}
+	}
+
+	switch {
+	case xmlDoc.ContentUrl == "":
+		return nil, errors.New("document text or contentUrl is required")
+	case strings.HasPrefix(xmlDoc.ContentUrl, "s3://"):
+		downloadedPath, err := downloadFromS3(xmlDoc.ContentUrl, xmlDoc.EncodingFormat)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = os.Remove(downloadedPath) }()
+		return os.ReadFile(downloadedPath)
+	default:
+		filePath, err := localFilePath(xmlDoc.ContentUrl)
+		if err != nil {
+			return nil, err
+		}
+		return readLocalFile(filePath)
+	}
+}
+
+// documentVersion returns the version of a stored document
+func documentVersion(ctx context.Context, client basex.Client, db, path string) (string, error) {
+	doc, err := client.GetDocument(ctx, db, path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = doc.Body.Close() }()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, doc.Body); err != nil {
+		return "", fmt.Errorf("failed to read document: %w", err)
+	}
+	return formatVersion(hash.Sum(nil)), nil
+}
+
+// formatVersion formats the SHA-256 hash of a document's content, as
+// returned by DownloadAction, as its version
+func formatVersion(sum []byte) string {
+	return "sha256:" + hex.EncodeToString(sum)
+}
+
+// keyedLocks hands out one mutex per key
+type keyedLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// databaseLocks serializes the writes of this process to a database, so
+// concurrent actions queue up instead of failing on each other's changes.
+// It spans one process only: checks that must hold while writing, such as
+// the version of a ReplaceAction, run on the server in the write query.
+var databaseLocks = &keyedLocks{locks: make(map[string]*keyedLock)}
+
+// lockDatabases locks databases for writing and returns the function
+// unlocking them. Databases are keyed by the server they resolve to, so a
+// connection profile and the same server given by url share a lock. Call it
+// before the databases are resolved, which fills in their identifiers.
+func lockDatabases(databases ...*semantic.XMLDatabase) (func(), error) {
+	keys := make([]string, 0, len(databases))
+	for _, database := range databases {
+		resolved := *database
+		connection, _, err := resolveConnection(&resolved)
+		if err != nil {
+			return nil, err
+		}
+		key := databaseLockKey(connection, resolved.Identifier)
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	// Locking in a fixed order keeps moves in opposite directions from
+	// deadlocking
+	sort.Strings(keys)
+	unlocks := make([]func(), 0, len(keys))
+	for _, key := range keys {
+		unlocks = append(unlocks, databaseLocks.lock(key))
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}, nil
+}
+
+// lockSystemDatabase locks the stored-query registry of the server database
+// resolves to
+func lockSystemDatabase(database *semantic.XMLDatabase) (func(), error) {
+	resolved := *database
+	connection, _, err := resolveConnection(&resolved)
+	if err != nil {
+		return nil, err
+	}
+	return databaseLocks.lock(databaseLockKey(connection, storedQueryDatabase())), nil
+}
+
+// databaseLockKey names a database of a server in databaseLocks
+func databaseLockKey(connection baseXConnection, db string) string {
+	return strings.ToLower(strings.TrimRight(connection.URL, "/")) + "|" + db
+}
+
+// lock locks key and returns the function unlocking it
+func (l *keyedLocks) lock(key string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"basexservice.evalgo.org/basex/basextest"
+	"eve.evalgo.org/semantic"
+)
+
+// testPNG is the content of a binary resource
+var testPNG = []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+
+func TestDownloadAction(t *testing.T) {
+	e, server := newTestService(t)
+	server.PutResource("db", "docs/doc.xml", "application/xml", []byte("<doc/>"))
+	server.PutResource("db", "images/logo.png", "image/png", testPNG)
+
+	download := func(actionType, identifier string, extra map[string]interface{}) map[string]interface{} {
+		action := map[string]interface{}{
+			"@type":  actionType,
+			"object": map[string]interface{}{"@type": "DigitalDocument", "identifier": identifier},
+			"target": catalog(server, "db"),
+		}
+		for key, value := range extra {
+			action[key] = value
+		}
+		return serveAction(t, e, action)
+	}
+
+	action := download("DownloadAction", "docs/doc.xml", nil)
+	requireStatus(t, action, "CompletedActionStatus")
+	if got := resultOutput(action); got != "<doc/>" {
+		t.Errorf("output = %q", got)
+	}
+	if result := action["result"].(map[string]interface{}); result["encodingFormat"] != "application/xml" {
+		t.Errorf("encodingFormat = %v", result["encodingFormat"])
+	}
+
+	// Binary resources are inlined as base64
+	action = download("ReadAction", "images/logo.png", nil)
+	requireStatus(t, action, "CompletedActionStatus")
+	if content, err := base64.StdEncoding.DecodeString(resultOutput(action)); err != nil || string(content) != string(testPNG) || action["contentEncoding"] != "base64" {
+		t.Errorf("binary output = %q (%v), contentEncoding %v", resultOutput(action), err, action["contentEncoding"])
+	}
+
+	// or written to the targetUrl
+	target := filepath.Join(t.TempDir(), "out", "logo.png")
+	action = download("DownloadAction", "images/logo.png", map[string]interface{}{"targetUrl": "file://" + target})
+	requireStatus(t, action, "CompletedActionStatus")
+	if data, err := os.ReadFile(target); err != nil || string(data) != string(testPNG) {
+		t.Errorf("written document = %q, %v", data, err)
+	}
+	if action["contentSize"] != float64(len(testPNG)) {
+		t.Errorf("contentSize = %v", action["contentSize"])
+	}
+
+	requireStatus(t, download("DownloadAction", "missing.xml", nil), "FailedActionStatus")
+}
+
+func TestGetDocumentREST(t *testing.T) {
+	e, server := newTestService(t)
+	server.PutResource("db", "docs/a b.xml", "application/xml", []byte("<doc/>"))
+	server.PutResource("db", "docs/100%.xml", "application/xml", []byte("<percent/>"))
+	server.PutResource("db", "images/logo.png", "image/png", testPNG)
+	baseURL := "?baseUrl=" + server.URL
+
+	for path, want := range map[string]struct{ contentType, body string }{
+		"docs/a%20b.xml":  {"application/xml", "<doc/>"},
+		"docs/100%25.xml": {"application/xml", "<percent/>"},
+		"images/logo.png": {"image/png", string(testPNG)},
+	} {
+		rec := serve(t, e, http.MethodGet, "/v1/api/databases/db/documents/"+path+baseURL, nil, basicAuth(server.Username, server.Password)...)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != want.contentType || rec.Body.String() != want.body {
+			t.Errorf("GET %s = %d %s %q", path, rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+		}
+	}
+
+	if rec := serve(t, e, http.MethodGet, "/v1/api/databases/db/documents/missing.xml"+baseURL, nil, basicAuth(server.Username, server.Password)...); rec.Code != http.StatusNotFound {
+		t.Errorf("missing document: status %d, want 404", rec.Code)
+	}
+
+	target := filepath.Join(t.TempDir(), "logo.png")
+	rec := serve(t, e, http.MethodGet, "/v1/api/databases/db/documents/images/logo.png"+baseURL+"&targetUrl="+target, nil, basicAuth(server.Username, server.Password)...)
+	requireStatus(t, decodeAction(t, rec), "CompletedActionStatus")
+	if data, err := os.ReadFile(target); err != nil || string(data) != string(testPNG) {
+		t.Errorf("written document = %q, %v", data, err)
+	}
+
+	// Credentials are not taken from the URL
+	rec = serve(t, e, http.MethodGet, "/v1/api/databases/db/documents/images/logo.png"+baseURL+"&username="+server.Username+"&password="+server.Password, nil)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "basic authentication") {
+		t.Errorf("credentials in the URL = %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDownloadActionOverProtocol(t *testing.T) {
+	e, _ := newTestService(t)
+	server := basextest.NewProtocolServer(t)
+	server.PutResource("db", "doc.xml", "application/xml", []byte("<doc/>"))
+	server.PutResource("db", "logo.png", "image/png", testPNG)
+	baseURL := "?baseUrl=" + server.URL
+
+	for path, want := range map[string]string{"doc.xml": "<doc/>", "logo.png": string(testPNG)} {
+		rec := serve(t, e, http.MethodGet, "/v1/api/databases/db/documents/"+path+baseURL, nil, basicAuth(server.Username, server.Password)...)
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("GET %s = %d %q", path, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := serve(t, e, http.MethodGet, "/v1/api/databases/db/documents/missing.xml"+baseURL, nil, basicAuth(server.Username, server.Password)...); rec.Code != http.StatusNotFound {
+		t.Errorf("missing document: status %d, want 404", rec.Code)
+	}
+}
+
+// versionOf returns the version of a document with the given content
+func versionOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// scriptReplace answers conditional replacements like BaseX would,
+// checking and writing the stored resource atomically
+func scriptReplace(server *basextest.Server) {
+	var mu sync.Mutex
+	server.HandleQuery("declare variable $replace-db external;", func(q *basextest.Query) basextest.Response {
+		mu.Lock()
+		defer mu.Unlock()
+		db, path, version := q.Variable("replace-db"), q.Variable("replace-path"), q.Variable("replace-version")
+		resource, exists := server.Resource(db, path)
+		switch {
+		case !exists && (q.Variable("replace-create") != "true" || version != ""):
+			return basextest.Response{Status: http.StatusBadRequest, Body: "Stopped at ., 1/1:\n[bxs:missing] document does not exist"}
+		case exists && version != "" && versionOf(string(resource.Content)) != "sha256:"+version:
+			return basextest.Response{Status: http.StatusBadRequest, Body: "Stopped at ., 1/1:\n[bxs:conflict] " + strings.TrimPrefix(versionOf(string(resource.Content)), "sha256:")}
+		}
+		if q.Variable("replace-xml") == "true" {
+			server.PutResource(db, path, "application/xml", []byte(q.Variable("replace-content")))
+		} else {
+			content, _ := base64.StdEncoding.DecodeString(q.Variable("replace-content"))
+			server.PutResource(db, path, "application/octet-stream", content)
+		}
+		return basextest.Response{}
+	})
+}
+
+func TestBuildReplaceQuery(t *testing.T) {
+	for _, tc := range []struct {
+		contentType, xml, value, valueType string
+	}{
+		{"application/xml", "true", "<a/>", "xs:string"},
+		{"application/xhtml+xml", "true", "<a/>", "xs:string"},
+		{"image/png", "false", base64.StdEncoding.EncodeToString([]byte("<a/>")), "xs:base64Binary"},
+	} {
+		query := buildReplaceQuery("db", "a.xml", []byte("<a/>"), tc.contentType, " SHA256:AB ", false)
+		values := map[string]string{}
+		for _, variable := range query.Variables {
+			values[variable.Name] = variable.Value
+			if variable.Name == "replace-content" && variable.Type != tc.valueType {
+				t.Errorf("%s: content type = %s, want %s", tc.contentType, variable.Type, tc.valueType)
+			}
+		}
+		if values["replace-xml"] != tc.xml || values["replace-content"] != tc.value || values["replace-version"] != "ab" || values["replace-create"] != "false" {
+			t.Errorf("%s: variables = %v", tc.contentType, values)
+		}
+	}
+	if got := conflictVersion("Stopped at ., 5/7:\n[bxs:conflict] 0b5f"); got != "sha256:0b5f" {
+		t.Errorf("conflictVersion = %q", got)
+	}
+}
+
+func TestReplaceAction(t *testing.T) {
+	e, server := newTestService(t)
+	server.PutResource("db", "docs/a.xml", "application/xml", []byte("<a/>"))
+	scriptReplace(server)
+
+	replace := func(actionType, identifier, text string, extra map[string]interface{}) map[string]interface{} {
+		action := map[string]interface{}{
+			"@type":  actionType,
+			"object": map[string]interface{}{"@type": "DigitalDocument", "identifier": identifier, "text": text},
+			"target": catalog(server, "db"),
+		}
+		for key, value := range extra {
+			action[key] = value
+		}
+		return serveAction(t, e, action)
+	}
+	content := func(path string) string {
+		resource, _ := server.Resource("db", path)
+		if resource == nil {
+			return ""
+		}
+		return string(resource.Content)
+	}
+
+	// DownloadAction reports the version to replace
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "DownloadAction",
+		"object": map[string]interface{}{"@type": "DigitalDocument", "identifier": "docs/a.xml"},
+		"target": catalog(server, "db"),
+	})
+	version, _ := action["version"].(string)
+	if version != versionOf("<a/>") {
+		t.Fatalf("version = %q, want %q", version, versionOf("<a/>"))
+	}
+
+	action = replace("ReplaceAction", "docs/a.xml", "<b/>", map[string]interface{}{"version": version})
+	requireStatus(t, action, "CompletedActionStatus")
+	if content("docs/a.xml") != "<b/>" || action["version"] != versionOf("<b/>") {
+		t.Errorf("replaced %q, version %v", content("docs/a.xml"), action["version"])
+	}
+
+	// A stale version is refused and the current one reported. The check
+	// is part of the write query rather than an earlier read.
+	requests := len(server.Requests())
+	action = replace("ReplaceAction", "docs/a.xml", "<c/>", map[string]interface{}{"version": version})
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "Version conflict") || action["version"] != versionOf("<b/>") || content("docs/a.xml") != "<b/>" {
+		t.Errorf("stale version: %s, version %v, content %q", errorMessage(action), action["version"], content("docs/a.xml"))
+	}
+	if sent := server.Requests()[requests:]; len(sent) != 1 || sent[0].Query == nil {
+		t.Errorf("stale replacement sent %+v, want the replace query only", sent)
+	}
+
+	// Missing documents are created unless createIfMissing is false
+	requireStatus(t, replace("ReplaceAction", "docs/new.xml", "<new/>", map[string]interface{}{"createIfMissing": false}), "FailedActionStatus")
+	if _, ok := server.Resource("db", "docs/new.xml"); ok {
+		t.Error("missing document was created")
+	}
+	requireStatus(t, replace("UpdateAction", "docs/new.xml", "<new/>", nil), "CompletedActionStatus")
+	if content("docs/new.xml") != "<new/>" {
+		t.Errorf("created %q", content("docs/new.xml"))
+	}
+}
+
+func TestReplaceActionConcurrent(t *testing.T) {
+	e, server := newTestService(t)
+	server.PutResource("db", "doc.xml", "application/xml", []byte("<v0/>"))
+	scriptReplace(server)
+
+	// Runs replacing the same version race; only one of them may win
+	var wg sync.WaitGroup
+	statuses := make(chan interface{}, 8)
+	for i := 0; i < cap(statuses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- serveAction(t, e, map[string]interface{}{
+				"@type":   "ReplaceAction",
+				"object":  map[string]interface{}{"@type": "DigitalDocument", "identifier": "doc.xml", "text": "<v1/>"},
+				"target":  catalog(server, "db"),
+				"version": versionOf("<v0/>"),
+			})["actionStatus"]
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	completed := 0
+	for status := range statuses {
+		if status == "CompletedActionStatus" {
+			completed++
+		}
+	}
+	if completed != 1 {
+		t.Errorf("%d replacements completed, want 1", completed)
+	}
+}
+
+func TestLockDatabasesSharedAcrossReferences(t *testing.T) {
+	_, server := newTestService(t)
+	useConnectionProfiles(t, "connections.yaml", testProfiles(server))
+
+	// A profile, its name as identifier and the server's url, with or
+	// without a trailing slash, name the same database
+	references := []*semantic.XMLDatabase{
+		{URL: "connection://scratch"},
+		{Identifier: "scratch"},
+		{URL: server.URL + "/", Identifier: "db", Username: server.Username, Password: server.Password},
+	}
+	unlock, err := lockDatabases(&semantic.XMLDatabase{URL: server.URL, Identifier: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, reference := range references {
+		locked := make(chan func())
+		go func() {
+			unlock, err := lockDatabases(reference)
+			if err != nil {
+				t.Error(err)
+				unlock = func() {}
+			}
+			locked <- unlock
+		}()
+		select {
+		case other := <-locked:
+			other()
+			t.Errorf("%+v was locked twice", reference)
+		case <-time.After(20 * time.Millisecond):
+		}
+		unlock()
+		unlock = <-locked
+	}
+	unlock()
+
+	// Locking does not resolve the databases passed
+	if reference := references[1]; reference.Identifier != "scratch" {
+		t.Errorf("identifier = %q", reference.Identifier)
+	}
+}
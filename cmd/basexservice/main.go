@@ -19,20 +19,37 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 )
 
-func main() {
-	// Initialize logger
-	logger := common.ServiceLogger("basexservice", "1.0.0")
-
-	// Register action handlers with the semantic action registry
-	// This allows the service to handle semantic actions without modifying switch statements
+// registerActionHandlers registers the action handlers with the semantic action registry
+// This allows the service to handle semantic actions without modifying switch statements
+func registerActionHandlers() {
 	semantic.MustRegister("TransformAction", executeTransformAction)
 	semantic.MustRegister("SearchAction", executeQueryAction)
 	semantic.MustRegister("CreateAction", handleCreateAction)
 	semantic.MustRegister("DeleteAction", handleDeleteAction)
 	semantic.MustRegister("UploadAction", handleUploadAction) // Handle UploadAction directly
+	semantic.MustRegister("DownloadAction", handleDownloadAction)
+	semantic.MustRegister("ReadAction", handleDownloadAction)
+	semantic.MustRegister("UpdateAction", handleUpdateAction)
+	semantic.MustRegister("ReplaceAction", handleReplaceAction)
+	semantic.MustRegister("MoveAction", handleMoveAction)
+}
+
+func main() {
+	// Initialize logger
+	logger := common.ServiceLogger("basexservice", "1.0.0")
+
+	// Register action handlers with the semantic action registry
+	registerActionHandlers()
+
+	onBaseXError = func(err error) {
+		logger.WithError(err).Warn("BaseX background request failed")
+	}
 
 	e := echo.New()
 
+	// Mask credentials in every JSON response
+	e.JSONSerializer = redactingJSONSerializer{}
+
 	// Register EVE corporate identity assets
 	web.RegisterAssets(e)
 
@@ -82,11 +99,66 @@ func main() {
 				Path:        "/v1/api/databases",
 				Description: "Create database (REST convenience - converts to CreateAction)",
 			},
+			{
+				Method:      "GET",
+				Path:        "/v1/api/databases",
+				Description: "List databases (REST convenience - converts to SearchAction)",
+			},
+			{
+				Method:      "GET",
+				Path:        "/v1/api/databases/:name",
+				Description: "Describe a database (REST convenience - converts to ReadAction)",
+			},
+			{
+				Method:      "GET",
+				Path:        "/v1/api/databases/:name/documents",
+				Description: "List the documents of a database (REST convenience - converts to SearchAction)",
+			},
 			{
 				Method:      "DELETE",
 				Path:        "/v1/api/databases/:name",
 				Description: "Delete database (REST convenience - converts to DeleteAction)",
 			},
+			{
+				Method:      "GET",
+				Path:        "/v1/api/databases/:name/documents/*",
+				Description: "Read a stored document (REST convenience - converts to DownloadAction)",
+			},
+			{
+				Method:      "POST",
+				Path:        "/v1/api/databases/:name/move",
+				Description: "Move a document or directory (REST convenience - converts to MoveAction)",
+			},
+			{
+				Method:      "POST",
+				Path:        "/v1/api/databases/:name/copy",
+				Description: "Copy a document or directory (REST convenience - converts to MoveAction)",
+			},
+			{
+				Method:      "GET",
+				Path:        "/v1/api/stored-queries",
+				Description: "List stored queries (REST convenience - converts to SearchAction)",
+			},
+			{
+				Method:      "GET",
+				Path:        "/v1/api/stored-queries/:name",
+				Description: "Get stored query versions (REST convenience - converts to SearchAction)",
+			},
+			{
+				Method:      "POST",
+				Path:        "/v1/api/stored-queries",
+				Description: "Register stored query version (REST convenience - converts to CreateAction)",
+			},
+			{
+				Method:      "DELETE",
+				Path:        "/v1/api/stored-queries/:name",
+				Description: "Delete stored query (REST convenience - converts to DeleteAction)",
+			},
+			{
+				Method:      "GET",
+				Path:        "/v1/api/connections",
+				Description: "List BaseX connection profiles with secrets redacted",
+			},
 			{
 				Method:      "GET",
 				Path:        "/health",
@@ -105,9 +177,18 @@ func main() {
 	apiGroup := e.Group("/v1/api")
 	sm.RegisterRoutes(apiGroup)
 
-	// API Key middleware
-	apiKey := os.Getenv("BASEX_API_KEY")
-	apiKeyMiddleware := evehttp.APIKeyMiddleware(apiKey)
+	// API Key middleware, accepting BASEX_API_KEY and the scoped keys of
+	// BASEX_API_KEYS_FILE
+	stopWatching := make(chan struct{})
+	if path := os.Getenv("BASEX_API_KEYS_FILE"); path != "" {
+		if err := apiKeys.load(path); err != nil {
+			logger.WithError(err).Error("Failed to load API keys, only BASEX_API_KEY is accepted")
+		} else {
+			logger.Infof("Loaded %d API keys from %s", apiKeys.count(), path)
+		}
+		go apiKeys.watch(configFilePollInterval, stopWatching, logger)
+	}
+	apiKeyMiddleware := scopedAPIKeyMiddleware(os.Getenv("BASEX_API_KEY"))
 
 	// Semantic action endpoint (primary interface)
 	apiGroup.POST("/semantic/action", handleSemanticAction, apiKeyMiddleware)
@@ -115,6 +196,35 @@ func main() {
 	// REST endpoints (convenience adapters that convert to semantic actions)
 	registerRESTEndpoints(apiGroup, apiKeyMiddleware)
 
+	// Named connection profiles, reloaded when the file changes or on SIGHUP
+	if path := os.Getenv("BASEX_CONNECTIONS_FILE"); path != "" {
+		if err := connectionProfiles.load(path); err != nil {
+			logger.WithError(err).Error("Failed to load connection profiles")
+		} else {
+			logger.Infof("Loaded %d connection profiles from %s", len(connectionProfiles.list()), path)
+		}
+		go connectionProfiles.watch(configFilePollInterval, stopWatching, logger)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if connectionProfiles.file.name() != "" {
+				connectionProfiles.reloadAndLog(logger)
+			}
+			if apiKeys.configured() {
+				apiKeys.reloadAndLog(logger)
+			}
+		}
+	}()
+
+	// Default BaseX connection for actions that do not name a server
+	if connection := defaultConnection(); connection.URL != "" {
+		logger.Infof("Default BaseX connection: %s", connection.URL)
+	} else if requestCredentialsForbidden() {
+		logger.Warn("BASEX_FORBID_REQUEST_CREDENTIALS is set without BASEX_URL, all BaseX actions will fail")
+	}
+
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -168,6 +278,7 @@ func main() {
 	<-quit
 
 	logger.Info("Shutting down server...")
+	close(stopWatching)
 
 	// Unregister from registry
 	if err := registry.AutoUnregister("basexservice"); err != nil {
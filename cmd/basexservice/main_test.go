@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"basexservice.evalgo.org/basex/basextest"
+	"github.com/labstack/echo/v4"
+)
+
+// The handlers are registered in init rather than TestMain, which the
+// integration tests define
+func init() {
+	registerActionHandlers()
+}
+
+// newTestService returns the service routes and a fake BaseX server
+func newTestService(t *testing.T) (*echo.Echo, *basextest.Server) {
+	t.Helper()
+	// Local files are refused unless their directories are allowed
+	t.Setenv("BASEX_FILE_ROOTS", os.TempDir())
+	e := echo.New()
+	e.JSONSerializer = redactingJSONSerializer{}
+	apiGroup := e.Group("/v1/api")
+	noAuth := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	apiGroup.POST("/semantic/action", handleSemanticAction, noAuth)
+	registerRESTEndpoints(apiGroup, noAuth)
+	return e, basextest.NewServer(t)
+}
+
+// catalog returns the DataCatalog addressing db on the fake server
+func catalog(server *basextest.Server, db string) map[string]interface{} {
+	return map[string]interface{}{
+		"@type":      "DataCatalog",
+		"identifier": db,
+		"url":        server.URL,
+		"additionalProperty": map[string]interface{}{
+			"username": server.Username,
+			"password": server.Password,
+		},
+	}
+}
+
+// basicAuth returns the Authorization header carrying BaseX credentials, for
+// serve
+func basicAuth(username, password string) []string {
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return []string{echo.HeaderAuthorization, "Basic " + credentials}
+}
+
+// serve sends a request with a JSON body to the service
+func serve(t *testing.T, e *echo.Echo, method, path string, body interface{}, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("failed to marshal request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// serveAction posts a semantic action and decodes the returned action
+func serveAction(t *testing.T, e *echo.Echo, action map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	action["@context"] = "https://schema.org"
+	return decodeAction(t, serve(t, e, http.MethodPost, "/v1/api/semantic/action", action))
+}
+
+// decodeAction decodes the action in a response
+func decodeAction(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var action map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &action); err != nil {
+		t.Fatalf("response is not JSON (status %d): %s", rec.Code, rec.Body.String())
+	}
+	return action
+}
+
+// requireStatus fails the test unless the action has the given actionStatus
+func requireStatus(t *testing.T, action map[string]interface{}, status string) {
+	t.Helper()
+	if action["actionStatus"] != status {
+		t.Fatalf("actionStatus = %v, want %s: %v", action["actionStatus"], status, action["error"])
+	}
+}
+
+// errorMessage returns the error message of a failed action
+func errorMessage(action map[string]interface{}) string {
+	if e, ok := action["error"].(map[string]interface{}); ok {
+		message, _ := e["message"].(string)
+		return message
+	}
+	return ""
+}
+
+// resultOutput returns the output of the action result
+func resultOutput(action map[string]interface{}) string {
+	if result, ok := action["result"].(map[string]interface{}); ok {
+		output, _ := result["output"].(string)
+		return output
+	}
+	return ""
+}
+
+// writeFile writes a file below the test's temporary directory
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"basexservice.evalgo.org/basex"
+	"eve.evalgo.org/semantic"
+	"github.com/labstack/echo/v4"
+)
+
+// documentMove moves or copies the resources at Path, a document or a
+// directory, to TargetPath
+type documentMove struct {
+	Source      string
+	Destination string
+	Path        string
+	TargetPath  string
+	Copy        bool
+}
+
+// moveQueryDeclarations declares the variables bound by buildMoveQuery and
+// the resources moved: the document at $move-path or those below it
+const moveQueryDeclarations = `declare variable $move-source external;
+declare variable $move-path external;
+declare variable $move-destination external;
+declare variable $move-target external;
+let $paths := db:list($move-source, $move-path)[. = $move-path or starts-with(., $move-path || '/')]
+return (
+  for $path in $paths
+  let $target := $move-target || substring($path, string-length($move-path) + 1)
+  return `
+
+// buildMoveQuery builds the XQuery Update query moving or copying resources
+// between databases of one server. It outputs the number of resources moved.
+func buildMoveQuery(move *documentMove) *basex.Query {
+	var update string
+	switch {
+	case move.Source == move.Destination && !move.Copy:
+		update = "db:rename($move-source, $path, $target)"
+	default:
+		// XML documents are copied as documents, all others as binaries
+		update = `if (db:content-type($move-source, $path) = 'application/xml')
+    then db:put($move-destination, db:get($move-source, $path)[db:path(.) = $path], $target)
+    else db:put-binary($move-destination, db:get-binary($move-source, $path), $target)`
+		if !move.Copy {
+			update = "(" + update + ",\n    db:delete($move-source, $path))"
+		}
+	}
+
+	return &basex.Query{
+		Text: moveQueryDeclarations + update + ",\n  update:output(count($paths))\n)",
+		Variables: []basex.Variable{
+			{Name: "move-source", Value: move.Source, Type: "xs:string"},
+			{Name: "move-path", Value: move.Path, Type: "xs:string"},
+			{Name: "move-destination", Value: move.Destination, Type: "xs:string"},
+			{Name: "move-target", Value: move.TargetPath, Type: "xs:string"},
+		},
+	}
+}
+
+// getDocumentMove returns the move described by an action on the source and
+// destination databases
+func getDocumentMove(action *semantic.SemanticAction, source, destination *semantic.XMLDatabase, sameServer bool) (*documentMove, error) {
+	xmlDoc, err := semantic.GetXMLDocumentFromAction(action)
+	if err != nil {
+		return nil, err
+	}
+	move := &documentMove{
+		Source:      source.Identifier,
+		Destination: destination.Identifier,
+		Path:        strings.Trim(xmlDoc.Identifier, "/"),
+	}
+	move.Copy, _ = actionProperty(action, "copy").(bool)
+	if move.Path == "" {
+		return nil, errors.New("document identifier is required")
+	}
+	if move.Source == "" || move.Destination == "" {
+		return nil, errors.New("database identifier is required")
+	}
+
+	// The path is kept unless the action renames it
+	move.TargetPath = move.Path
+	if targetPath, ok := actionProperty(action, "targetPath").(string); ok && strings.Trim(targetPath, "/") != "" {
+		move.TargetPath = strings.Trim(targetPath, "/")
+	}
+	if sameServer && move.Source == move.Destination {
+		if move.TargetPath == move.Path {
+			return nil, fmt.Errorf("%q would be moved onto itself", move.Path)
+		}
+		if strings.HasPrefix(move.TargetPath, move.Path+"/") {
+			return nil, fmt.Errorf("%q cannot be moved below itself", move.Path)
+		}
+	}
+	return move, nil
+}
+
+// executeMoveActionImpl handles MoveAction on a DigitalDocument. The
+// document or directory named by the object's identifier is moved from the
+// target database to the toLocation database, or renamed within the target
+// if toLocation is omitted. targetPath renames it, and copy keeps the
+// source. Moves on one server run as a single XQuery Update; between servers
+// the resources are copied one by one, and deleted from the source only once
+// all of them were copied.
+func executeMoveActionImpl(c echo.Context, action *semantic.SemanticAction) error {
+	source, err := semantic.GetXMLDatabaseFromAction(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database", err)
+	}
+	destination := actionDatabase(action, "toLocation")
+	if destination == nil {
+		destination = source
+	}
+
+	// Moves must not interleave with other writes to either database
+	unlock, err := lockDatabases(source, destination)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	defer unlock()
+
+	sourceConnection, sourceProfile, err := resolveConnection(source)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	destinationConnection, destinationProfile, err := resolveConnection(destination)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract destination credentials", err)
+	}
+	sameServer := sourceConnection.URL == destinationConnection.URL && sourceConnection.Username == destinationConnection.Username
+
+	move, err := getDocumentMove(action, source, destination, sameServer)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid move", err)
+	}
+
+	sourceClient, err := clientForConnection(sourceConnection, sourceProfile)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to create BaseX client", err)
+	}
+	destinationClient, err := clientForConnection(destinationConnection, destinationProfile)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to create BaseX client", err)
+	}
+
+	// Refuse read-only profiles before anything is written
+	if err := checkWritable(destinationClient); err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to move documents", err)
+	}
+	if !move.Copy {
+		if err := checkWritable(sourceClient); err != nil {
+			return semantic.ReturnActionError(c, action, "Failed to move documents", err)
+		}
+	}
+
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, sourceClient)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
+	var moved int
+	if sameServer {
+		result, err := sourceClient.Query(ctx, "", buildMoveQuery(move))
+		if err != nil {
+			return returnBaseXError(c, action, ctx, timeout, "Failed to move documents", err)
+		}
+		if moved, err = strconv.Atoi(strings.TrimSpace(string(result))); err != nil {
+			return semantic.ReturnActionError(c, action, "Failed to count moved documents", err)
+		}
+	} else {
+		if moved, err = copyDocuments(ctx, sourceClient, destinationClient, move); err != nil {
+			return returnBaseXError(c, action, ctx, timeout, "Failed to move documents", err)
+		}
+	}
+	if moved == 0 {
+		return semantic.ReturnActionError(c, action, fmt.Sprintf("Document %q does not exist", move.Path), nil)
+	}
+	setActionProperty(action, "affectedDocuments", moved)
+
+	semantic.SetSuccessOnAction(action)
+	return c.JSON(http.StatusOK, action)
+}
+
+// copyDocuments copies the resources of a move from one server to another
+// and, unless the move is a copy, deletes them from the source afterwards.
+// It returns the number of resources copied. Copies are not atomic: a
+// failure leaves the resources copied so far in place at both ends.
+func copyDocuments(ctx context.Context, source, destination basex.Client, move *documentMove) (int, error) {
+	documents, err := source.ListDocuments(ctx, move.Source, move.Path)
+	if err != nil {
+		return 0, err
+	}
+	var paths []string
+	for _, document := range documents {
+		if document.Path == move.Path || strings.HasPrefix(document.Path, move.Path+"/") {
+			paths = append(paths, document.Path)
+		}
+	}
+
+	for _, path := range paths {
+		target := move.TargetPath + strings.TrimPrefix(path, move.Path)
+		if err := copyDocument(ctx, source, destination, move.Source, path, move.Destination, target); err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if !move.Copy {
+		for _, path := range paths {
+			if err := source.DeleteDocument(ctx, move.Source, path); err != nil {
+				return 0, fmt.Errorf("failed to delete %s after copying it: %w", path, err)
+			}
+		}
+	}
+	return len(paths), nil
+}
+
+// copyDocument streams one resource from a source database to a destination
+func copyDocument(ctx context.Context, source, destination basex.Client, sourceDB, path, destinationDB, target string) error {
+	doc, err := source.GetDocument(ctx, sourceDB, path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = doc.Body.Close() }()
+
+	contentType := doc.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return destination.PutDocument(ctx, destinationDB, target, doc.Body, contentType)
+}
+
+// moveDocumentsREST handles REST POST /v1/api/databases/:name/move and
+// /v1/api/databases/:name/copy
+// Converts to MoveAction and delegates to semantic handler
+func moveDocumentsREST(copyMode bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req MoveRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid request: %v", err)})
+		}
+		if req.Path == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "path is required"})
+		}
+
+		action := map[string]interface{}{
+			"@context": "https://schema.org",
+			"@type":    "MoveAction",
+			"object": map[string]interface{}{
+				"@type":      "DigitalDocument",
+				"identifier": req.Path,
+			},
+			"target": databaseObject(c.Param("name"), req.BaseURL, req.Username, req.Password),
+			"copy":   copyMode,
+		}
+		if req.TargetPath != "" {
+			action["targetPath"] = req.TargetPath
+		}
+		if req.TargetDatabase != "" || req.TargetBaseURL != "" {
+			name := req.TargetDatabase
+			if name == "" {
+				name = c.Param("name")
+			}
+			action["toLocation"] = databaseObject(name, req.TargetBaseURL, req.TargetUsername, req.TargetPassword)
+		}
+		if req.Timeout != nil {
+			action["timeout"] = req.Timeout
+		}
+
+		return callSemanticHandler(c, action)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"basexservice.evalgo.org/basex/basextest"
+)
+
+func TestBuildMoveQuery(t *testing.T) {
+	for _, tc := range []struct {
+		move       documentMove
+		want, lack []string
+	}{
+		{
+			documentMove{Source: "db", Destination: "db", Path: "docs", TargetPath: "archive"},
+			[]string{"db:rename($move-source, $path, $target)", "update:output(count($paths))"},
+			[]string{"db:put", "db:delete"},
+		},
+		{
+			documentMove{Source: "db", Destination: "db", Path: "docs", TargetPath: "archive", Copy: true},
+			[]string{"db:put($move-destination, db:get($move-source, $path)[db:path(.) = $path], $target)", "db:put-binary($move-destination, db:get-binary($move-source, $path), $target)"},
+			[]string{"db:rename", "db:delete"},
+		},
+		{
+			documentMove{Source: "staging", Destination: "prod", Path: "docs", TargetPath: "docs"},
+			[]string{"db:put($move-destination", "db:delete($move-source, $path)"},
+			[]string{"db:rename"},
+		},
+	} {
+		query := buildMoveQuery(&tc.move)
+		for _, want := range tc.want {
+			if !strings.Contains(query.Text, want) {
+				t.Errorf("%+v: query lacks %q:\n%s", tc.move, want, query.Text)
+			}
+		}
+		for _, lack := range tc.lack {
+			if strings.Contains(query.Text, lack) {
+				t.Errorf("%+v: query has %q:\n%s", tc.move, lack, query.Text)
+			}
+		}
+	}
+}
+
+func TestMoveActionSameServer(t *testing.T) {
+	e, server := newTestService(t)
+	var moves []*basextest.Query
+	server.HandleQuery("declare variable $move-source external;", func(query *basextest.Query) basextest.Response {
+		moves = append(moves, query)
+		return basextest.Response{Body: "3"}
+	})
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":      "MoveAction",
+		"object":     map[string]interface{}{"@type": "DigitalDocument", "identifier": "/drafts/"},
+		"target":     catalog(server, "staging"),
+		"toLocation": catalog(server, "prod"),
+		"targetPath": "released",
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if action["affectedDocuments"] != float64(3) {
+		t.Errorf("affectedDocuments = %v", action["affectedDocuments"])
+	}
+	if len(moves) != 1 {
+		t.Fatalf("%d move queries, want 1", len(moves))
+	}
+	for name, want := range map[string]string{"move-source": "staging", "move-destination": "prod", "move-path": "drafts", "move-target": "released"} {
+		if got := moves[0].Variable(name); got != want {
+			t.Errorf("$%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// Moves onto or below the source are refused before BaseX is asked
+	for _, targetPath := range []string{"drafts", "drafts/old"} {
+		requireStatus(t, serveAction(t, e, map[string]interface{}{
+			"@type":      "MoveAction",
+			"object":     map[string]interface{}{"@type": "DigitalDocument", "identifier": "drafts"},
+			"target":     catalog(server, "staging"),
+			"targetPath": targetPath,
+		}), "FailedActionStatus")
+	}
+	if len(moves) != 1 {
+		t.Errorf("%d move queries, want 1", len(moves))
+	}
+}
+
+func TestMoveActionAcrossServers(t *testing.T) {
+	e, staging := newTestService(t)
+	prod := basextest.NewServer(t)
+	staging.PutResource("db", "docs/a.xml", "application/xml", []byte("<a/>"))
+	staging.PutResource("db", "docs/img/logo.png", "image/png", testPNG)
+	staging.PutResource("db", "docs2/b.xml", "application/xml", []byte("<b/>"))
+	prod.CreateDatabase("live")
+
+	move := func(copyMode bool) map[string]interface{} {
+		return serveAction(t, e, map[string]interface{}{
+			"@type":      "MoveAction",
+			"object":     map[string]interface{}{"@type": "DigitalDocument", "identifier": "docs"},
+			"target":     catalog(staging, "db"),
+			"toLocation": catalog(prod, "live"),
+			"copy":       copyMode,
+		})
+	}
+
+	action := move(true)
+	requireStatus(t, action, "CompletedActionStatus")
+	if action["affectedDocuments"] != float64(2) {
+		t.Errorf("affectedDocuments = %v", action["affectedDocuments"])
+	}
+	if got := strings.Join(prod.Resources("live"), ","); got != "docs/a.xml,docs/img/logo.png" {
+		t.Errorf("copied resources = %s", got)
+	}
+	if resource, _ := prod.Resource("live", "docs/img/logo.png"); resource == nil || string(resource.Content) != string(testPNG) || resource.ContentType != "image/png" {
+		t.Errorf("copied binary = %+v", resource)
+	}
+	if got := strings.Join(staging.Resources("db"), ","); got != "docs/a.xml,docs/img/logo.png,docs2/b.xml" {
+		t.Errorf("copy changed the source: %s", got)
+	}
+
+	requireStatus(t, move(false), "CompletedActionStatus")
+	if got := strings.Join(staging.Resources("db"), ","); got != "docs2/b.xml" {
+		t.Errorf("source after move = %s", got)
+	}
+
+	action = move(false)
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "does not exist") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+}
+
+func TestMoveActionReadOnlyProfile(t *testing.T) {
+	e, server := newTestService(t)
+	server.PutResource("IQS", "doc.xml", "application/xml", []byte("<doc/>"))
+	useConnectionProfiles(t, "connections.yaml", testProfiles(server))
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":      "MoveAction",
+		"object":     map[string]interface{}{"@type": "DigitalDocument", "identifier": "doc.xml"},
+		"target":     catalog(server, "scratch"),
+		"toLocation": map[string]interface{}{"@type": "DataCatalog", "url": "connection://prod"},
+		"copy":       true,
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if message := errorMessage(action); !strings.Contains(message, "read-only") {
+		t.Errorf("error = %q", message)
+	}
+	if len(server.Queries()) != 0 {
+		t.Errorf("BaseX was asked: %+v", server.Queries())
+	}
+}
+
+func TestMoveDocumentsREST(t *testing.T) {
+	e, staging := newTestService(t)
+	prod := basextest.NewServer(t)
+	staging.PutResource("db", "docs/a.xml", "application/xml", []byte("<a/>"))
+	prod.CreateDatabase("live")
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/databases/db/copy", map[string]interface{}{
+		"path":           "docs/a.xml",
+		"targetPath":     "released/a.xml",
+		"targetDatabase": "live",
+		"targetBaseUrl":  prod.URL,
+		"targetUsername": prod.Username,
+		"targetPassword": prod.Password,
+		"baseUrl":        staging.URL,
+		"username":       staging.Username,
+		"password":       staging.Password,
+	})
+	requireStatus(t, decodeAction(t, rec), "CompletedActionStatus")
+	if _, ok := prod.Resource("live", "released/a.xml"); !ok {
+		t.Errorf("copied resources = %v", prod.Resources("live"))
+	}
+	if _, ok := staging.Resource("db", "docs/a.xml"); !ok {
+		t.Error("copy removed the source")
+	}
+
+	if rec := serve(t, e, http.MethodPost, "/v1/api/databases/db/move", map[string]interface{}{}); rec.Code != http.StatusBadRequest {
+		t.Errorf("move without path: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"basexservice.evalgo.org/basex"
+	"eve.evalgo.org/semantic"
+)
+
+// defaultPageLimit is the page size used when only an offset or cursor is given
+const defaultPageLimit = 100
+
+// queryPage selects a window of the items returned by a query
+type queryPage struct {
+	Offset int
+	Limit  int
+}
+
+// getQueryPage returns the page requested by the action through its offset,
+// limit or cursor properties (or additionalProperty), or nil if the whole
+// result is requested. A cursor takes precedence over offset.
+func getQueryPage(action *semantic.SemanticAction) (*queryPage, error) {
+	lookup := func(key string) interface{} {
+		if value := actionProperty(action, key); value != nil {
+			return value
+		}
+		return actionAdditionalProperty(action, key)
+	}
+
+	offsetValue, limitValue, cursorValue := lookup("offset"), lookup("limit"), lookup("cursor")
+	if offsetValue == nil && limitValue == nil && cursorValue == nil {
+		return nil, nil
+	}
+
+	page := &queryPage{Limit: defaultPageLimit}
+	var err error
+	if offsetValue != nil {
+		if page.Offset, err = intValue(offsetValue); err != nil || page.Offset < 0 {
+			return nil, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	if limitValue != nil {
+		if page.Limit, err = intValue(limitValue); err != nil || page.Limit <= 0 {
+			return nil, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+	if cursor, ok := cursorValue.(string); ok && cursor != "" {
+		if page.Offset, err = decodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// intValue converts a JSON number or numeric string to an int
+func intValue(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("%v is not an integer", v)
+		}
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	}
+	return 0, fmt.Errorf("%v is not an integer", value)
+}
+
+// encodeCursor returns the opaque cursor addressing the item at offset
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+// decodeCursor returns the offset addressed by a cursor
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(data), "offset:"))
+	if err != nil || offset < 0 || !strings.HasPrefix(string(data), "offset:") {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return offset, nil
+}
+
+// nextCursor returns the cursor of the page following p, or "" if p is the last page
+func (p *queryPage) nextCursor(total int) string {
+	if next := p.Offset + p.Limit; next < total {
+		return encodeCursor(next)
+	}
+	return ""
+}
+
+// bounds returns the slice bounds of p within total items
+func (p *queryPage) bounds(total int) (int, int) {
+	start := min(p.Offset, total)
+	return start, min(start+p.Limit, total)
+}
+
+// buildPagedQuery wraps a query so BaseX counts all its items and serializes
+// only the requested page in a single evaluation. The result starts with the
+// number of items on a line of its own, read by readPageTotal, followed by
+// the page serialized with the original parameters. With itemList the page
+// is a JSON array of the items, each serialized on its own, read by
+// readPageItems. The prolog of the original query is kept in front of the
+// wrapper, and its database options are kept with the serialization.
+func buildPagedQuery(query string, page *queryPage, serialization *basex.Serialization, itemList bool) (string, *basex.Serialization) {
+	prolog, body := splitProlog(query)
+	paged := &basex.Serialization{
+		Parameters: []basex.Parameter{
+			{Name: "method", Value: "text"},
+			{Name: "item-separator", Value: "\n"},
+		},
+	}
+	var params []string
+	if serialization != nil {
+		for _, param := range serialization.Parameters {
+			params = append(params, basex.StringLiteral(param.Name)+": "+basex.StringLiteral(param.Value))
+			if param.Name == "encoding" {
+				paged.Parameters = append(paged.Parameters, param)
+			}
+		}
+		paged.Options = serialization.Options
+	}
+
+	serialized := fmt.Sprintf("serialize(subsequence($items, %d, %d), map { %s })", page.Offset+1, page.Limit, strings.Join(params, ", "))
+	if itemList {
+		serialized = fmt.Sprintf("serialize(array { subsequence($items, %d, %d) ! serialize(., map { %s }) }, map { 'method': 'json' })",
+			page.Offset+1, page.Limit, strings.Join(params, ", "))
+	}
+	wrapped := fmt.Sprintf("%slet $items := (\n%s\n)\nreturn (count($items), %s)", prolog, body, serialized)
+	return wrapped, paged
+}
+
+// readPageTotal reads the number of items heading the result of a query
+// wrapped by buildPagedQuery, leaving the page in result
+func readPageTotal(result *bufio.Reader) (int, error) {
+	line, err := result.ReadString('\n')
+	if err != nil {
+		return 0, fmt.Errorf("paged result lacks the item count")
+	}
+	return strconv.Atoi(strings.TrimSpace(line))
+}
+
+// readPageItems reads the items of a page read by readPageTotal from a query
+// wrapped by buildPagedQuery with itemList
+func readPageItems(result io.Reader) ([]string, error) {
+	items := []string{}
+	if err := json.NewDecoder(result).Decode(&items); err != nil {
+		return nil, fmt.Errorf("invalid page items: %w", err)
+	}
+	return items, nil
+}
+
+// newPageList returns the ItemList holding one page of total items and the
+// cursor of the following page
+func newPageList(items interface{}, total int, nextCursor string) map[string]interface{} {
+	list := map[string]interface{}{
+		"@context":        "https://schema.org",
+		"@type":           "ItemList",
+		"numberOfItems":   total,
+		"itemListElement": items,
+	}
+	if nextCursor != "" {
+		list["nextCursor"] = nextCursor
+	}
+	return list
+}
+
+// setPageProperties reports the total number of items and the cursor of the
+// following page on the action, and returns that cursor
+func setPageProperties(action *semantic.SemanticAction, page *queryPage, total int) string {
+	nextCursor := page.nextCursor(total)
+	setActionProperty(action, "numberOfItems", total)
+	if nextCursor != "" {
+		setActionProperty(action, "nextCursor", nextCursor)
+	}
+	return nextCursor
+}
+
+// splitProlog splits an XQuery main module into its prolog (version
+// declaration, imports and declarations, each terminated by a semicolon)
+// and its body
+func splitProlog(query string) (string, string) {
+	pos := 0
+	for {
+		start := skipIgnorable(query, pos)
+		if !startsDeclaration(query[start:]) {
+			return query[:start], query[start:]
+		}
+		end := declarationEnd(query, start)
+		if end < 0 {
+			return query[:start], query[start:]
+		}
+		pos = end + 1
+	}
+}
+
+// prologKeywords start the declarations of an XQuery prolog
+var prologKeywords = [][]string{
+	{"xquery", "version"},
+	{"xquery", "encoding"},
+	{"declare"},
+	{"import"},
+}
+
+// startsDeclaration reports whether s starts with a prolog declaration
+func startsDeclaration(s string) bool {
+	fields := strings.Fields(s)
+	for _, keywords := range prologKeywords {
+		if len(fields) <= len(keywords) {
+			continue
+		}
+		matched := true
+		for i, keyword := range keywords {
+			if fields[i] != keyword {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// skipIgnorable returns the position of the first character at or after pos
+// that is neither whitespace nor part of a comment
+func skipIgnorable(query string, pos int) int {
+	for pos < len(query) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(query[pos])):
+			pos++
+		case strings.HasPrefix(query[pos:], "(:"):
+			pos = commentEnd(query, pos)
+		default:
+			return pos
+		}
+	}
+	return pos
+}
+
+// commentEnd returns the position after the (possibly nested) comment at pos
+func commentEnd(query string, pos int) int {
+	depth := 0
+	for pos < len(query) {
+		switch {
+		case strings.HasPrefix(query[pos:], "(:"):
+			depth++
+			pos += 2
+		case strings.HasPrefix(query[pos:], ":)"):
+			depth--
+			pos += 2
+			if depth == 0 {
+				return pos
+			}
+		default:
+			pos++
+		}
+	}
+	return pos
+}
+
+// declarationEnd returns the position of the semicolon terminating the
+// declaration at pos, skipping string literals, comments and braced bodies,
+// or -1 if there is none
+func declarationEnd(query string, pos int) int {
+	depth := 0
+	for pos < len(query) {
+		switch ch := query[pos]; {
+		case ch == '"' || ch == '\'':
+			// Doubled quotes escape the delimiter inside a literal
+			pos++
+			for pos < len(query) {
+				if query[pos] == ch {
+					if pos+1 < len(query) && query[pos+1] == ch {
+						pos += 2
+						continue
+					}
+					break
+				}
+				pos++
+			}
+			pos++
+		case strings.HasPrefix(query[pos:], "(:"):
+			pos = commentEnd(query, pos)
+		case ch == '{':
+			depth++
+			pos++
+		case ch == '}':
+			depth--
+			pos++
+		case ch == ';' && depth == 0:
+			return pos
+		default:
+			pos++
+		}
+	}
+	return -1
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestQueryActionCursor(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("subsequence($items", `5
+["<d/>","<e/>"]`)
+
+	// The cursor addresses the next page, which is the last one
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "//item",
+		"limit":  2,
+		"cursor": encodeCursor(3),
+		"target": catalog(server, "db"),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if _, ok := action["nextCursor"]; ok {
+		t.Errorf("nextCursor on the last page = %v", action["nextCursor"])
+	}
+	var list map[string]interface{}
+	if err := json.Unmarshal([]byte(resultOutput(action)), &list); err != nil || list["numberOfItems"] != float64(5) {
+		t.Errorf("page list = %s (%v)", resultOutput(action), err)
+	}
+	if _, ok := list["nextCursor"]; ok {
+		t.Errorf("page list has a nextCursor on the last page: %v", list["nextCursor"])
+	}
+	if paged := server.Queries()[0].Text; !strings.Contains(paged, "subsequence($items, 4, 2)") {
+		t.Errorf("cursor page query:\n%s", paged)
+	}
+
+	// Invalid pages are refused before BaseX is asked
+	for key, value := range map[string]interface{}{"cursor": "not-a-cursor", "limit": 0, "offset": -1} {
+		requireStatus(t, serveAction(t, e, map[string]interface{}{
+			"@type":  "SearchAction",
+			"query":  "//item",
+			key:      value,
+			"target": catalog(server, "db"),
+		}), "FailedActionStatus")
+	}
+	if len(server.Queries()) != 1 {
+		t.Errorf("%d queries, want 1", len(server.Queries()))
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	for _, offset := range []int{0, 1, 250} {
+		if got, err := decodeCursor(encodeCursor(offset)); err != nil || got != offset {
+			t.Errorf("cursor of %d = %d, %v", offset, got, err)
+		}
+	}
+	for _, cursor := range []string{"", "!!", base64.RawURLEncoding.EncodeToString([]byte("3")), base64.RawURLEncoding.EncodeToString([]byte("offset:-1")), base64.RawURLEncoding.EncodeToString([]byte("offset:x"))} {
+		if _, err := decodeCursor(cursor); err == nil {
+			t.Errorf("%q: no error", cursor)
+		}
+	}
+}
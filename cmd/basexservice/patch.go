@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"basexservice.evalgo.org/basex"
+	"eve.evalgo.org/semantic"
+	"github.com/labstack/echo/v4"
+)
+
+// patchOperation is one declarative change of a document patch. Path is an
+// XPath expression evaluated against the document.
+type patchOperation struct {
+	// Operation is insert, replace, delete, rename or setAttribute
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	// Value is the XML fragment inserted or replacing the nodes, or the
+	// value of the attribute set
+	Value *string `json:"value,omitempty"`
+	// Name is the new name of renamed nodes or the name of the attribute set
+	Name string `json:"name,omitempty"`
+	// Position places inserted nodes: into (the default, as last child),
+	// first, last, before or after the selected nodes
+	Position string `json:"position,omitempty"`
+}
+
+// documentPatch changes a stored document in place, either with an XQuery
+// Update expression or with a list of operations
+type documentPatch struct {
+	Expression string
+	Operations []patchOperation
+	// Namespaces are the prefixes usable in the paths and names of operations
+	Namespaces map[string]string
+}
+
+// insertPositions maps the insert positions to XQuery Update target clauses
+var insertPositions = map[string]string{
+	"":       "into",
+	"into":   "into",
+	"first":  "as first into",
+	"last":   "as last into",
+	"before": "before",
+	"after":  "after",
+}
+
+// namespacePrefixPattern matches the namespace prefixes of patch operations
+var namespacePrefixPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// getDocumentPatch returns the patch in the action's instrument: a
+// SoftwareSourceCode in XQuery, or a list (or ItemList) of operations. It
+// returns nil if the instrument is not a patch, such as an XSLT stylesheet.
+func getDocumentPatch(action *semantic.SemanticAction) (*documentPatch, error) {
+	var items []interface{}
+	switch instrument := actionProperty(action, "instrument").(type) {
+	case []interface{}:
+		items = instrument
+	case map[string]interface{}:
+		if instrument["@type"] == "ItemList" {
+			items, _ = instrument["itemListElement"].([]interface{})
+			break
+		}
+		language, _ := instrument["programmingLanguage"].(string)
+		if !strings.HasPrefix(strings.ToLower(language), "xquery") {
+			return nil, nil
+		}
+		text, _ := instrument["text"].(string)
+		if strings.TrimSpace(text) == "" {
+			return nil, errors.New("the XQuery Update expression in instrument.text is required")
+		}
+		return &documentPatch{Expression: text}, nil
+	}
+
+	// Stylesheet pipelines are lists too; operations are told apart by
+	// their operation property
+	if len(items) == 0 {
+		return nil, nil
+	}
+	if first, ok := items[0].(map[string]interface{}); !ok || first["operation"] == nil {
+		return nil, nil
+	}
+
+	patch := &documentPatch{}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &patch.Operations); err != nil {
+		return nil, fmt.Errorf("invalid patch operations: %w", err)
+	}
+
+	namespaces, ok := actionProperty(action, "namespaces").(map[string]interface{})
+	if !ok {
+		namespaces, _ = actionAdditionalProperty(action, "namespaces").(map[string]interface{})
+	}
+	patch.Namespaces = make(map[string]string, len(namespaces))
+	for prefix, uri := range namespaces {
+		value, ok := uri.(string)
+		if !ok || !namespacePrefixPattern.MatchString(prefix) {
+			return nil, fmt.Errorf("invalid namespace %q", prefix)
+		}
+		patch.Namespaces[prefix] = value
+	}
+	return patch, nil
+}
+
+// buildPatchQuery builds the XQuery Update query applying a patch to the
+// document $patch-path of database $patch-db. Operation queries output the
+// number of affected nodes.
+func buildPatchQuery(patch *documentPatch, db, path string) (string, []basex.Variable, error) {
+	variables := []basex.Variable{
+		{Name: "patch-db", Value: db, Type: "xs:string"},
+		{Name: "patch-path", Value: path, Type: "xs:string"},
+	}
+	declarations := "declare variable $patch-db external;\ndeclare variable $patch-path external;\n"
+
+	// The document is the context item of an expression
+	if patch.Expression != "" {
+		prolog, body := splitProlog(patch.Expression)
+		query := prolog + declarations + "declare context item := doc($patch-db || '/' || $patch-path);\n" + body
+		return query, variables, nil
+	}
+
+	if len(patch.Operations) == 0 {
+		return "", nil, errors.New("patch has no operations")
+	}
+
+	var prolog, lets, updates strings.Builder
+	prefixes := make([]string, 0, len(patch.Namespaces))
+	for prefix := range patch.Namespaces {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		fmt.Fprintf(&prolog, "declare namespace %s = %s;\n", prefix, basex.StringLiteral(patch.Namespaces[prefix]))
+	}
+	prolog.WriteString(declarations)
+
+	counts := make([]string, 0, len(patch.Operations))
+	bind := func(name, value string) string {
+		variables = append(variables, basex.Variable{Name: name, Value: value, Type: "xs:string"})
+		fmt.Fprintf(&prolog, "declare variable $%s external;\n", name)
+		return "$" + name
+	}
+
+	for i, op := range patch.Operations {
+		if strings.TrimSpace(op.Path) == "" {
+			return "", nil, fmt.Errorf("operation %d: path is required", i+1)
+		}
+		nodes := "$patch-nodes" + strconv.Itoa(i+1)
+		fmt.Fprintf(&lets, "let %s := $patch-doc ! (%s)\n", nodes, op.Path)
+		counts = append(counts, "count("+nodes+")")
+
+		value := func() (string, error) {
+			if op.Value == nil {
+				return "", fmt.Errorf("operation %d: %s needs a value", i+1, op.Operation)
+			}
+			return bind("patch-value"+strconv.Itoa(i+1), *op.Value), nil
+		}
+		name := func() (string, error) {
+			if op.Name == "" {
+				return "", fmt.Errorf("operation %d: %s needs a name", i+1, op.Operation)
+			}
+			return bind("patch-name"+strconv.Itoa(i+1), op.Name), nil
+		}
+
+		var update string
+		switch op.Operation {
+		case "insert":
+			position, ok := insertPositions[op.Position]
+			if !ok {
+				return "", nil, fmt.Errorf("operation %d: unknown position %q", i+1, op.Position)
+			}
+			fragment, err := value()
+			if err != nil {
+				return "", nil, err
+			}
+			update = fmt.Sprintf("insert node parse-xml-fragment(%s)/node() %s $node", fragment, position)
+		case "replace":
+			fragment, err := value()
+			if err != nil {
+				return "", nil, err
+			}
+			update = fmt.Sprintf("replace node $node with parse-xml-fragment(%s)/node()", fragment)
+		case "delete":
+			update = "delete node $node"
+		case "rename":
+			newName, err := name()
+			if err != nil {
+				return "", nil, err
+			}
+			update = fmt.Sprintf("rename node $node as %s", newName)
+		case "setAttribute":
+			attribute, err := name()
+			if err != nil {
+				return "", nil, err
+			}
+			attributeValue, err := value()
+			if err != nil {
+				return "", nil, err
+			}
+			update = fmt.Sprintf("let $attribute := $node/@*[name() = %[1]s]\n    return if ($attribute) then replace value of node $attribute with %[2]s\n    else insert node attribute { %[1]s } { %[2]s } into $node", attribute, attributeValue)
+		default:
+			return "", nil, fmt.Errorf("operation %d: unknown operation %q", i+1, op.Operation)
+		}
+		fmt.Fprintf(&updates, "  for $node in %s return %s,\n", nodes, update)
+	}
+
+	query := prolog.String() +
+		"let $patch-doc := doc($patch-db || '/' || $patch-path)\n" +
+		lets.String() +
+		"return (\n" + updates.String() +
+		"  update:output(" + strings.Join(counts, " + ") + ")\n)"
+	return query, variables, nil
+}
+
+// executePatchActionImpl handles UpdateAction with a patch instrument. The
+// patch is applied to the XML document named by the object's identifier in
+// one XQuery Update, and operation patches report the number of nodes they
+// changed as affectedNodes. Expression patches report no count: the updates of
+// an XQuery Update only apply when the query ends, so the query cannot see
+// what the expression changed, and its update:output becomes the result.
+func executePatchActionImpl(c echo.Context, action *semantic.SemanticAction, patch *documentPatch) error {
+	xmlDoc, err := semantic.GetXMLDocumentFromAction(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract XML document", err)
+	}
+	if xmlDoc.Identifier == "" {
+		return semantic.ReturnActionError(c, action, "Document identifier is required", nil)
+	}
+
+	database, err := semantic.GetXMLDatabaseFromAction(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database", err)
+	}
+
+	query, variables, err := buildPatchQuery(patch, database.Identifier, xmlDoc.Identifier)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid patch", err)
+	}
+
+	// Patches must not interleave with conditional replacements
+	unlock, err := lockDatabases(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	defer unlock()
+
+	client, err := clientForDatabase(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	if err := checkWritable(client); err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to patch document", err)
+	}
+
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
+	// Only XML documents can be patched, which the listing tells without
+	// reading the document
+	documents, err := client.ListDocuments(ctx, database.Identifier, xmlDoc.Identifier)
+	if err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to find document", err)
+	}
+	var document *basex.DocumentInfo
+	for i := range documents {
+		if documents[i].Path == xmlDoc.Identifier {
+			document = &documents[i]
+		}
+	}
+	if document == nil {
+		return semantic.ReturnActionError(c, action, fmt.Sprintf("Document %q does not exist", xmlDoc.Identifier), nil)
+	}
+	if !strings.Contains(document.ContentType, "xml") && !strings.Contains(document.ContentType, "json") {
+		return semantic.ReturnActionError(c, action, fmt.Sprintf("Document %q is a %s resource and cannot be patched", xmlDoc.Identifier, document.ContentType), nil)
+	}
+
+	result, err := client.Query(ctx, "", &basex.Query{Text: query, Variables: variables})
+	if err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to patch document", err)
+	}
+
+	if patch.Expression == "" {
+		affected, err := strconv.Atoi(strings.TrimSpace(string(result)))
+		if err != nil {
+			return semantic.ReturnActionError(c, action, "Failed to count affected nodes", err)
+		}
+		setActionProperty(action, "affectedNodes", affected)
+	} else if len(result) > 0 {
+		// Whatever the expression outputs with update:output
+		action.Result = &semantic.SemanticResult{
+			Type:   "Dataset",
+			Format: "application/xml",
+			Output: string(result),
+		}
+	}
+
+	semantic.SetSuccessOnAction(action)
+	return c.JSON(http.StatusOK, action)
+}
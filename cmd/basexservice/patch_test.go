@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"basexservice.evalgo.org/basex/basextest"
+	"eve.evalgo.org/semantic"
+)
+
+func TestBuildPatchQuery(t *testing.T) {
+	value := func(s string) *string { return &s }
+	patch := &documentPatch{
+		Operations: []patchOperation{
+			{Operation: "insert", Path: "//skos:Concept[@id = 'c1']", Value: value("<skos:note/>"), Position: "first"},
+			{Operation: "replace", Path: "//label", Value: value("<label>New</label>")},
+			{Operation: "delete", Path: "//draft"},
+			{Operation: "rename", Path: "//old", Name: "new"},
+			{Operation: "setAttribute", Path: "/scheme", Name: "status", Value: value("released")},
+		},
+		Namespaces: map[string]string{"skos": "http://www.w3.org/2004/02/skos/core#"},
+	}
+	query, variables, err := buildPatchQuery(patch, "db", "scheme.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`declare namespace skos = "http://www.w3.org/2004/02/skos/core#";`,
+		"let $patch-nodes1 := $patch-doc ! (//skos:Concept[@id = 'c1'])",
+		"insert node parse-xml-fragment($patch-value1)/node() as first into $node",
+		"replace node $node with parse-xml-fragment($patch-value2)/node()",
+		"for $node in $patch-nodes3 return delete node $node",
+		"rename node $node as $patch-name4",
+		"replace value of node $attribute with $patch-value5",
+		"insert node attribute { $patch-name5 } { $patch-value5 } into $node",
+		"update:output(count($patch-nodes1) + count($patch-nodes2) + count($patch-nodes3) + count($patch-nodes4) + count($patch-nodes5))",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query lacks %q:\n%s", want, query)
+		}
+	}
+	bound := map[string]string{}
+	for _, variable := range variables {
+		bound[variable.Name] = variable.Value
+	}
+	if bound["patch-db"] != "db" || bound["patch-path"] != "scheme.xml" || bound["patch-value1"] != "<skos:note/>" || bound["patch-name4"] != "new" {
+		t.Errorf("variables = %+v", variables)
+	}
+
+	// Expressions keep their prolog in front of the document context
+	query, _, err = buildPatchQuery(&documentPatch{Expression: "declare namespace x = 'urn:x';\ndelete node //x:draft"}, "db", "scheme.xml")
+	if err != nil || !strings.HasPrefix(query, "declare namespace x = 'urn:x';") || !strings.Contains(query, "declare context item := doc($patch-db || '/' || $patch-path);\ndelete node //x:draft") {
+		t.Errorf("expression query = %q, %v", query, err)
+	}
+
+	for _, op := range []patchOperation{
+		{Operation: "insert", Path: "/a"},
+		{Operation: "insert", Path: "/a", Value: value("<b/>"), Position: "inside"},
+		{Operation: "rename", Path: "/a"},
+		{Operation: "setAttribute", Path: "/a", Name: "b"},
+		{Operation: "delete", Path: " "},
+		{Operation: "move", Path: "/a"},
+	} {
+		if _, _, err := buildPatchQuery(&documentPatch{Operations: []patchOperation{op}}, "db", "doc.xml"); err == nil {
+			t.Errorf("accepted %+v", op)
+		}
+	}
+}
+
+func TestGetDocumentPatch(t *testing.T) {
+	for _, tc := range []struct {
+		instrument interface{}
+		patch      bool
+	}{
+		{map[string]interface{}{"@type": "SoftwareSourceCode", "programmingLanguage": "XQuery", "text": "delete node //a"}, true},
+		{[]interface{}{map[string]interface{}{"operation": "delete", "path": "//a"}}, true},
+		{map[string]interface{}{"@type": "ItemList", "itemListElement": []interface{}{map[string]interface{}{"operation": "delete", "path": "//a"}}}, true},
+		{map[string]interface{}{"@type": "SoftwareSourceCode", "programmingLanguage": "XSLT", "contentUrl": "/a.xsl"}, false},
+		{[]interface{}{map[string]interface{}{"@type": "SoftwareSourceCode", "contentUrl": "/a.xsl"}}, false},
+	} {
+		action := &semantic.SemanticAction{Type: "UpdateAction", Properties: map[string]interface{}{"instrument": tc.instrument}}
+		patch, err := getDocumentPatch(action)
+		if err != nil || (patch != nil) != tc.patch {
+			t.Errorf("%v: patch = %+v, %v", tc.instrument, patch, err)
+		}
+	}
+}
+
+func TestPatchAction(t *testing.T) {
+	e, server := newTestService(t)
+	server.PutResource("db", "scheme.xml", "application/xml", []byte("<scheme/>"))
+	server.PutResource("db", "logo.png", "image/png", testPNG)
+	server.HandleQuery("update:output(count(", func(query *basextest.Query) basextest.Response {
+		return basextest.Response{Body: "2"}
+	})
+	server.RespondToQuery("declare context item", "")
+
+	patch := func(identifier string, instrument interface{}) map[string]interface{} {
+		return serveAction(t, e, map[string]interface{}{
+			"@type":      "UpdateAction",
+			"object":     map[string]interface{}{"@type": "DigitalDocument", "identifier": identifier},
+			"instrument": instrument,
+			"target":     catalog(server, "db"),
+		})
+	}
+	operations := []interface{}{
+		map[string]interface{}{"operation": "setAttribute", "path": "//concept", "name": "status", "value": "released"},
+	}
+
+	action := patch("scheme.xml", operations)
+	requireStatus(t, action, "CompletedActionStatus")
+	if action["affectedNodes"] != float64(2) {
+		t.Errorf("affectedNodes = %v", action["affectedNodes"])
+	}
+	queries := server.Queries()
+	if query := queries[len(queries)-1]; query.Variable("patch-path") != "scheme.xml" || query.Variable("patch-value1") != "released" {
+		t.Errorf("patch query variables = %+v", query.Variables)
+	}
+
+	action = patch("scheme.xml", map[string]interface{}{
+		"@type":               "SoftwareSourceCode",
+		"programmingLanguage": "XQuery",
+		"text":                "delete node //draft",
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if _, ok := action["affectedNodes"]; ok {
+		t.Errorf("expression patch reported affectedNodes = %v", action["affectedNodes"])
+	}
+
+	for identifier, message := range map[string]string{"missing.xml": "does not exist", "logo.png": "cannot be patched"} {
+		if action := patch(identifier, operations); action["actionStatus"] != "FailedActionStatus" || !strings.Contains(errorMessage(action), message) {
+			t.Errorf("%s: %v %s", identifier, action["actionStatus"], errorMessage(action))
+		}
+	}
+	requireStatus(t, patch("scheme.xml", []interface{}{map[string]interface{}{"operation": "move", "path": "//a"}}), "FailedActionStatus")
+}
+
+func TestPatchActionReadOnlyProfile(t *testing.T) {
+	e, server := newTestService(t)
+	server.PutResource("IQS", "doc.xml", "application/xml", []byte("<doc/>"))
+	useConnectionProfiles(t, "connections.yaml", testProfiles(server))
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":      "UpdateAction",
+		"object":     map[string]interface{}{"@type": "DigitalDocument", "identifier": "doc.xml"},
+		"instrument": []interface{}{map[string]interface{}{"operation": "delete", "path": "//doc"}},
+		"target":     map[string]interface{}{"@type": "DataCatalog", "url": "connection://prod"},
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if message := errorMessage(action); !strings.Contains(message, "read-only") {
+		t.Errorf("error = %q", message)
+	}
+	if len(server.Queries()) != 0 {
+		t.Errorf("patch was sent: %+v", server.Queries())
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"basexservice.evalgo.org/basex"
+	"eve.evalgo.org/semantic"
+)
+
+// serializationParameterNames maps the accepted serialization keys, including
+// camelCase aliases, to BaseX serialization parameter names
+var serializationParameterNames = map[string]string{
+	"method":               "method",
+	"indent":               "indent",
+	"omit-xml-declaration": "omit-xml-declaration",
+	"omitXmlDeclaration":   "omit-xml-declaration",
+	"encoding":             "encoding",
+	"media-type":           "media-type",
+	"mediaType":            "media-type",
+	"item-separator":       "item-separator",
+	"itemSeparator":        "item-separator",
+	"json":                 "json",
+	"csv":                  "csv",
+}
+
+// getQuerySerialization returns the serialization settings of the action,
+// taken from its serialization property or additionalProperty.serialization.
+// jsonFormat is a shorthand for the format of the json parameter, and an
+// options map is sent as database options.
+func getQuerySerialization(action *semantic.SemanticAction) (*basex.Serialization, error) {
+	raw, ok := actionProperty(action, "serialization").(map[string]interface{})
+	if !ok {
+		raw, _ = actionAdditionalProperty(action, "serialization").(map[string]interface{})
+	}
+	return parseQuerySerialization(raw)
+}
+
+// parseQuerySerialization converts a serialization map into query parameters
+func parseQuerySerialization(raw map[string]interface{}) (*basex.Serialization, error) {
+	serialization := &basex.Serialization{}
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := raw[key]
+		switch key {
+		case "options":
+			options, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("serialization options must be an object")
+			}
+			names := make([]string, 0, len(options))
+			for name := range options {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				serialization.Options = append(serialization.Options, basex.Parameter{Name: name, Value: serializationValue(options[name])})
+			}
+		case "jsonFormat":
+			serialization.Parameters = append(serialization.Parameters, basex.Parameter{Name: "json", Value: "format=" + serializationValue(value)})
+		default:
+			name, ok := serializationParameterNames[key]
+			if !ok {
+				return nil, fmt.Errorf("unsupported serialization parameter %q", key)
+			}
+			serialization.Parameters = append(serialization.Parameters, basex.Parameter{Name: name, Value: serializationValue(value)})
+		}
+	}
+
+	return serialization, nil
+}
+
+// serializationValue formats a JSON value as serialization parameter value
+func serializationValue(value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// acceptedSerializationMethods maps the media types honored in the Accept
+// header of REST queries to serialization methods
+var acceptedSerializationMethods = map[string]string{
+	"application/xml": "xml",
+	"text/xml":        "xml",
+	"text/html":       "html",
+	"text/plain":      "text",
+	"text/csv":        "csv",
+}
+
+// acceptedSerializationMethod returns the serialization method for the first
+// media type of an Accept header that maps to one
+func acceptedSerializationMethod(accept string) (string, bool) {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if method, ok := acceptedSerializationMethods[mediaType]; ok {
+			return method, true
+		}
+	}
+	return "", false
+}
+
+// valueTypes maps the type hints accepted for variables and parameters to
+// XQuery types
+var valueTypes = map[string]string{
+	"string":  "xs:string",
+	"number":  "xs:double",
+	"integer": "xs:integer",
+	"boolean": "xs:boolean",
+	"node":    "document-node()",
+}
+
+// typedValue unwraps a JSON value that is either a plain scalar or an object
+// of the form {"value": ..., "type": "..."}. It returns the type hint, inferred
+// from the JSON type when absent, and the value as text.
+func typedValue(value interface{}) (string, string, error) {
+	hint := ""
+	if typed, ok := value.(map[string]interface{}); ok {
+		hint, _ = typed["type"].(string)
+		value = typed["value"]
+	}
+	if value == nil {
+		return "", "", fmt.Errorf("value is required")
+	}
+
+	text := fmt.Sprint(value)
+	switch v := value.(type) {
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+		if hint == "" {
+			hint = "number"
+			if v == float64(int64(v)) {
+				hint = "integer"
+			}
+		}
+	case bool:
+		if hint == "" {
+			hint = "boolean"
+		}
+	}
+	if hint == "" {
+		hint = "string"
+	}
+
+	switch hint {
+	case "number":
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return "", "", fmt.Errorf("%q is not a number", text)
+		}
+	case "integer":
+		if _, err := strconv.ParseInt(text, 10, 64); err != nil {
+			return "", "", fmt.Errorf("%q is not an integer", text)
+		}
+	case "boolean":
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return "", "", fmt.Errorf("%q is not a boolean", text)
+		}
+		text = strconv.FormatBool(b)
+	}
+	return hint, text, nil
+}
+
+// getQueryVariables returns the external variable bindings of the action,
+// taken from its variables property or additionalProperty.variables
+func getQueryVariables(action *semantic.SemanticAction) ([]basex.Variable, error) {
+	return parseQueryVariables(getQueryVariableValues(action))
+}
+
+// getQueryVariableValues returns the raw variables map of the action
+func getQueryVariableValues(action *semantic.SemanticAction) map[string]interface{} {
+	raw, ok := actionProperty(action, "variables").(map[string]interface{})
+	if !ok {
+		raw, _ = actionAdditionalProperty(action, "variables").(map[string]interface{})
+	}
+	return raw
+}
+
+// parseQueryVariables converts a variables map into bindings sorted by name.
+// Type hints are either one of the short names of valueTypes or an XQuery
+// type such as xs:date, which is passed to BaseX unchanged.
+func parseQueryVariables(raw map[string]interface{}) ([]basex.Variable, error) {
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	variables := make([]basex.Variable, 0, len(names))
+	for _, name := range names {
+		hint, text, err := typedValue(raw[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for variable %q: %w", name, err)
+		}
+
+		variableType, ok := valueTypes[hint]
+		if !ok {
+			if !strings.ContainsAny(hint, ":(") {
+				return nil, fmt.Errorf("unsupported type %q for variable %q", hint, name)
+			}
+			variableType = hint
+		}
+
+		variables = append(variables, basex.Variable{
+			Name:  strings.TrimPrefix(name, "$"),
+			Value: text,
+			Type:  variableType,
+		})
+	}
+	return variables, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseQuerySerialization(t *testing.T) {
+	serialization, err := parseQuerySerialization(map[string]interface{}{
+		"method":             "json",
+		"jsonFormat":         "direct",
+		"indent":             true,
+		"omitXmlDeclaration": false,
+		"options":            map[string]interface{}{"stripws": true, "intparse": float64(1)},
+	})
+	if err != nil {
+		t.Fatalf("parseQuerySerialization: %v", err)
+	}
+	if got := fmt.Sprint(serialization.Parameters); got != "[{indent yes} {json format=direct} {method json} {omit-xml-declaration no}]" {
+		t.Errorf("parameters = %s", got)
+	}
+	if got := fmt.Sprint(serialization.Options); got != "[{intparse 1} {stripws yes}]" {
+		t.Errorf("options = %s", got)
+	}
+
+	for _, raw := range []map[string]interface{}{
+		{"methode": "xml"},
+		{"options": "stripws=true"},
+	} {
+		if _, err := parseQuerySerialization(raw); err == nil {
+			t.Errorf("%v: no error", raw)
+		}
+	}
+}
+
+func TestParseQueryVariables(t *testing.T) {
+	variables, err := parseQueryVariables(map[string]interface{}{
+		"name":   "x",
+		"limit":  float64(10),
+		"ratio":  0.5,
+		"strict": false,
+		"since":  map[string]interface{}{"value": "2024-01-01", "type": "xs:date"},
+		"doc":    map[string]interface{}{"value": "<a/>", "type": "node"},
+	})
+	if err != nil {
+		t.Fatalf("parseQueryVariables: %v", err)
+	}
+	if got := fmt.Sprint(variables); got != "[{doc <a/> document-node()} {limit 10 xs:integer} {name x xs:string} {ratio 0.5 xs:double} {since 2024-01-01 xs:date} {strict false xs:boolean}]" {
+		t.Errorf("variables = %s", got)
+	}
+	if variables, err := parseQueryVariables(map[string]interface{}{"$id": "c1"}); err != nil || variables[0].Name != "id" {
+		t.Errorf("$id = %+v, %v", variables, err)
+	}
+
+	for _, value := range []interface{}{
+		nil,
+		map[string]interface{}{"value": "ten", "type": "integer"},
+		map[string]interface{}{"value": "yes please", "type": "boolean"},
+		map[string]interface{}{"value": "x", "type": "date"},
+	} {
+		if _, err := parseQueryVariables(map[string]interface{}{"v": value}); err == nil {
+			t.Errorf("%v: no error", value)
+		}
+	}
+}
+
+func TestAcceptedSerializationMethod(t *testing.T) {
+	for accept, want := range map[string]string{
+		"text/plain":                          "text",
+		"text/csv; charset=utf-8":             "csv",
+		"application/json, text/html;q=0.9":   "html",
+		"image/png, text/xml;q=0.5, text/csv": "xml",
+		"application/json":                    "",
+		"*/*":                                 "",
+		"":                                    "",
+	} {
+		method, ok := acceptedSerializationMethod(accept)
+		if method != want || ok != (want != "") {
+			t.Errorf("%q: method = %q, %v, want %q", accept, method, ok, want)
+		}
+	}
+}
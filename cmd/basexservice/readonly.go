@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"basexservice.evalgo.org/basex"
+)
+
+// updatingQueryCheck asks BaseX whether $query is an updating expression.
+// The query is only parsed, never compiled or evaluated.
+const updatingQueryCheck = `declare variable $query external;
+xquery:parse($query, map { 'compile': false() })/@updating = 'true'`
+
+// errUpdatingQuery rejects updating queries where only reading is allowed
+var errUpdatingQuery = errors.New("updating queries are not allowed")
+
+// checkReadOnlyQuery returns errUpdatingQuery unless BaseX reports the query
+// text as not updating. Side effects outside of XQuery Update, such as the
+// job or file modules, are not detected, so the BaseX users of read-only
+// connections should only have the READ permission.
+func checkReadOnlyQuery(ctx context.Context, queries basex.Queries, text string) error {
+	result, err := queries.Query(ctx, "", &basex.Query{
+		Text:      updatingQueryCheck,
+		Variables: []basex.Variable{{Name: "query", Value: text, Type: "xs:string"}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check whether the query is updating: %w", err)
+	}
+	if strings.TrimSpace(string(result)) != "false" {
+		return errUpdatingQuery
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"eve.evalgo.org/semantic"
+	"github.com/labstack/echo/v4"
+)
+
+// defaultRedactedFields are the credential fields masked in responses when
+// BASEX_REDACT_FIELDS is unset
+var defaultRedactedFields = []string{"password", "secretKey", "accessKey", "token", "apiKey"}
+
+// redactedFields returns the normalized names of the fields masked in
+// responses, taken from BASEX_REDACT_FIELDS (comma-separated) if set
+func redactedFields() map[string]bool {
+	fields := defaultRedactedFields
+	if value := os.Getenv("BASEX_REDACT_FIELDS"); value != "" {
+		fields = strings.Split(value, ",")
+	}
+	names := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if name := normalizeFieldName(field); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// normalizeFieldName folds case, dashes and underscores, so secretKey also
+// matches secret_key and Secret-Key
+func normalizeFieldName(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "", " ", "").Replace(name))
+}
+
+// redactingJSONSerializer masks credential fields in every JSON response,
+// including the actions returned by the handlers and semantic.ReturnActionError.
+// Streamed query results are written directly and never touched.
+type redactingJSONSerializer struct {
+	echo.DefaultJSONSerializer
+}
+
+// Serialize writes i with its credential fields masked
+func (s redactingJSONSerializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	return s.DefaultJSONSerializer.Serialize(c, redactCredentials(i), indent)
+}
+
+// redactCredentials returns i with the values of credential fields, at any
+// depth, replaced by a mask. Secret references such as env:BASEX_PASSWORD
+// are kept, since they reveal nothing. Actions, JSON maps and slices and the
+// credentials of XMLDatabases are masked in a copy; other values carry no
+// credentials and are returned as they are, as is i if nothing was masked.
+func redactCredentials(i interface{}) interface{} {
+	fields := redactedFields()
+	if len(fields) == 0 {
+		return i
+	}
+	if action, ok := i.(*semantic.SemanticAction); ok && action != nil {
+		properties, redacted := redactValue(action.Properties, fields)
+		if !redacted {
+			return i
+		}
+		masked := *action
+		masked.Properties = properties.(map[string]interface{})
+		return &masked
+	}
+	if value, redacted := redactValue(i, fields); redacted {
+		return value
+	}
+	return i
+}
+
+// redactValue returns a copy of value with its credential fields masked and
+// reports whether any was found. value is returned as is otherwise.
+func redactValue(value interface{}, fields map[string]bool) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		var masked map[string]interface{}
+		for key, item := range v {
+			if fields[normalizeFieldName(key)] {
+				if item == nil || item == "" || isSecretReference(item) {
+					continue
+				}
+				item = redactedSecret
+			} else if redacted, ok := redactValue(item, fields); ok {
+				item = redacted
+			} else {
+				continue
+			}
+			if masked == nil {
+				masked = make(map[string]interface{}, len(v))
+				for key, item := range v {
+					masked[key] = item
+				}
+			}
+			masked[key] = item
+		}
+		if masked == nil {
+			return value, false
+		}
+		return masked, true
+	case map[string]string:
+		var masked map[string]string
+		for key, item := range v {
+			if !fields[normalizeFieldName(key)] || item == "" || isSecretReference(item) {
+				continue
+			}
+			if masked == nil {
+				masked = make(map[string]string, len(v))
+				for key, item := range v {
+					masked[key] = item
+				}
+			}
+			masked[key] = redactedSecret
+		}
+		if masked == nil {
+			return value, false
+		}
+		return masked, true
+	case []interface{}:
+		var masked []interface{}
+		for i, item := range v {
+			redacted, ok := redactValue(item, fields)
+			if !ok {
+				continue
+			}
+			if masked == nil {
+				masked = append([]interface{}(nil), v...)
+			}
+			masked[i] = redacted
+		}
+		if masked == nil {
+			return value, false
+		}
+		return masked, true
+	case *semantic.XMLDatabase:
+		if v == nil {
+			return value, false
+		}
+		if masked, ok := redactValue(*v, fields); ok {
+			database := masked.(semantic.XMLDatabase)
+			return &database, true
+		}
+		return value, false
+	case semantic.XMLDatabase:
+		redacted := false
+		if fields["password"] && v.Password != "" && !isSecretReference(v.Password) {
+			v.Password, redacted = redactedSecret, true
+		}
+		if properties, ok := redactValue(v.AdditionalProperty, fields); ok {
+			v.AdditionalProperty, redacted = properties.(map[string]interface{}), true
+		}
+		return v, redacted
+	}
+	return value, false
+}
+
+// isSecretReference reports whether value references a secret instead of
+// holding it. request: references of masked passwords are not shown.
+func isSecretReference(value interface{}) bool {
+	s, ok := value.(string)
+	if !ok || strings.HasPrefix(s, "request:") {
+		return false
+	}
+	provider, _ := secretProviderFor(s)
+	return provider != nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"eve.evalgo.org/semantic"
+)
+
+func TestRedactCredentials(t *testing.T) {
+	value := map[string]interface{}{
+		"target": map[string]interface{}{
+			"url": "http://basex:8080",
+			"additionalProperty": map[string]interface{}{
+				"username": "admin",
+				"password": "s3cret",
+			},
+		},
+		"steps": []interface{}{
+			map[string]interface{}{"Secret_Key": "aws-secret", "access-key": "AKIA", "count": 12345678901234567},
+		},
+		"token":  "",
+		"apiKey": "env:BASEX_SECRET_API_KEY",
+	}
+	data, _ := json.Marshal(redactCredentials(value))
+	got := string(data)
+	for _, secret := range []string{"s3cret", "aws-secret", "AKIA"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("%s not masked: %s", secret, got)
+		}
+	}
+	for _, kept := range []string{`"username":"admin"`, `"count":12345678901234567`, `"token":""`, `"apiKey":"env:BASEX_SECRET_API_KEY"`} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("%s not kept: %s", kept, got)
+		}
+	}
+
+	if value["target"].(map[string]interface{})["additionalProperty"].(map[string]interface{})["password"] != "s3cret" {
+		t.Error("the original value was masked")
+	}
+
+	// Values without credentials are passed through untouched
+	type page struct{ Total int }
+	if _, ok := redactCredentials(page{Total: 1}).(page); !ok {
+		t.Error("value without credentials was re-encoded")
+	}
+}
+
+func TestRedactActionCredentials(t *testing.T) {
+	database := &semantic.XMLDatabase{Identifier: "db", Username: "admin", Password: "s3cret"}
+	action := &semantic.SemanticAction{
+		Type:   "SearchAction",
+		Result: &semantic.SemanticResult{Output: `{"password": "data"}`},
+		Properties: map[string]interface{}{
+			"target":  map[string]interface{}{"password": "s3cret"},
+			"created": database,
+		},
+	}
+
+	redacted, ok := redactCredentials(action).(*semantic.SemanticAction)
+	if !ok || redacted == action {
+		t.Fatalf("redacted = %#v, want a masked copy", redacted)
+	}
+	if got := redacted.Properties["target"].(map[string]interface{})["password"]; got != redactedSecret {
+		t.Errorf("target password = %v", got)
+	}
+	if got := redacted.Properties["created"].(*semantic.XMLDatabase); got.Password != redactedSecret || got.Username != "admin" {
+		t.Errorf("database = %+v", got)
+	}
+	if redacted.Result.Output != `{"password": "data"}` {
+		t.Errorf("result output = %q, want the data as it is", redacted.Result.Output)
+	}
+
+	// The action handled is left as it is
+	if action.Properties["target"].(map[string]interface{})["password"] != "s3cret" || database.Password != "s3cret" {
+		t.Error("the original action was masked")
+	}
+}
+
+func TestRedactedFieldsFromEnv(t *testing.T) {
+	t.Setenv("BASEX_REDACT_FIELDS", "sessionId, password")
+	data, _ := json.Marshal(redactCredentials(map[string]interface{}{
+		"session_id": "abc",
+		"password":   "s3cret",
+		"token":      "kept",
+	}))
+	if got := string(data); strings.Contains(got, "abc") || strings.Contains(got, "s3cret") || !strings.Contains(got, "kept") {
+		t.Errorf("redacted = %s", got)
+	}
+}
+
+func TestResponsesAreRedacted(t *testing.T) {
+	e, server := newTestService(t)
+	server.Password = "s3cret-basex"
+	server.RespondToQuery("//item", `{"password": "data, not a credential"}`)
+
+	// Success and failure responses of semantic actions
+	for _, password := range []string{server.Password, "wrong-password"} {
+		target := catalog(server, "db")
+		target["additionalProperty"].(map[string]interface{})["password"] = password
+		rec := serve(t, e, http.MethodPost, "/v1/api/semantic/action", map[string]interface{}{
+			"@type":  "SearchAction",
+			"query":  "//item",
+			"target": target,
+			"object": map[string]interface{}{"@type": "Dataset", "s3": map[string]interface{}{"secretKey": "aws-secret"}},
+		})
+		body := rec.Body.String()
+		if strings.Contains(body, password) || strings.Contains(body, "aws-secret") || strings.Contains(body, "request:") {
+			t.Errorf("credentials in response: %s", body)
+		}
+		if !strings.Contains(body, `"password":"`+redactedSecret+`"`) {
+			t.Errorf("password not masked: %s", body)
+		}
+	}
+
+	// Query results are data and stay as they are
+	rec := serve(t, e, http.MethodPost, "/v1/api/queries", restCredentials(server, map[string]interface{}{
+		"query": "//item",
+	}), "Accept", "application/json")
+	if !strings.Contains(rec.Body.String(), "data, not a credential") || strings.Contains(rec.Body.String(), server.Password) {
+		t.Errorf("response = %s", rec.Body.String())
+	}
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
@@ -13,20 +15,34 @@ import (
 // REST endpoint request types
 
 type QueryRequest struct {
-	Query    string `json:"query"`
-	Database string `json:"database,omitempty"`
-	BaseURL  string `json:"baseUrl,omitempty"`
-	Username string `json:"username,omitempty"`
-	Password string `json:"password,omitempty"`
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Serialization map[string]interface{} `json:"serialization,omitempty"`
+	Offset        *int                   `json:"offset,omitempty"`
+	Limit         *int                   `json:"limit,omitempty"`
+	Cursor        string                 `json:"cursor,omitempty"`
+	Stream        string                 `json:"stream,omitempty"`
+	Timeout       interface{}            `json:"timeout,omitempty"`
+	Database      string                 `json:"database,omitempty"`
+	BaseURL       string                 `json:"baseUrl,omitempty"`
+	Username      string                 `json:"username,omitempty"`
+	Password      string                 `json:"password,omitempty"`
 }
 
 type TransformRequest struct {
-	XSLT     string `json:"xslt"`
-	XSLTPath string `json:"xsltPath,omitempty"`
-	Database string `json:"database,omitempty"`
-	BaseURL  string `json:"baseUrl,omitempty"`
-	Username string `json:"username,omitempty"`
-	Password string `json:"password,omitempty"`
+	// XSLT is refused: stylesheets are read from xsltPath along with the
+	// modules they import, which inline text has no location to resolve from
+	XSLT       string                 `json:"xslt,omitempty"`
+	XSLTPath   string                 `json:"xsltPath,omitempty"`
+	Document   string                 `json:"document,omitempty"`
+	Source     string                 `json:"source,omitempty"`
+	TargetPath string                 `json:"targetPath,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Timeout    interface{}            `json:"timeout,omitempty"`
+	Database   string                 `json:"database,omitempty"`
+	BaseURL    string                 `json:"baseUrl,omitempty"`
+	Username   string                 `json:"username,omitempty"`
+	Password   string                 `json:"password,omitempty"`
 }
 
 type DatabaseRequest struct {
@@ -36,6 +52,32 @@ type DatabaseRequest struct {
 	Password string `json:"password,omitempty"`
 }
 
+type StoredQueryRequest struct {
+	Name        string                 `json:"name"`
+	Version     string                 `json:"version"`
+	Description string                 `json:"description,omitempty"`
+	Query       string                 `json:"query"`
+	Parameters  []storedQueryParameter `json:"parameters,omitempty"`
+	BaseURL     string                 `json:"baseUrl,omitempty"`
+	Username    string                 `json:"username,omitempty"`
+	Password    string                 `json:"password,omitempty"`
+}
+
+type MoveRequest struct {
+	Path       string `json:"path"`
+	TargetPath string `json:"targetPath,omitempty"`
+	// TargetDatabase, TargetBaseURL and its credentials name the destination,
+	// which defaults to the source database
+	TargetDatabase string      `json:"targetDatabase,omitempty"`
+	TargetBaseURL  string      `json:"targetBaseUrl,omitempty"`
+	TargetUsername string      `json:"targetUsername,omitempty"`
+	TargetPassword string      `json:"targetPassword,omitempty"`
+	Timeout        interface{} `json:"timeout,omitempty"`
+	BaseURL        string      `json:"baseUrl,omitempty"`
+	Username       string      `json:"username,omitempty"`
+	Password       string      `json:"password,omitempty"`
+}
+
 // registerRESTEndpoints adds REST endpoints that convert to semantic actions
 func registerRESTEndpoints(apiGroup *echo.Group, apiKeyMiddleware echo.MiddlewareFunc) {
 	// POST /v1/api/queries - Execute XQuery
@@ -47,8 +89,33 @@ func registerRESTEndpoints(apiGroup *echo.Group, apiKeyMiddleware echo.Middlewar
 	// POST /v1/api/databases - Create database
 	apiGroup.POST("/databases", createDatabaseREST, apiKeyMiddleware)
 
+	// GET /v1/api/databases - List databases
+	apiGroup.GET("/databases", listDatabasesREST, apiKeyMiddleware)
+
+	// GET /v1/api/databases/:name - Describe a database
+	apiGroup.GET("/databases/:name", getDatabaseREST, apiKeyMiddleware)
+
+	// GET /v1/api/databases/:name/documents - List the documents of a database
+	apiGroup.GET("/databases/:name/documents", listDocumentsREST, apiKeyMiddleware)
+
 	// DELETE /v1/api/databases/:name - Delete database
 	apiGroup.DELETE("/databases/:name", deleteDatabaseREST, apiKeyMiddleware)
+
+	// GET /v1/api/databases/:name/documents/* - Read a document
+	apiGroup.GET("/databases/:name/documents/*", getDocumentREST, apiKeyMiddleware)
+
+	// POST /v1/api/databases/:name/move and /copy - Move or copy documents
+	apiGroup.POST("/databases/:name/move", moveDocumentsREST(false), apiKeyMiddleware)
+	apiGroup.POST("/databases/:name/copy", moveDocumentsREST(true), apiKeyMiddleware)
+
+	// Stored-query registry
+	apiGroup.GET("/stored-queries", listStoredQueriesREST, apiKeyMiddleware)
+	apiGroup.GET("/stored-queries/:name", getStoredQueryREST, apiKeyMiddleware)
+	apiGroup.POST("/stored-queries", registerStoredQueryREST, apiKeyMiddleware)
+	apiGroup.DELETE("/stored-queries/:name", deleteStoredQueryREST, apiKeyMiddleware)
+
+	// GET /v1/api/connections - List connection profiles
+	apiGroup.GET("/connections", listConnectionsREST, apiKeyMiddleware)
 }
 
 // executeQueryREST handles REST POST /v1/api/queries
@@ -87,8 +154,49 @@ func executeQueryREST(c echo.Context) error {
 		"query":    req.Query,
 		"object":   database,
 	}
+	if len(req.Variables) > 0 {
+		action["variables"] = req.Variables
+	}
+	if len(req.Serialization) > 0 {
+		action["serialization"] = req.Serialization
+	}
+	if req.Offset != nil {
+		action["offset"] = *req.Offset
+	}
+	if req.Limit != nil {
+		action["limit"] = *req.Limit
+	}
+	if req.Cursor != "" {
+		action["cursor"] = req.Cursor
+	}
+	if req.Timeout != nil {
+		action["timeout"] = req.Timeout
+	}
 
-	return callSemanticHandler(c, action)
+	values := map[string]interface{}{}
+
+	// An Accept header naming a non-JSON media type selects the serialization
+	// method and returns the raw result instead of the JSON-LD action
+	accept := c.Request().Header.Get("Accept")
+	if method, ok := acceptedSerializationMethod(accept); ok {
+		values[acceptedMethodKey] = method
+		values[rawResultKey] = true
+	}
+
+	// Streamed results are piped from BaseX to the client unbuffered
+	stream := req.Stream
+	if stream == "" && strings.Contains(accept, ndjsonMediaType) {
+		stream = streamModeNDJSON
+	}
+	switch stream {
+	case "":
+	case streamModeChunked, streamModeNDJSON:
+		values[streamModeKey] = stream
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unsupported stream mode %q", stream)})
+	}
+
+	return callSemanticHandlerWith(c, action, values)
 }
 
 // executeTransformREST handles REST POST /v1/api/transforms
@@ -99,19 +207,21 @@ func executeTransformREST(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid request: %v", err)})
 	}
 
-	if req.XSLT == "" && req.XSLTPath == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "xslt or xsltPath is required"})
+	if req.XSLT != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "inline xslt is not supported, pass the stylesheet file as xsltPath"})
+	}
+	if req.XSLTPath == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "xsltPath is required"})
+	}
+
+	if req.Document == "" && req.Source == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "document or source is required"})
 	}
 
 	// Build XSLT stylesheet object
 	stylesheet := map[string]interface{}{
-		"@type": "XSLTStylesheet",
-	}
-	if req.XSLT != "" {
-		stylesheet["text"] = req.XSLT
-	}
-	if req.XSLTPath != "" {
-		stylesheet["contentUrl"] = req.XSLTPath
+		"@type":      "XSLTStylesheet",
+		"contentUrl": req.XSLTPath,
 	}
 
 	// Build database object
@@ -131,12 +241,33 @@ func executeTransformREST(c echo.Context) error {
 		database["password"] = req.Password
 	}
 
+	// Build source document object
+	document := map[string]interface{}{
+		"@type": "DigitalDocument",
+	}
+	if req.Document != "" {
+		document["contentUrl"] = req.Document
+	}
+	if req.Source != "" {
+		document["text"] = req.Source
+	}
+
 	// Convert to JSON-LD TransformAction
 	action := map[string]interface{}{
 		"@context":   "https://schema.org",
 		"@type":      "TransformAction",
 		"instrument": stylesheet,
-		"object":     database,
+		"object":     document,
+		"target":     database,
+	}
+	if req.TargetPath != "" {
+		action["targetUrl"] = req.TargetPath
+	}
+	if len(req.Parameters) > 0 {
+		action["parameters"] = req.Parameters
+	}
+	if req.Timeout != nil {
+		action["timeout"] = req.Timeout
 	}
 
 	return callSemanticHandler(c, action)
@@ -187,12 +318,138 @@ func deleteDatabaseREST(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "database name is required"})
 	}
 
-	// Get optional parameters from query string
-	baseURL := c.QueryParam("baseUrl")
-	username := c.QueryParam("username")
-	password := c.QueryParam("password")
+	// Build database object from the optional baseUrl and basic authentication
+	database, err := requestDatabaseObject(c, name)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
 
-	// Build database object
+	// Convert to JSON-LD DeleteAction
+	action := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "DeleteAction",
+		"object":   database,
+	}
+
+	return callSemanticHandler(c, action)
+}
+
+// registerStoredQueryREST handles REST POST /v1/api/stored-queries
+// Converts to CreateAction on a SoftwareSourceCode and delegates to semantic handler
+func registerStoredQueryREST(c echo.Context) error {
+	var req StoredQueryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid request: %v", err)})
+	}
+
+	if req.Name == "" || req.Version == "" || req.Query == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "name, version and query are required"})
+	}
+
+	code := map[string]interface{}{
+		"@type":               "SoftwareSourceCode",
+		"identifier":          req.Name,
+		"version":             req.Version,
+		"programmingLanguage": "XQuery",
+		"text":                req.Query,
+	}
+	if req.Description != "" {
+		code["description"] = req.Description
+	}
+	if len(req.Parameters) > 0 {
+		code["parameters"] = req.Parameters
+	}
+
+	// Convert to JSON-LD CreateAction
+	action := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "CreateAction",
+		"object":   code,
+		"target":   storedQueryDatabaseObject(req.BaseURL, req.Username, req.Password),
+	}
+
+	return callSemanticHandler(c, action)
+}
+
+// listStoredQueriesREST handles REST GET /v1/api/stored-queries
+// Converts to SearchAction on a SoftwareSourceCode and delegates to semantic handler
+func listStoredQueriesREST(c echo.Context) error {
+	database, err := requestDatabaseObject(c, storedQueryDatabase())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	action := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "SearchAction",
+		"object": map[string]interface{}{
+			"@type": "SoftwareSourceCode",
+		},
+		"target": database,
+	}
+
+	return callSemanticHandler(c, action)
+}
+
+// getStoredQueryREST handles REST GET /v1/api/stored-queries/:name
+// Converts to SearchAction on a SoftwareSourceCode and delegates to semantic handler
+func getStoredQueryREST(c echo.Context) error {
+	code := map[string]interface{}{
+		"@type":      "SoftwareSourceCode",
+		"identifier": c.Param("name"),
+	}
+	if version := c.QueryParam("version"); version != "" {
+		code["version"] = version
+	}
+	database, err := requestDatabaseObject(c, storedQueryDatabase())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	action := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "SearchAction",
+		"object":   code,
+		"target":   database,
+	}
+
+	return callSemanticHandler(c, action)
+}
+
+// deleteStoredQueryREST handles REST DELETE /v1/api/stored-queries/:name
+// Converts to DeleteAction on a SoftwareSourceCode and delegates to semantic handler
+func deleteStoredQueryREST(c echo.Context) error {
+	code := map[string]interface{}{
+		"@type":      "SoftwareSourceCode",
+		"identifier": c.Param("name"),
+	}
+	if version := c.QueryParam("version"); version != "" {
+		code["version"] = version
+	}
+	database, err := requestDatabaseObject(c, storedQueryDatabase())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	action := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "DeleteAction",
+		"object":   code,
+		"target":   database,
+	}
+
+	return callSemanticHandler(c, action)
+}
+
+// storedQueryDatabaseObject builds the database object addressing the
+// BaseX instance that holds the stored-query registry
+func storedQueryDatabaseObject(baseURL, username, password string) map[string]interface{} {
+	return databaseObject(storedQueryDatabase(), baseURL, username, password)
+}
+
+// databaseObject builds the Database object of a REST request from its
+// optional baseUrl, username and password
+func databaseObject(name, baseURL, username, password string) map[string]interface{} {
 	database := map[string]interface{}{
 		"@type":      "Database",
 		"identifier": name,
@@ -206,19 +463,41 @@ func deleteDatabaseREST(c echo.Context) error {
 	if password != "" {
 		database["password"] = password
 	}
+	return database
+}
 
-	// Convert to JSON-LD DeleteAction
-	action := map[string]interface{}{
-		"@context": "https://schema.org",
-		"@type":    "DeleteAction",
-		"object":   database,
-	}
+// errCredentialsInURL refuses credentials given as query parameters
+var errCredentialsInURL = errors.New("username and password are not accepted as query parameters; use basic authentication or a connection profile")
 
-	return callSemanticHandler(c, action)
+// requestDatabaseObject builds the Database object of a GET or DELETE request from its
+// optional baseUrl query parameter. Credentials only come from basic
+// authentication, since URLs end up in access logs and browser histories.
+func requestDatabaseObject(c echo.Context, name string) (map[string]interface{}, error) {
+	if c.QueryParams().Has("username") || c.QueryParams().Has("password") {
+		return nil, errCredentialsInURL
+	}
+	username, password, _ := c.Request().BasicAuth()
+	return databaseObject(name, c.QueryParam("baseUrl"), username, password), nil
 }
 
+// Context keys passed from REST adapters to semantic handlers
+const (
+	// rawResultKey asks the handler to return the raw result instead of the action
+	rawResultKey = "basexservice.rawResult"
+	// acceptedMethodKey carries the serialization method negotiated via Accept
+	acceptedMethodKey = "basexservice.acceptedMethod"
+	// streamModeKey asks the handler to stream the result to the client
+	streamModeKey = "basexservice.streamMode"
+)
+
 // callSemanticHandler converts action to JSON and calls the semantic action handler
 func callSemanticHandler(c echo.Context, action map[string]interface{}) error {
+	return callSemanticHandlerWith(c, action, nil)
+}
+
+// callSemanticHandlerWith calls the semantic action handler with values set
+// on the handler's context
+func callSemanticHandlerWith(c echo.Context, action map[string]interface{}, values map[string]interface{}) error {
 	// Marshal action to JSON
 	actionJSON, err := json.Marshal(action)
 	if err != nil {
@@ -235,6 +514,9 @@ func callSemanticHandler(c echo.Context, action map[string]interface{}) error {
 	newCtx.SetPath(c.Path())
 	newCtx.SetParamNames(c.ParamNames()...)
 	newCtx.SetParamValues(c.ParamValues()...)
+	for key, value := range values {
+		newCtx.Set(key, value)
+	}
 
 	// Call the existing semantic action handler
 	return handleSemanticAction(newCtx)
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"basexservice.evalgo.org/basex/basextest"
+)
+
+// restCredentials returns the baseUrl and credential fields addressing the fake server
+func restCredentials(server *basextest.Server, fields map[string]interface{}) map[string]interface{} {
+	fields["baseUrl"] = server.URL
+	fields["username"] = server.Username
+	fields["password"] = server.Password
+	return fields
+}
+
+func TestQueryREST(t *testing.T) {
+	e, server := newTestService(t)
+	server.HandleQuery("$id", func(q *basextest.Query) basextest.Response {
+		return basextest.Response{Body: "<item id=\"" + q.Variable("id") + "\"/>"}
+	})
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/queries", restCredentials(server, map[string]interface{}{
+		"query":     "declare variable $id external; //item[@id = $id]",
+		"variables": map[string]interface{}{"id": "42"},
+		"database":  "db",
+	}))
+	action := decodeAction(t, rec)
+	requireStatus(t, action, "CompletedActionStatus")
+	if got := resultOutput(action); got != `<item id="42"/>` {
+		t.Errorf("output = %q", got)
+	}
+	if q := server.Queries()[0]; q.Database != "db" {
+		t.Errorf("query ran against %q, want db", q.Database)
+	}
+}
+
+func TestQueryRESTRawResult(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("subsequence($items", "3\na\nb")
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/queries", restCredentials(server, map[string]interface{}{
+		"query": "('a', 'b', 'c')",
+		"limit": 2,
+	}), "Accept", "text/plain")
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "a\nb" {
+		t.Fatalf("response = %d %q", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Content-Type = %s", rec.Header().Get("Content-Type"))
+	}
+	if rec.Header().Get("X-Total-Count") != "3" || rec.Header().Get("X-Next-Cursor") == "" {
+		t.Errorf("page headers = %v", rec.Header())
+	}
+	if q := server.Queries()[0]; !strings.Contains(q.Text, "map { \"method\": \"text\" }") {
+		t.Errorf("negotiated serialization was not applied to the page:\n%s", q.Text)
+	}
+}
+
+func TestQueryRESTStreamsNDJSON(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("'method': 'json'", "{\"n\":1}\n{\"n\":2}")
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/queries", restCredentials(server, map[string]interface{}{
+		"query": "//item",
+	}), "Accept", ndjsonMediaType)
+
+	if rec.Body.String() != "{\"n\":1}\n{\"n\":2}\n" {
+		t.Fatalf("response = %d %q", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), ndjsonMediaType) {
+		t.Errorf("Content-Type = %s", rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestQueryRESTValidation(t *testing.T) {
+	e, server := newTestService(t)
+
+	for _, body := range []map[string]interface{}{
+		{},
+		{"query": "1", "stream": "gzip"},
+	} {
+		if rec := serve(t, e, http.MethodPost, "/v1/api/queries", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if len(server.Requests()) != 0 {
+		t.Errorf("BaseX was called for invalid requests: %+v", server.Requests())
+	}
+}
+
+func TestTransformREST(t *testing.T) {
+	e, server := newTestService(t)
+	server.CreateDatabase("db")
+	xsltPath := writeFile(t, t.TempDir(), "identity.xsl",
+		`<xsl:stylesheet version="3.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform"><xsl:mode on-no-match="shallow-copy"/></xsl:stylesheet>`)
+	server.HandleQuery("xslt:transform", func(q *basextest.Query) basextest.Response {
+		return basextest.Response{Body: q.Variable("source")}
+	})
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/transforms", restCredentials(server, map[string]interface{}{
+		"xsltPath": xsltPath,
+		"source":   "<doc/>",
+		"database": "db",
+	}))
+	action := decodeAction(t, rec)
+	requireStatus(t, action, "CompletedActionStatus")
+	if got := resultOutput(action); got != "<doc/>" {
+		t.Errorf("output = %q", got)
+	}
+
+	if rec := serve(t, e, http.MethodPost, "/v1/api/transforms", map[string]interface{}{"source": "<doc/>"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("transform without stylesheet: status = %d, want 400", rec.Code)
+	}
+
+	// Inline stylesheets are refused before anything reaches BaseX
+	requests := len(server.Requests())
+	rec = serve(t, e, http.MethodPost, "/v1/api/transforms", restCredentials(server, map[string]interface{}{
+		"xslt":     `<xsl:stylesheet version="3.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform"/>`,
+		"source":   "<doc/>",
+		"database": "db",
+	}))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "xsltPath") {
+		t.Errorf("inline xslt: status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	if len(server.Requests()) != requests {
+		t.Errorf("inline xslt reached BaseX: %+v", server.Requests()[requests:])
+	}
+}
+
+func TestDatabasesREST(t *testing.T) {
+	e, server := newTestService(t)
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/databases", restCredentials(server, map[string]interface{}{"name": "db"}))
+	requireStatus(t, decodeAction(t, rec), "CompletedActionStatus")
+	if !server.HasDatabase("db") {
+		t.Fatal("database was not created")
+	}
+
+	// Credentials in the URL are refused
+	path := "/v1/api/databases/db?baseUrl=" + server.URL
+	if rec := serve(t, e, http.MethodDelete, path+"&username="+server.Username+"&password="+server.Password, nil); rec.Code != http.StatusBadRequest || !server.HasDatabase("db") {
+		t.Fatalf("delete with credentials in the URL: status = %d", rec.Code)
+	}
+
+	auth := basicAuth(server.Username, server.Password)
+	server.FailNext("DELETE", "/rest/db", http.StatusInternalServerError, "disk full")
+	action := decodeAction(t, serve(t, e, http.MethodDelete, path, nil, auth...))
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "disk full") || !server.HasDatabase("db") {
+		t.Fatalf("delete with scripted failure: %q", errorMessage(action))
+	}
+
+	requireStatus(t, decodeAction(t, serve(t, e, http.MethodDelete, path, nil, auth...)), "CompletedActionStatus")
+	if server.HasDatabase("db") {
+		t.Fatal("database was not deleted")
+	}
+}
+
+func TestStoredQueriesREST(t *testing.T) {
+	e, server := newTestService(t)
+	scriptStoredQueryRegistry(server)
+	baseURL := "?baseUrl=" + server.URL
+	auth := basicAuth(server.Username, server.Password)
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/stored-queries", restCredentials(server, map[string]interface{}{
+		"name":    "count",
+		"version": "1",
+		"query":   "count(//item)",
+	}))
+	requireStatus(t, decodeAction(t, rec), "CompletedActionStatus")
+
+	action := decodeAction(t, serve(t, e, http.MethodGet, "/v1/api/stored-queries"+baseURL, nil, auth...))
+	requireStatus(t, action, "CompletedActionStatus")
+	if !strings.Contains(resultOutput(action), `"identifier":"count"`) {
+		t.Errorf("listing = %s", resultOutput(action))
+	}
+
+	action = decodeAction(t, serve(t, e, http.MethodGet, "/v1/api/stored-queries/missing"+baseURL, nil, auth...))
+	requireStatus(t, action, "FailedActionStatus")
+
+	// Credentials are not taken from the URL
+	rec = serve(t, e, http.MethodDelete, "/v1/api/stored-queries/count"+baseURL+"&username="+server.Username+"&password="+server.Password, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("credentials in the URL = %d %s", rec.Code, rec.Body.String())
+	}
+
+	action = decodeAction(t, serve(t, e, http.MethodDelete, "/v1/api/stored-queries/count"+baseURL+"&version=1", nil, auth...))
+	requireStatus(t, action, "CompletedActionStatus")
+	if got := server.Resources(storedQueryDatabase()); len(got) != 0 {
+		t.Errorf("resources after delete: %v", got)
+	}
+}
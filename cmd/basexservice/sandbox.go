@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fileRoots returns the directories local files may be read from and
+// written to, from BASEX_FILE_ROOTS (comma-separated). Local files are
+// refused if it is empty.
+func fileRoots() ([]string, error) {
+	var roots []string
+	for _, root := range strings.Split(os.Getenv("BASEX_FILE_ROOTS"), ",") {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		if !filepath.IsAbs(root) {
+			return nil, fmt.Errorf("BASEX_FILE_ROOTS entry %q is not an absolute path", root)
+		}
+		resolved, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return nil, fmt.Errorf("BASEX_FILE_ROOTS entry %q: %w", root, err)
+		}
+		roots = append(roots, resolved)
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("local files are disabled; set BASEX_FILE_ROOTS to allow them")
+	}
+	return roots, nil
+}
+
+// fileSchemeRequired reports whether BASEX_REQUIRE_FILE_SCHEME demands
+// file:// URLs for local files
+func fileSchemeRequired() bool {
+	required, _ := strconv.ParseBool(os.Getenv("BASEX_REQUIRE_FILE_SCHEME"))
+	return required
+}
+
+// isLocalFileURL reports whether a contentUrl or targetUrl names a local
+// file rather than a database resource
+func isLocalFileURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "file://") || filepath.IsAbs(rawURL)
+}
+
+// localFilePath returns the resolved path of an existing local file named by
+// a contentUrl: a file:// URL or a path. The file must lie within
+// BASEX_FILE_ROOTS after symlinks are resolved, and is read with
+// readLocalFile or openLocalFile.
+func localFilePath(contentURL string) (string, error) {
+	return sandboxPath(contentURL, true)
+}
+
+// localTargetPath is localFilePath for a file that is about to be written
+// with createLocalFile and may not exist yet
+func localTargetPath(targetURL string) (string, error) {
+	return sandboxPath(targetURL, false)
+}
+
+func sandboxPath(rawURL string, mustExist bool) (string, error) {
+	path, hasScheme := strings.CutPrefix(rawURL, "file://")
+	if !hasScheme && fileSchemeRequired() {
+		return "", fmt.Errorf("local file %q must be given as a file:// URL", rawURL)
+	}
+	return checkFileRoots(path, mustExist)
+}
+
+// checkFileRoots returns path, made absolute and with its symlinks resolved,
+// if it lies within BASEX_FILE_ROOTS
+func checkFileRoots(path string, mustExist bool) (string, error) {
+	roots, err := fileRoots()
+	if err != nil {
+		return "", err
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := resolveExisting(abs, mustExist)
+	if err != nil {
+		return "", fmt.Errorf("local file %s: %w", path, err)
+	}
+	for _, root := range roots {
+		if isWithinDir(root, resolved) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("local file %s is outside of the directories allowed by BASEX_FILE_ROOTS", path)
+}
+
+// inFileRoot calls fn with the directory of BASEX_FILE_ROOTS holding a path
+// returned by checkFileRoots and the name of the path relative to it. Files
+// are accessed through the os.Root, so a symlink swapped in after the path
+// was checked cannot lead out of the directory.
+func inFileRoot(path string, fn func(root *os.Root, name string) error) error {
+	roots, err := fileRoots()
+	if err != nil {
+		return err
+	}
+	for _, dir := range roots {
+		if !isWithinDir(dir, path) {
+			continue
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		root, err := os.OpenRoot(dir)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = root.Close() }()
+		return fn(root, name)
+	}
+	return fmt.Errorf("local file %s is outside of the directories allowed by BASEX_FILE_ROOTS", path)
+}
+
+// readLocalFile reads a local file checked by localFilePath
+func readLocalFile(path string) ([]byte, error) {
+	var data []byte
+	err := inFileRoot(path, func(root *os.Root, name string) error {
+		var err error
+		data, err = root.ReadFile(name)
+		return err
+	})
+	return data, err
+}
+
+// openLocalFile opens a local file checked by localFilePath for reading
+func openLocalFile(path string) (*os.File, error) {
+	var file *os.File
+	err := inFileRoot(path, func(root *os.Root, name string) error {
+		var err error
+		file, err = root.Open(name)
+		return err
+	})
+	return file, err
+}
+
+// createLocalFile creates or truncates a local file checked by
+// localTargetPath, creating its missing parent directories
+func createLocalFile(path string) (*os.File, error) {
+	var file *os.File
+	err := inFileRoot(path, func(root *os.Root, name string) error {
+		if err := root.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		var err error
+		file, err = root.Create(name)
+		return err
+	})
+	return file, err
+}
+
+// removeLocalFile removes a local file checked by localTargetPath
+func removeLocalFile(path string) error {
+	return inFileRoot(path, func(root *os.Root, name string) error {
+		return root.Remove(name)
+	})
+}
+
+// resolveExisting resolves the symlinks of path. Unless mustExist is set,
+// missing trailing elements are kept as they are, so files that are about
+// to be created resolve through their closest existing directory.
+func resolveExisting(path string, mustExist bool) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil || mustExist || !errors.Is(err, os.ErrNotExist) {
+		return resolved, err
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return "", err
+	}
+	resolvedParent, err := resolveExisting(parent, false)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolvedParent, filepath.Base(path)), nil
+}
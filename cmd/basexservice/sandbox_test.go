@@ -0,0 +1,185 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalFilesDisabledByDefault(t *testing.T) {
+	e, server := newTestService(t)
+	server.PutResource("db", "doc.xml", "application/xml", []byte("<doc/>"))
+	dir := t.TempDir()
+	source := writeFile(t, dir, "source.xml", "<source/>")
+	target := filepath.Join(dir, "out", "doc.xml")
+	t.Setenv("BASEX_FILE_ROOTS", "")
+
+	for _, action := range []map[string]interface{}{
+		{
+			"@type":     "CreateAction",
+			"object":    map[string]interface{}{"@type": "DigitalDocument", "identifier": "source.xml", "contentUrl": source},
+			"target":    catalog(server, "db"),
+			"targetUrl": "source.xml",
+		},
+		{
+			"@type":     "DownloadAction",
+			"object":    map[string]interface{}{"@type": "DigitalDocument", "identifier": "doc.xml"},
+			"target":    catalog(server, "db"),
+			"targetUrl": "file://" + target,
+		},
+	} {
+		result := serveAction(t, e, action)
+		requireStatus(t, result, "FailedActionStatus")
+		if message := errorMessage(result); !strings.Contains(message, "local files are disabled") {
+			t.Errorf("%s: error = %q", action["@type"], message)
+		}
+	}
+	if _, ok := server.Resource("db", "source.xml"); ok {
+		t.Error("local file was uploaded")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("local file was written: %v", err)
+	}
+}
+
+func TestUploadActionFileRoots(t *testing.T) {
+	e, server := newTestService(t)
+	server.CreateDatabase("db")
+	root := t.TempDir()
+	allowed := writeFile(t, root, "doc.xml", "<doc/>")
+	outside := writeFile(t, t.TempDir(), "secret.xml", "<secret/>")
+	t.Setenv("BASEX_FILE_ROOTS", root)
+
+	upload := func(contentURL string) map[string]interface{} {
+		return serveAction(t, e, map[string]interface{}{
+			"@type":     "CreateAction",
+			"object":    map[string]interface{}{"@type": "DigitalDocument", "identifier": "doc.xml", "contentUrl": contentURL},
+			"target":    catalog(server, "db"),
+			"targetUrl": "doc.xml",
+		})
+	}
+
+	requireStatus(t, upload(allowed), "CompletedActionStatus")
+	requireStatus(t, upload("file://"+allowed), "CompletedActionStatus")
+
+	link := filepath.Join(root, "link.xml")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+	for _, contentURL := range []string{
+		outside,
+		"file://" + outside,
+		root + "/../" + filepath.Base(filepath.Dir(outside)) + "/secret.xml",
+		link,
+	} {
+		action := upload(contentURL)
+		requireStatus(t, action, "FailedActionStatus")
+		if message := errorMessage(action); !strings.Contains(message, "outside of the directories allowed by BASEX_FILE_ROOTS") {
+			t.Errorf("%s: error = %q", contentURL, message)
+		}
+	}
+	if stored, _ := server.Resource("db", "doc.xml"); string(stored.Content) != "<doc/>" {
+		t.Errorf("stored = %q", stored.Content)
+	}
+
+	// Bare paths are rejected once file:// URLs are required
+	t.Setenv("BASEX_REQUIRE_FILE_SCHEME", "true")
+	action := upload(allowed)
+	requireStatus(t, action, "FailedActionStatus")
+	if message := errorMessage(action); !strings.Contains(message, "must be given as a file:// URL") {
+		t.Errorf("error = %q", message)
+	}
+	requireStatus(t, upload("file://"+allowed), "CompletedActionStatus")
+}
+
+func TestTransformActionFileRoots(t *testing.T) {
+	e, server := newTestService(t)
+	server.CreateDatabase("db")
+	root := t.TempDir()
+	xsltPath := writeFile(t, root, "main.xsl", strings.Replace(testStylesheet, "common/base.xsl", "../escape/base.xsl", 1))
+	writeFile(t, filepath.Dir(root), "escape/base.xsl", testBaseStylesheet)
+	t.Setenv("BASEX_FILE_ROOTS", root)
+
+	transform := func(contentURL string) map[string]interface{} {
+		return serveAction(t, e, map[string]interface{}{
+			"@type":      "TransformAction",
+			"instrument": map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": contentURL},
+			"object":     map[string]interface{}{"@type": "DigitalDocument", "identifier": "input.xml"},
+			"target":     catalog(server, "db"),
+			"parameters": map[string]interface{}{"prefix": "x-"},
+		})
+	}
+
+	// Imports are held to the same roots as the stylesheet
+	action := transform(xsltPath)
+	requireStatus(t, action, "FailedActionStatus")
+	if message := errorMessage(action); !strings.Contains(message, "imports ../escape/base.xsl") {
+		t.Errorf("error = %q", message)
+	}
+
+	action = transform(filepath.Join(filepath.Dir(root), "escape/base.xsl"))
+	requireStatus(t, action, "FailedActionStatus")
+	if message := errorMessage(action); !strings.Contains(message, "Stylesheet path not allowed") {
+		t.Errorf("error = %q", message)
+	}
+	if len(server.Requests()) != 0 {
+		t.Errorf("BaseX was called: %+v", server.Requests())
+	}
+}
+
+func TestLocalTargetPath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BASEX_FILE_ROOTS", root)
+
+	if _, err := localTargetPath("file://" + filepath.Join(root, "exports", "new", "result.xml")); err != nil {
+		t.Errorf("new file below the root: %v", err)
+	}
+	if path, err := localTargetPath(filepath.Join(root, "link2", "..", "result.xml")); err != nil || path != filepath.Join(root, "result.xml") {
+		t.Errorf("cleaned path = %q, %v", path, err)
+	}
+	for _, target := range []string{
+		filepath.Join(outside, "result.xml"),
+		filepath.Join(root, "link", "exports", "result.xml"),
+	} {
+		if _, err := localTargetPath(target); err == nil {
+			t.Errorf("%s was allowed", target)
+		}
+	}
+
+	t.Setenv("BASEX_FILE_ROOTS", "relative/dir")
+	if _, err := localTargetPath(filepath.Join(root, "result.xml")); err == nil || !strings.Contains(err.Error(), "not an absolute path") {
+		t.Errorf("relative root: %v", err)
+	}
+}
+
+func TestLocalFileSwappedForSymlink(t *testing.T) {
+	root := t.TempDir()
+	secret := writeFile(t, t.TempDir(), "secret.xml", "<secret/>")
+	t.Setenv("BASEX_FILE_ROOTS", root)
+
+	path, err := localFilePath(writeFile(t, root, "doc.xml", "<doc/>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A link replacing the file after it was checked is not followed out
+	// of the root
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, path); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := readLocalFile(path); err == nil {
+		t.Errorf("read %q through a swapped link", data)
+	}
+	if file, err := createLocalFile(path); err == nil {
+		_ = file.Close()
+		t.Error("created a file through a swapped link")
+	}
+}
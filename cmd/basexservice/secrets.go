@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"eve.evalgo.org/semantic"
+)
+
+// defaultSecretsDir holds the files readable through file: references when
+// BASEX_SECRETS_DIR is unset
+const defaultSecretsDir = "/run/secrets"
+
+// secretEnvPrefix marks the environment variables readable through env:
+// references, besides BASEX_USER and BASEX_PASSWORD
+const secretEnvPrefix = "BASEX_SECRET_"
+
+// secretProvider resolves the secret references of one scheme. name is the
+// part after the scheme, e.g. BASEX_PASSWORD in env:BASEX_PASSWORD.
+type secretProvider interface {
+	resolveSecret(name string) (string, error)
+}
+
+// secretProviderFunc adapts a function to a secretProvider
+type secretProviderFunc func(name string) (string, error)
+
+func (f secretProviderFunc) resolveSecret(name string) (string, error) {
+	return f(name)
+}
+
+var (
+	secretProvidersMu sync.RWMutex
+	secretProviders   = map[string]secretProvider{}
+)
+
+// registerSecretProvider makes references of the form scheme:name resolve
+// through provider. It panics if the scheme is taken.
+func registerSecretProvider(scheme string, provider secretProvider) {
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+	if _, ok := secretProviders[scheme]; ok {
+		panic(fmt.Sprintf("secret provider %q registered twice", scheme))
+	}
+	secretProviders[scheme] = provider
+}
+
+// secretProviderFor returns the provider of a reference, or nil if value is
+// a plain value
+func secretProviderFor(value string) (secretProvider, string) {
+	scheme, name, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, ""
+	}
+	secretProvidersMu.RLock()
+	defer secretProvidersMu.RUnlock()
+	return secretProviders[scheme], name
+}
+
+// resolveSecret returns the secret a reference points to. Plain values are
+// returned as they are.
+func resolveSecret(value string) (string, error) {
+	provider, name := secretProviderFor(value)
+	if provider == nil {
+		return value, nil
+	}
+	secret, err := provider.resolveSecret(name)
+	if err != nil {
+		scheme, _, _ := strings.Cut(value, ":")
+		return "", fmt.Errorf("failed to resolve secret reference %s:%s: %w", scheme, name, err)
+	}
+	return secret, nil
+}
+
+func init() {
+	registerSecretProvider("env", secretProviderFunc(envSecret))
+	registerSecretProvider("file", secretProviderFunc(fileSecret))
+	registerSecretProvider("request", requestSecrets)
+}
+
+// isServiceSecretReference reports whether value references a secret of the
+// service, rather than being a plain value or a password of the request
+// itself
+func isServiceSecretReference(value string) bool {
+	provider, _ := secretProviderFor(value)
+	return provider != nil && provider != secretProvider(requestSecrets)
+}
+
+// envSecret reads an environment variable. Only BASEX_USER, BASEX_PASSWORD
+// and variables starting with BASEX_SECRET_ can be read, so callers cannot
+// reach other secrets of the service, such as cloud credentials.
+// resolveConnection only resolves references for trusted servers.
+func envSecret(name string) (string, error) {
+	if name != "BASEX_USER" && name != "BASEX_PASSWORD" && !strings.HasPrefix(name, secretEnvPrefix) {
+		return "", fmt.Errorf("only BASEX_USER, BASEX_PASSWORD and %s* variables can be referenced", secretEnvPrefix)
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.New("variable is not set")
+	}
+	return value, nil
+}
+
+// fileSecret reads a file below BASEX_SECRETS_DIR, /run/secrets by default.
+// A trailing line break is removed.
+func fileSecret(name string) (string, error) {
+	dir := os.Getenv("BASEX_SECRETS_DIR")
+	if dir == "" {
+		dir = defaultSecretsDir
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("secrets directory: %w", err)
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(root, name)
+	}
+	path, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file is outside of %s", dir)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// secretStore keeps the plaintext passwords of the actions in flight. They
+// are referenced as request:<token> while the action is handled.
+type secretStore struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+// requestSecrets holds the passwords taken out of the actions in flight
+var requestSecrets = &secretStore{secrets: map[string]string{}}
+
+func (s *secretStore) resolveSecret(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[token]
+	if !ok {
+		return "", errors.New("unknown or expired reference")
+	}
+	return secret, nil
+}
+
+// put stores a secret and returns its token
+func (s *secretStore) put(secret string) string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	token := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[token] = secret
+	return token
+}
+
+// drop forgets the secrets of an action that was handled
+func (s *secretStore) drop(tokens []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range tokens {
+		delete(s.secrets, token)
+	}
+}
+
+// maskActionPasswords replaces the plaintext passwords in action by
+// request: references, so neither the returned action nor the operation
+// history carries them. The passwords resolve until the returned tokens are
+// dropped.
+func maskActionPasswords(action *semantic.SemanticAction) []string {
+	var tokens []string
+	var mask func(value interface{})
+	mask = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, item := range v {
+				if password, ok := item.(string); ok && strings.EqualFold(key, "password") {
+					if provider, _ := secretProviderFor(password); provider == nil && password != "" {
+						token := requestSecrets.put(password)
+						tokens = append(tokens, token)
+						v[key] = "request:" + token
+					}
+					continue
+				}
+				mask(item)
+			}
+		case []interface{}:
+			for _, item := range v {
+				mask(item)
+			}
+		}
+	}
+	mask(action.Properties)
+	return tokens
+}
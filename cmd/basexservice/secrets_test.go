@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// catalogWithCredentials returns the DataCatalog of db on server with the
+// given username and password values
+func catalogWithCredentials(url, username, password string) map[string]interface{} {
+	return map[string]interface{}{
+		"@type":      "DataCatalog",
+		"identifier": "db",
+		"url":        url,
+		"additionalProperty": map[string]interface{}{
+			"username": username,
+			"password": password,
+		},
+	}
+}
+
+func TestSecretReferences(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("//item", "<item/>")
+	server.Password = "s3cret-basex"
+	dir := t.TempDir()
+	writeFile(t, dir, "basex-password", server.Password+"\n")
+	t.Setenv("BASEX_SECRETS_DIR", dir)
+	t.Setenv("BASEX_SECRET_IQS_USER", server.Username)
+	t.Setenv("BASEX_PASSWORD", server.Password)
+	t.Setenv("BASEX_ALLOWED_URLS", server.URL)
+
+	for _, credentials := range [][2]string{
+		{"env:BASEX_SECRET_IQS_USER", "env:BASEX_PASSWORD"},
+		{"env:BASEX_SECRET_IQS_USER", "file:basex-password"},
+		{"env:BASEX_SECRET_IQS_USER", "file:" + filepath.Join(dir, "basex-password")},
+	} {
+		action := serveAction(t, e, map[string]interface{}{
+			"@type":  "SearchAction",
+			"query":  "//item",
+			"target": catalogWithCredentials(server.URL, credentials[0], credentials[1]),
+		})
+		requireStatus(t, action, "CompletedActionStatus")
+	}
+
+	// References are echoed as they are, never resolved into the action
+	rec := serve(t, e, http.MethodPost, "/v1/api/semantic/action", map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "//item",
+		"target": catalogWithCredentials(server.URL, "admin", "env:BASEX_PASSWORD"),
+	})
+	if strings.Contains(rec.Body.String(), server.Password) {
+		t.Errorf("resolved secret in the response: %s", rec.Body.String())
+	}
+}
+
+func TestSecretReferencesRejected(t *testing.T) {
+	e, server := newTestService(t)
+	dir := t.TempDir()
+	writeFile(t, filepath.Dir(dir), "outside", "secret")
+	t.Setenv("BASEX_SECRETS_DIR", dir)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "aws-secret")
+	t.Setenv("BASEX_ALLOWED_URLS", server.URL)
+
+	for _, password := range []string{
+		"env:AWS_SECRET_ACCESS_KEY",
+		"env:BASEX_SECRET_UNSET",
+		"file:../outside",
+		"file:" + filepath.Join(filepath.Dir(dir), "outside"),
+		"file:missing",
+		"request:0123456789abcdef",
+	} {
+		action := serveAction(t, e, map[string]interface{}{
+			"@type":  "SearchAction",
+			"query":  "//item",
+			"target": catalogWithCredentials(server.URL, "admin", password),
+		})
+		requireStatus(t, action, "FailedActionStatus")
+		if message := errorMessage(action); !strings.Contains(message, "failed to resolve secret reference") {
+			t.Errorf("%s: error = %q", password, message)
+		}
+	}
+	if len(server.Requests()) != 0 {
+		t.Errorf("BaseX was called with unresolved secrets: %+v", server.Requests())
+	}
+}
+
+func TestSecretReferencesNeedTrustedServer(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("//item", "<item/>")
+	t.Setenv("BASEX_SECRET_IQS_PASSWORD", server.Password)
+
+	search := func(url string) map[string]interface{} {
+		return serveAction(t, e, map[string]interface{}{
+			"@type":  "SearchAction",
+			"query":  "//item",
+			"target": catalogWithCredentials(url, server.Username, "env:BASEX_SECRET_IQS_PASSWORD"),
+		})
+	}
+
+	// Without an allowlist, a url of the caller's choice gets no secrets
+	action := search(server.URL)
+	requireStatus(t, action, "FailedActionStatus")
+	if message := errorMessage(action); !strings.Contains(message, "secret references are only resolved") {
+		t.Errorf("error = %q", message)
+	}
+	if len(server.Requests()) != 0 {
+		t.Errorf("BaseX was called: %+v", server.Requests())
+	}
+
+	// The default server is configured by the operator
+	t.Setenv("BASEX_URL", server.URL)
+	requireStatus(t, search(server.URL), "CompletedActionStatus")
+
+	t.Setenv("BASEX_URL", "")
+	t.Setenv("BASEX_ALLOWED_URLS", server.URL)
+	requireStatus(t, search(server.URL), "CompletedActionStatus")
+}
+
+func TestFileSecretRejectsSymlinkEscape(t *testing.T) {
+	dir := t.TempDir()
+	outside := writeFile(t, t.TempDir(), "secret", "s3cret")
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	t.Setenv("BASEX_SECRETS_DIR", dir)
+	if _, err := fileSecret("link"); err == nil {
+		t.Error("symlink out of the secrets directory was followed")
+	}
+}
+
+func TestPlaintextPasswordsAreMasked(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("//item", "<item/>")
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/semantic/action", map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "SearchAction",
+		"query":    "//item",
+		"target":   catalogWithCredentials(server.URL, server.Username, "plaintext-password"),
+	})
+	action := decodeAction(t, rec)
+	requireStatus(t, action, "FailedActionStatus") // wrong password, but resolved
+	if strings.Contains(rec.Body.String(), "plaintext-password") {
+		t.Errorf("password echoed back: %s", rec.Body.String())
+	}
+
+	server.Password = "plaintext-password"
+	rec = serve(t, e, http.MethodPost, "/v1/api/queries", restCredentials(server, map[string]interface{}{
+		"query":    "//item",
+		"database": "db",
+	}))
+	requireStatus(t, decodeAction(t, rec), "CompletedActionStatus")
+	if strings.Contains(rec.Body.String(), "plaintext-password") {
+		t.Errorf("password echoed back: %s", rec.Body.String())
+	}
+
+	// The masked passwords are forgotten once the action is handled
+	requestSecrets.mu.Lock()
+	defer requestSecrets.mu.Unlock()
+	if n := len(requestSecrets.secrets); n != 0 {
+		t.Errorf("%d passwords kept after the actions", n)
+	}
+}
+
+func TestRegisterSecretProvider(t *testing.T) {
+	registerSecretProvider("test-vault", secretProviderFunc(func(name string) (string, error) {
+		return "vault:" + name, nil
+	}))
+	t.Cleanup(func() {
+		secretProvidersMu.Lock()
+		delete(secretProviders, "test-vault")
+		secretProvidersMu.Unlock()
+	})
+
+	if secret, err := resolveSecret("test-vault:basex/prod"); err != nil || secret != "vault:basex/prod" {
+		t.Errorf("resolveSecret = %q, %v", secret, err)
+	}
+	if secret, err := resolveSecret("no-scheme:value"); err != nil || secret != "no-scheme:value" {
+		t.Errorf("plain value = %q, %v", secret, err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a scheme twice did not panic")
+		}
+	}()
+	registerSecretProvider("env", secretProviderFunc(envSecret))
+}
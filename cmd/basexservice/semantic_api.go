@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -11,6 +14,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"basexservice.evalgo.org/basex"
 	"eve.evalgo.org/semantic"
 	"github.com/labstack/echo/v4"
 )
@@ -29,6 +33,15 @@ func handleSemanticAction(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Failed to parse action: %v", err))
 	}
 
+	// Hold scoped API keys to their actions, databases and profiles
+	if err := authorizeAction(c, action); err != nil {
+		return err
+	}
+
+	// Swap plaintext passwords for references resolved at call time, so
+	// they are not echoed back or recorded
+	defer requestSecrets.drop(maskActionPasswords(action))
+
 	// Dispatch to registered handler using the ActionRegistry
 	// No switch statement needed - handlers are registered at startup
 	return semantic.Handle(c, action)
@@ -41,6 +54,10 @@ func handleCreateActionImpl(c echo.Context, action *semantic.SemanticAction) err
 		// CreateAction + DigitalDocument = upload/store XML document
 		return executeUploadAction(c, action)
 	}
+	if action.Object != nil && action.Object.Type == "SoftwareSourceCode" {
+		// CreateAction + SoftwareSourceCode = register stored query
+		return executeRegisterStoredQueryAction(c, action)
+	}
 	// CreateAction + Database (or no object type) = create database
 	return executeCreateDatabaseAction(c, action)
 }
@@ -52,14 +69,18 @@ func handleDeleteActionImpl(c echo.Context, action *semantic.SemanticAction) err
 		// DeleteAction + DigitalDocument = delete document
 		return executeDeleteDocumentAction(c, action)
 	}
+	if action.Object != nil && action.Object.Type == "SoftwareSourceCode" {
+		// DeleteAction + SoftwareSourceCode = delete stored query
+		return executeDeleteStoredQueryAction(c, action)
+	}
 	// DeleteAction + Database (or no object type) = delete database
 	return executeDeleteDatabaseAction(c, action)
 }
 
 // executeTransformAction handles XSLT transformation operations
 func executeTransformActionImpl(c echo.Context, action *semantic.SemanticAction) error {
-	// Extract XSLT stylesheet and database using helpers
-	xslt, err := semantic.GetXSLTStylesheetFromAction(action)
+	// Extract XSLT stylesheets (a single one or an ordered pipeline) and database
+	stylesheets, err := getStylesheetsFromAction(action)
 	if err != nil {
 		return semantic.ReturnActionError(c, action, "Failed to extract XSLT stylesheet", err)
 	}
@@ -69,36 +90,196 @@ func executeTransformActionImpl(c echo.Context, action *semantic.SemanticAction)
 		return semantic.ReturnActionError(c, action, "Failed to extract XML database", err)
 	}
 
+	// Writes of the service to a database are serialized
+	unlock, err := lockDatabases(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	defer unlock()
+
 	// Extract target database credentials
-	baseURL, username, password, err := semantic.ExtractDatabaseCredentials(database)
+	client, err := clientForDatabase(database)
 	if err != nil {
 		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
 	}
+	// Stylesheets are uploaded before the transformation runs
+	if err := checkWritable(client); err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to upload XSLT", err)
+	}
 
-	// Get XSLT path
-	xsltPath := xslt.ContentUrl
-	if xsltPath == "" {
-		xsltPath = xslt.CodeRepository
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
 	}
-	if xsltPath == "" {
-		return semantic.ReturnActionError(c, action, "XSLT stylesheet path required", nil)
+	defer cancel()
+
+	// Extract xsl:param values bound at transform time
+	params, err := getStylesheetParameters(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract stylesheet parameters", err)
 	}
 
-	// Upload XSLT file to BaseX
-	if err := uploadXSLTToBaseX(baseURL, username, password, database.Identifier, xsltPath); err != nil {
-		return semantic.ReturnActionError(c, action, "Failed to upload XSLT", err)
+	// Get XSLT paths of the stages
+	xsltPaths := make([]string, 0, len(stylesheets))
+	for i, xslt := range stylesheets {
+		xsltPath := xslt.ContentUrl
+		if xsltPath == "" {
+			xsltPath = xslt.CodeRepository
+		}
+		if xsltPath == "" {
+			return semantic.ReturnActionError(c, action, fmt.Sprintf("XSLT stylesheet path required for stage %d", i+1), nil)
+		}
+		xsltPath, err := localFilePath(xsltPath)
+		if err != nil {
+			return semantic.ReturnActionError(c, action, "Stylesheet path not allowed", err)
+		}
+		xsltPaths = append(xsltPaths, xsltPath)
 	}
 
-	// TODO: Trigger transformation (implementation depends on BaseX setup)
+	graphs, err := resolveStylesheetGraphs(xsltPaths)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to resolve stylesheet dependencies", err)
+	}
+
+	// Check the parameters required by each stage and its modules
+	var info *stylesheetInfo
+	for i, graph := range graphs {
+		info = readStylesheetGraphInfo(graph)
+		if err := checkRequiredParameters(xsltPaths[i], info, params); err != nil {
+			return semantic.ReturnActionError(c, action, "Missing stylesheet parameter", err)
+		}
+	}
+
+	// Upload each stage with its imports to BaseX. Stages sharing a
+	// stylesheet file share its resource.
+	resources := make([]string, 0, len(graphs))
+	var uploaded []string
+	seen := make(map[string]bool)
+	for _, graph := range graphs {
+		for _, dependency := range graph.Resources {
+			if seen[dependency.Path] {
+				continue
+			}
+			if err := uploadXSLTToBaseX(ctx, client, database.Identifier, dependency.Path, dependency.Resource); err != nil {
+				return returnBaseXError(c, action, ctx, timeout, "Failed to upload XSLT", err)
+			}
+			seen[dependency.Path] = true
+			uploaded = append(uploaded, dependency.Resource)
+		}
+		resources = append(resources, graph.Root)
+	}
+	setActionProperty(action, "uploadedResources", uploaded)
+
+	// Resolve the source document named in object
+	source, err := resolveTransformSource(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to resolve source document", err)
+	}
+
+	// Run all stages inside BaseX so intermediate results stay server-side
+	trace, _ := actionAdditionalProperty(action, "trace").(bool)
+	result, err := client.Transform(ctx, database.Identifier, &basex.Transform{
+		Stylesheets: resources,
+		Resource:    source.Resource,
+		XML:         source.XML,
+		Params:      transformParams(params),
+		Trace:       trace,
+	})
+	if err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to execute transformation", err)
+	}
+	output := result.Output
+	if trace {
+		setActionProperty(action, "trace", result.Stages)
+	}
+
+	// The final stage determines the output format
+	format := info.Format
+
+	// Optionally store the transformation result back into the database
+	if targetPath := semantic.GetTargetUrlFromAction(action); targetPath != "" {
+		if err := client.PutDocument(ctx, database.Identifier, targetPath, bytes.NewReader(output), format); err != nil {
+			return returnBaseXError(c, action, ctx, timeout, "Failed to store transformation result", err)
+		}
+	}
+
+	action.Result = &semantic.SemanticResult{
+		Type:   "Dataset",
+		Format: format,
+		Output: string(output),
+	}
 	semantic.SetSuccessOnAction(action)
 	return c.JSON(http.StatusOK, action)
 }
 
+// actionProperty returns a raw JSON-LD property of the action
+func actionProperty(action *semantic.SemanticAction, key string) interface{} {
+	if value, ok := action.Properties[key]; ok {
+		return value
+	}
+	// Fall back to the serialized form for properties mapped to struct fields
+	data, err := json.Marshal(action)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	return fields[key]
+}
+
+// actionAdditionalProperty returns an option from the action's additionalProperty
+func actionAdditionalProperty(action *semantic.SemanticAction, key string) interface{} {
+	properties, ok := actionProperty(action, "additionalProperty").(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return properties[key]
+}
+
+// actionDatabase returns a copy of the DataCatalog or Database in the given
+// action property, or nil if there is none
+func actionDatabase(action *semantic.SemanticAction, key string) *semantic.XMLDatabase {
+	value, ok := actionProperty(action, key).(map[string]interface{})
+	if !ok || (value["@type"] != "DataCatalog" && value["@type"] != "Database") {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	database := &semantic.XMLDatabase{}
+	if err := json.Unmarshal(data, database); err != nil {
+		return nil
+	}
+	return database
+}
+
+// setActionProperty adds a JSON-LD property to the action response
+func setActionProperty(action *semantic.SemanticAction, key string, value interface{}) {
+	if action.Properties == nil {
+		action.Properties = make(map[string]interface{})
+	}
+	action.Properties[key] = value
+}
+
 // executeQueryAction handles XQuery execution operations
 func executeQueryActionImpl(c echo.Context, action *semantic.SemanticAction) error {
-	// Extract query and database using helpers
+	// SearchAction + SoftwareSourceCode = browse the stored-query registry
+	if action.Object != nil && action.Object.Type == "SoftwareSourceCode" {
+		return executeSearchStoredQueriesAction(c, action)
+	}
+
+	// Extract query text, or a reference to a stored query in the instrument
 	query := semantic.GetQueryFromAction(action)
-	if query == "" {
+	storedRef := getStoredQueryReference(action, "instrument")
+	if query == "" && storedRef == nil {
+		// SearchAction + DataCatalog without a query = browse databases
+		if database := actionDatabase(action, "object"); database != nil {
+			return executeBrowseAction(c, action, database)
+		}
 		return semantic.ReturnActionError(c, action, "Query is required", nil)
 	}
 
@@ -108,22 +289,174 @@ func executeQueryActionImpl(c echo.Context, action *semantic.SemanticAction) err
 	}
 
 	// Extract target database credentials
-	baseURL, username, password, err := semantic.ExtractDatabaseCredentials(database)
+	client, err := clientForDatabase(database)
 	if err != nil {
 		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
 	}
 
+	// Bound the BaseX requests by the action timeout and the client
+	// connection. Streamed results and results written to a targetUrl are
+	// not bound by the default timeout.
+	mode, _ := c.Get(streamModeKey).(string)
+	targetURL := semantic.GetTargetUrlFromAction(action)
+	newContext := baseXContext
+	if mode != "" || targetURL != "" {
+		newContext = baseXStreamContext
+	}
+	ctx, cancel, timeout, err := newContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
+	// Bind external variables declared by the query
+	var variables []basex.Variable
+	if query == "" {
+		stored, err := loadStoredQuery(ctx, client, storedRef.Name, storedRef.Version)
+		if err != nil {
+			return returnBaseXError(c, action, ctx, timeout, "Failed to load stored query", err)
+		}
+		variables, err = bindStoredQueryVariables(stored, getQueryVariableValues(action))
+		if err != nil {
+			return semantic.ReturnActionError(c, action, "Failed to bind stored query parameters", err)
+		}
+		query = stored.Text
+	} else {
+		variables, err = getQueryVariables(action)
+		if err != nil {
+			return semantic.ReturnActionError(c, action, "Failed to extract query variables", err)
+		}
+	}
+
+	// Read-only API keys may only run queries that do not update
+	if scope, ok := c.Get(readOnlyScopeKey).(string); ok {
+		if err := checkReadOnlyQuery(ctx, client, query); err != nil {
+			if errors.Is(err, errUpdatingQuery) {
+				return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("API key %q: %v", scope, err))
+			}
+			return returnBaseXError(c, action, ctx, timeout, "Failed to check query", err)
+		}
+	}
+
+	// Extract serialization parameters for the result
+	serialization, err := getQuerySerialization(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract serialization parameters", err)
+	}
+	if method, ok := c.Get(acceptedMethodKey).(string); ok {
+		serialization.SetMethod(method)
+	}
+
+	// Extract the requested page of the result
+	page, err := getQueryPage(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid pagination parameters", err)
+	}
+
+	// Streamed results are piped to the client or the target location
+	// without being buffered in memory
+	format := serialization.Format()
+	if mode == streamModeNDJSON {
+		query, serialization = buildNDJSONQuery(query, serialization)
+		format = ndjsonMediaType
+	}
+
+	// Count all items and select the page in the same evaluation. Pages in
+	// the action result are lists of the items, those sent raw or written
+	// out are the items serialized together.
+	raw, _ := c.Get(rawResultKey).(bool)
+	itemList := !raw && mode == "" && targetURL == ""
+	request := &basex.Query{Text: query, Variables: variables, Serialization: serialization}
+	if page != nil {
+		request.Text, request.Serialization = buildPagedQuery(query, page, serialization, itemList)
+	}
+
+	if mode != "" || targetURL != "" {
+		stream, err := client.QueryStream(ctx, database.Identifier, request)
+		if err != nil {
+			return returnBaseXError(c, action, ctx, timeout, "Failed to execute query", err)
+		}
+		defer func() { _ = stream.Close() }()
+
+		var result io.Reader = stream
+		nextCursor, total := "", 0
+		if page != nil {
+			reader := bufio.NewReader(stream)
+			if total, err = readPageTotal(reader); err != nil {
+				return returnBaseXError(c, action, ctx, timeout, "Failed to count query results", err)
+			}
+			nextCursor = setPageProperties(action, page, total)
+			result = reader
+		}
+
+		if mode != "" {
+			if page != nil {
+				setPageHeaders(c, total, nextCursor)
+			}
+			return streamQueryResult(c, format, result)
+		}
+
+		size, err := writeQueryStream(targetURL, format, result)
+		if err != nil {
+			return returnBaseXError(c, action, ctx, timeout, "Failed to write query result", err)
+		}
+		setActionProperty(action, "contentSize", size)
+
+		action.Result = &semantic.SemanticResult{
+			Type:   "MediaObject",
+			Format: format,
+			Output: targetURL, // contentUrl of the written result
+		}
+		semantic.SetSuccessOnAction(action)
+
+		return c.JSON(http.StatusOK, action)
+	}
+
 	// Execute XQuery against BaseX REST API
-	result, err := executeXQuery(baseURL, username, password, database.Identifier, query)
+	result, err := client.Query(ctx, database.Identifier, request)
 	if err != nil {
-		return semantic.ReturnActionError(c, action, "Failed to execute query", err)
+		return returnBaseXError(c, action, ctx, timeout, "Failed to execute query", err)
+	}
+	nextCursor, total := "", 0
+	if page != nil {
+		reader := bufio.NewReader(bytes.NewReader(result))
+		if total, err = readPageTotal(reader); err != nil {
+			return semantic.ReturnActionError(c, action, "Failed to count query results", err)
+		}
+		nextCursor = setPageProperties(action, page, total)
+		if itemList {
+			items, err := readPageItems(reader)
+			if err != nil {
+				return semantic.ReturnActionError(c, action, "Failed to read query results", err)
+			}
+			output, err := json.Marshal(newPageList(items, total, nextCursor))
+			if err != nil {
+				return semantic.ReturnActionError(c, action, "Failed to encode list", err)
+			}
+			action.Result = &semantic.SemanticResult{
+				Type:   "ItemList",
+				Format: "application/ld+json",
+				Output: string(output),
+			}
+			semantic.SetSuccessOnAction(action)
+			return c.JSON(http.StatusOK, action)
+		}
+		result, _ = io.ReadAll(reader)
+	}
+
+	// REST callers that asked for a specific media type get the raw result
+	if raw {
+		if page != nil {
+			setPageHeaders(c, total, nextCursor)
+		}
+		return c.Blob(http.StatusOK, format, result)
 	}
 
 	// Use semantic Result structure
 	action.Result = &semantic.SemanticResult{
 		Type:   "Dataset",
-		Format: "application/xml",
-		Output: string(result), // Raw XML result
+		Format: format,
+		Output: string(result), // Raw serialized result
 	}
 	semantic.SetSuccessOnAction(action)
 
@@ -143,19 +476,35 @@ func executeUploadAction(c echo.Context, action *semantic.SemanticAction) error
 		return semantic.ReturnActionError(c, action, "Failed to extract database", err)
 	}
 
+	// Writes of the service to a database are serialized
+	unlock, err := lockDatabases(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	defer unlock()
+
 	// Extract target database credentials
-	baseURL, username, password, err := semantic.ExtractDatabaseCredentials(database)
+	client, err := clientForDatabase(database)
 	if err != nil {
 		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
 	}
 
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
 	// Get file path
 	filePath := xmlDoc.ContentUrl
 	if filePath == "" {
 		return semantic.ReturnActionError(c, action, "Document contentUrl is required", nil)
 	}
 
-	// Check if contentUrl is an S3 URL and download if needed
+	// Check if contentUrl is an S3 URL and download if needed. Downloads
+	// are the service's own temporary files and not held to BASEX_FILE_ROOTS.
+	readFile := readLocalFile
 	if strings.HasPrefix(filePath, "s3://") {
 		fmt.Printf("DEBUG: Detected S3 URL: %s\n", filePath)
 		downloadedPath, err := downloadFromS3(filePath, xmlDoc.EncodingFormat)
@@ -164,11 +513,16 @@ func executeUploadAction(c echo.Context, action *semantic.SemanticAction) error
 		}
 		fmt.Printf("DEBUG: Downloaded to: %s\n", downloadedPath)
 		filePath = downloadedPath
+		readFile = os.ReadFile
 		defer func() {
 			_ = os.Remove(downloadedPath)
 		}()
 	} else {
 		fmt.Printf("DEBUG: Using local file path: %s\n", filePath)
+		filePath, err = localFilePath(filePath)
+		if err != nil {
+			return semantic.ReturnActionError(c, action, "File path not allowed", err)
+		}
 	}
 
 	// Determine target path in BaseX
@@ -177,9 +531,14 @@ func executeUploadAction(c echo.Context, action *semantic.SemanticAction) error
 		targetPath = xmlDoc.Identifier
 	}
 
+	fileData, err := readFile(filePath)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to read file", err)
+	}
+
 	// Upload file to BaseX
-	if err := uploadFileToBaseX(baseURL, username, password, database.Identifier, filePath, targetPath); err != nil {
-		return semantic.ReturnActionError(c, action, "Failed to upload file", err)
+	if err := uploadFileToBaseX(ctx, client, database.Identifier, fileData, targetPath); err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to upload file", err)
 	}
 
 	semantic.SetSuccessOnAction(action)
@@ -188,9 +547,14 @@ func executeUploadAction(c echo.Context, action *semantic.SemanticAction) error
 
 // executeCreateDatabaseAction handles database creation operations
 func executeCreateDatabaseAction(c echo.Context, action *semantic.SemanticAction) error {
-	// Extract database from result property
+	// Extract database from result property, or from the Database object
+	// sent by the REST adapter
 	var database *semantic.XMLDatabase
-	if result, ok := action.Properties["result"]; ok {
+	result, ok := action.Properties["result"]
+	if object, isMap := actionProperty(action, "object").(map[string]interface{}); !ok && isMap && object["@type"] == "Database" {
+		result, ok = object, true
+	}
+	if ok {
 		switch v := result.(type) {
 		case *semantic.XMLDatabase:
 			database = v
@@ -208,15 +572,29 @@ func executeCreateDatabaseAction(c echo.Context, action *semantic.SemanticAction
 		return semantic.ReturnActionError(c, action, "Database result is required", nil)
 	}
 
+	// Writes of the service to a database are serialized
+	unlock, err := lockDatabases(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	defer unlock()
+
 	// Extract database credentials
-	baseURL, username, password, err := semantic.ExtractDatabaseCredentials(database)
+	client, err := clientForDatabase(database)
 	if err != nil {
 		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
 	}
 
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
 	// Create database using BaseX REST API
-	if err := createBaseXDatabase(baseURL, username, password, database.Identifier); err != nil {
-		return semantic.ReturnActionError(c, action, "Failed to create database", err)
+	if err := client.CreateDatabase(ctx, database.Identifier); err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to create database", err)
 	}
 
 	semantic.SetSuccessOnAction(action)
@@ -229,9 +607,9 @@ func executeDeleteDatabaseAction(c echo.Context, action *semantic.SemanticAction
 	var database *semantic.XMLDatabase
 
 	// Try to get from Object field first
-	if action.Object != nil && action.Object.Type == "Database" {
-		// Convert Object to XMLDatabase
-		data, _ := json.Marshal(action.Object)
+	if object, ok := actionProperty(action, "object").(map[string]interface{}); ok && object["@type"] == "Database" {
+		// Convert Object to XMLDatabase, keeping its url and credentials
+		data, _ := json.Marshal(object)
 		database = &semantic.XMLDatabase{}
 		if err := json.Unmarshal(data, database); err == nil && database.Identifier != "" {
 			// Successfully extracted database from Object
@@ -260,15 +638,29 @@ func executeDeleteDatabaseAction(c echo.Context, action *semantic.SemanticAction
 		return semantic.ReturnActionError(c, action, "Database object or result is required", nil)
 	}
 
+	// Writes of the service to a database are serialized
+	unlock, err := lockDatabases(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	defer unlock()
+
 	// Extract database credentials
-	baseURL, username, password, err := semantic.ExtractDatabaseCredentials(database)
+	client, err := clientForDatabase(database)
 	if err != nil {
 		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
 	}
 
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
 	// Delete database using BaseX REST API: DELETE /rest/{db}
-	if err := deleteBaseXDatabase(baseURL, username, password, database.Identifier); err != nil {
-		return semantic.ReturnActionError(c, action, "Failed to delete database", err)
+	if err := client.DropDatabase(ctx, database.Identifier); err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to delete database", err)
 	}
 
 	semantic.SetSuccessOnAction(action)
@@ -288,12 +680,26 @@ func executeDeleteDocumentAction(c echo.Context, action *semantic.SemanticAction
 		return semantic.ReturnActionError(c, action, "Failed to extract database", err)
 	}
 
+	// Writes of the service to a database are serialized
+	unlock, err := lockDatabases(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	defer unlock()
+
 	// Extract target database credentials
-	baseURL, username, password, err := semantic.ExtractDatabaseCredentials(database)
+	client, err := clientForDatabase(database)
 	if err != nil {
 		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
 	}
 
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
 	// Determine document path to delete
 	documentPath := xmlDoc.Identifier
 	if documentPath == "" {
@@ -304,8 +710,8 @@ func executeDeleteDocumentAction(c echo.Context, action *semantic.SemanticAction
 	}
 
 	// Delete document using BaseX REST API: DELETE /rest/{db}/{resource}
-	if err := deleteBaseXDocument(baseURL, username, password, database.Identifier, documentPath); err != nil {
-		return semantic.ReturnActionError(c, action, "Failed to delete document", err)
+	if err := client.DeleteDocument(ctx, database.Identifier, documentPath); err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to delete document", err)
 	}
 
 	semantic.SetSuccessOnAction(action)
@@ -316,97 +722,41 @@ func executeDeleteDocumentAction(c echo.Context, action *semantic.SemanticAction
 // BaseX Client Functions
 // ============================================================================
 
-// uploadXSLTToBaseX uploads an XSLT file to BaseX database
-func uploadXSLTToBaseX(baseURL, username, password, dbName, xsltPath string) error {
-	// Open XSLT file
-	file, err := os.Open(xsltPath)
-	if err != nil {
-		return fmt.Errorf("failed to open XSLT file: %w", err)
-	}
-	defer func() { _ = file.Close() }()
-
-	// Extract filename from path
-	filename := xsltPath
-	if idx := len(xsltPath) - 1; idx >= 0 {
-		for i := idx; i >= 0; i-- {
-			if xsltPath[i] == '/' {
-				filename = xsltPath[i+1:]
-				break
-			}
-		}
+// newBaseXClient creates the client for a BaseX server. basex://host:port
+// URLs select the client/server protocol, other URLs the REST API. Tests
+// replace it to substitute a fake.
+var newBaseXClient = func(connection baseXConnection) (basex.Client, error) {
+	config := basex.Config{
+		URL:               connection.URL,
+		Username:          connection.Username,
+		Password:          connection.Password,
+		OnBackgroundError: onBaseXError,
 	}
-
-	// Upload to BaseX REST API: PUT /rest/{db}/{resource}
-	url := fmt.Sprintf("%s/rest/%s/%s", baseURL, dbName, filename)
-	req, err := http.NewRequest("PUT", url, file)
-	if err != nil {
-		return fmt.Errorf("failed to create upload request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/xml")
-	req.SetBasicAuth(username, password)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to upload XSLT: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("BaseX upload failed with status %d: %s", resp.StatusCode, string(body))
+	if connection.CheckAddresses {
+		config.HTTPClient, config.Dialer = allowlistHTTPClient, allowlistDialer
 	}
-
-	return nil
+	return basex.Open(config)
 }
 
-// executeXQuery executes an XQuery against BaseX database
-// For queries with doc() references, set database context in URL
-func executeXQuery(baseURL, username, password, dbName, query string) ([]byte, error) {
-	// BaseX REST API: POST /rest/{database} sets database context for doc() calls
-	// Query must be wrapped in XML: <query><text><![CDATA[...]]></text></query>
-	url := fmt.Sprintf("%s/rest/%s", baseURL, dbName)
-
-	// Wrap query in required XML structure with CDATA to avoid escaping issues
-	queryXML := fmt.Sprintf(`<query xmlns="http://basex.org/rest"><text><![CDATA[%s]]></text></query>`, query)
-
-	req, err := http.NewRequest("POST", url, bytes.NewBufferString(queryXML))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create query request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/xml")
-	req.SetBasicAuth(username, password)
+// onBaseXError is told about the BaseX errors no request waits for, such as
+// failures to stop cancelled queries. main logs them.
+var onBaseXError func(err error)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	result, err := io.ReadAll(resp.Body)
+// uploadXSLTToBaseX uploads an XSLT file to BaseX database as resource
+func uploadXSLTToBaseX(ctx context.Context, client basex.Client, dbName, xsltPath, resource string) error {
+	// Open XSLT file
+	file, err := openLocalFile(xsltPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read query result: %w", err)
-	}
-
-	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("BaseX query failed with status %d: %s", resp.StatusCode, string(result))
+		return fmt.Errorf("failed to open XSLT file: %w", err)
 	}
+	defer func() { _ = file.Close() }()
 
-	return result, nil
+	return client.PutDocument(ctx, dbName, resource, file, "application/xml")
 }
 
-// uploadFileToBaseX uploads a file to BaseX database
+// uploadFileToBaseX uploads the content of a file to BaseX database
 // Extracts XML from JSON-LD if the file contains semantic structure
-func uploadFileToBaseX(baseURL, username, password, dbName, filePath, targetPath string) error {
-	// Read file content
-	fileData, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
-	}
-
+func uploadFileToBaseX(ctx context.Context, client basex.Client, dbName string, fileData []byte, targetPath string) error {
 	// Check if file contains JSON-LD and extract the actual XML content
 	dataToUpload := fileData
 	var jsonData map[string]interface{}
@@ -421,55 +771,20 @@ func uploadFileToBaseX(baseURL, username, password, dbName, filePath, targetPath
 		}
 	}
 
-	url := fmt.Sprintf("%s/rest/%s/%s", baseURL, dbName, targetPath)
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(dataToUpload))
-	if err != nil {
-		return fmt.Errorf("failed to create upload request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/xml")
-	req.SetBasicAuth(username, password)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("BaseX upload failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	return nil
+	return client.PutDocument(ctx, dbName, targetPath, bytes.NewReader(dataToUpload), "application/xml")
 }
 
-// createBaseXDatabase creates a new BaseX database
-func createBaseXDatabase(baseURL, username, password, dbName string) error {
-	// Create database via BaseX REST API: PUT /rest/{db}
-	url := fmt.Sprintf("%s/rest/%s", baseURL, dbName)
-
-	req, err := http.NewRequest("PUT", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create database request: %w", err)
-	}
-
-	req.SetBasicAuth(username, password)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to create database: %w", err)
+// s3ServiceEndpoint returns the s3service URL from S3_SERVICE_URL, checked
+// against BASEX_ALLOWED_S3_SERVICES
+func s3ServiceEndpoint() (string, error) {
+	s3ServiceURL := os.Getenv("S3_SERVICE_URL")
+	if s3ServiceURL == "" {
+		s3ServiceURL = "http://localhost:8092"
 	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("BaseX create database failed with status %d: %s", resp.StatusCode, string(body))
+	if err := checkAllowed(s3ServiceAllowlist, s3ServiceURL); err != nil {
+		return "", fmt.Errorf("s3service endpoint rejected: %w", err)
 	}
-
-	return nil
+	return s3ServiceURL, nil
 }
 
 // downloadFromS3 downloads a file from S3 by calling s3service
@@ -526,9 +841,9 @@ func downloadFromS3(s3URL, encodingFormat string) (string, error) {
 		return "", fmt.Errorf("failed to marshal download action: %w", err)
 	}
 
-	s3ServiceURL := os.Getenv("S3_SERVICE_URL")
-	if s3ServiceURL == "" {
-		s3ServiceURL = "http://localhost:8092"
+	s3ServiceURL, err := s3ServiceEndpoint()
+	if err != nil {
+		return "", err
 	}
 
 	resp, err := http.Post(s3ServiceURL+"/v1/api/semantic/action", "application/ld+json", bytes.NewBuffer(actionBytes))
@@ -557,55 +872,83 @@ func downloadFromS3(s3URL, encodingFormat string) (string, error) {
 	return downloadPath, nil
 }
 
-// deleteBaseXDatabase deletes a BaseX database
-func deleteBaseXDatabase(baseURL, username, password, dbName string) error {
-	// Delete database via BaseX REST API: DELETE /rest/{db}
-	url := fmt.Sprintf("%s/rest/%s", baseURL, dbName)
-
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create delete database request: %w", err)
+// uploadToS3 uploads a local file to S3 via s3service
+func uploadToS3(filePath, s3URL, encodingFormat string) error {
+	// Parse S3 URL: s3://bucket/key
+	s3URL = strings.TrimPrefix(s3URL, "s3://")
+	parts := strings.SplitN(s3URL, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid S3 URL format, expected s3://bucket/key")
 	}
+	bucket := parts[0]
+	key := parts[1]
 
-	req.SetBasicAuth(username, password)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to delete database: %w", err)
+	// Get S3 credentials from environment
+	s3URL_env := os.Getenv("HETZNER_S3_URL")
+	if s3URL_env == "" {
+		s3URL_env = "https://fsn1.your-objectstorage.com"
 	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("BaseX delete database failed with status %d: %s", resp.StatusCode, string(body))
+	region := os.Getenv("HETZNER_S3_REGION")
+	if region == "" {
+		region = "fsn1"
 	}
+	accessKey := os.Getenv("HETZNER_S3_ACCESS_KEY")
+	secretKey := os.Getenv("HETZNER_S3_SECRET_KEY")
 
-	return nil
-}
-
-// deleteBaseXDocument deletes a document from BaseX database
-func deleteBaseXDocument(baseURL, username, password, dbName, docPath string) error {
-	// Delete document via BaseX REST API: DELETE /rest/{db}/{resource}
-	url := fmt.Sprintf("%s/rest/%s/%s", baseURL, dbName, docPath)
+	// Build S3UploadAction request
+	uploadAction := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "UploadAction",
+		"object": map[string]interface{}{
+			"@type":          "MediaObject",
+			"identifier":     key,
+			"encodingFormat": encodingFormat,
+			"contentUrl":     filePath,
+		},
+		"target": map[string]interface{}{
+			"@type":      "DataCatalog",
+			"identifier": bucket,
+			"url":        s3URL_env,
+			"additionalProperty": map[string]interface{}{
+				"region":    region,
+				"accessKey": accessKey,
+				"secretKey": secretKey,
+			},
+		},
+	}
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	// Call s3service
+	actionBytes, err := json.Marshal(uploadAction)
 	if err != nil {
-		return fmt.Errorf("failed to create delete document request: %w", err)
+		return fmt.Errorf("failed to marshal upload action: %w", err)
 	}
 
-	req.SetBasicAuth(username, password)
+	s3ServiceURL, err := s3ServiceEndpoint()
+	if err != nil {
+		return err
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := http.Post(s3ServiceURL+"/v1/api/semantic/action", "application/ld+json", bytes.NewBuffer(actionBytes))
 	if err != nil {
-		return fmt.Errorf("failed to delete document: %w", err)
+		return fmt.Errorf("failed to call s3service: %w", err)
 	}
-	defer func() { _ = resp.Body.Close() }()
+	defer func() {
+		_ = resp.Body.Close()
+	}()
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("BaseX delete document failed with status %d: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("s3service returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// Parse response to verify success
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to parse s3service response: %w", err)
+	}
+
+	if status, ok := result["actionStatus"].(string); ok && status != "CompletedActionStatus" {
+		return fmt.Errorf("s3service upload failed with status: %s", status)
 	}
 
 	return nil
@@ -658,3 +1001,39 @@ func handleUploadAction(c echo.Context, actionInterface interface{}) error {
 	}
 	return executeUploadAction(c, action)
 }
+
+// handleDownloadAction wraps the download implementation to match ActionHandler signature
+func handleDownloadAction(c echo.Context, actionInterface interface{}) error {
+	action, ok := actionInterface.(*semantic.SemanticAction)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid action type")
+	}
+	return executeDownloadActionImpl(c, action)
+}
+
+// handleUpdateAction wraps the update implementation to match ActionHandler signature
+func handleUpdateAction(c echo.Context, actionInterface interface{}) error {
+	action, ok := actionInterface.(*semantic.SemanticAction)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid action type")
+	}
+	return executeUpdateActionImpl(c, action)
+}
+
+// handleReplaceAction wraps the replace implementation to match ActionHandler signature
+func handleReplaceAction(c echo.Context, actionInterface interface{}) error {
+	action, ok := actionInterface.(*semantic.SemanticAction)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid action type")
+	}
+	return executeReplaceActionImpl(c, action)
+}
+
+// handleMoveAction wraps the move implementation to match ActionHandler signature
+func handleMoveAction(c echo.Context, actionInterface interface{}) error {
+	action, ok := actionInterface.(*semantic.SemanticAction)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid action type")
+	}
+	return executeMoveActionImpl(c, action)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"basexservice.evalgo.org/basex/basextest"
+)
+
+const testStylesheet = `<xsl:stylesheet version="3.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:import href="common/base.xsl"/>
+  <xsl:output method="text"/>
+  <xsl:param name="prefix" required="yes"/>
+</xsl:stylesheet>`
+
+const testBaseStylesheet = `<xsl:stylesheet version="3.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform"/>`
+
+func TestTransformAction(t *testing.T) {
+	e, server := newTestService(t)
+	server.CreateDatabase("db")
+	dir := t.TempDir()
+	xsltPath := writeFile(t, dir, "main.xsl", testStylesheet)
+	writeFile(t, dir, "common/base.xsl", testBaseStylesheet)
+
+	server.HandleQuery("xslt:transform-text", func(q *basextest.Query) basextest.Response {
+		return basextest.Response{Body: q.Variable("param1") + "result"}
+	})
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":      "TransformAction",
+		"instrument": map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": xsltPath},
+		"object":     map[string]interface{}{"@type": "DigitalDocument", "identifier": "input.xml"},
+		"target":     catalog(server, "db"),
+		"targetUrl":  "out/result.txt",
+		"parameters": map[string]interface{}{"prefix": "x-"},
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+
+	if got := resultOutput(action); got != "x-result" {
+		t.Errorf("output = %q", got)
+	}
+	if result := action["result"].(map[string]interface{}); result["encodingFormat"] != "text/plain" {
+		t.Errorf("encodingFormat = %v, want text/plain from xsl:output", result["encodingFormat"])
+	}
+	for _, path := range []string{"main.xsl", "common/base.xsl"} {
+		if _, ok := server.Resource("db", path); !ok {
+			t.Errorf("stylesheet %s was not uploaded", path)
+		}
+	}
+	if stored, ok := server.Resource("db", "out/result.txt"); !ok || string(stored.Content) != "x-result" {
+		t.Errorf("result stored at targetUrl = %+v", stored)
+	}
+	if q := server.Queries()[0]; !strings.Contains(q.Text, `doc("db/input.xml")`) {
+		t.Errorf("transform query does not read the source document:\n%s", q.Text)
+	}
+}
+
+func TestTransformActionRequiresParameters(t *testing.T) {
+	e, server := newTestService(t)
+	server.CreateDatabase("db")
+	dir := t.TempDir()
+	xsltPath := writeFile(t, dir, "main.xsl", testStylesheet)
+	writeFile(t, dir, "common/base.xsl", testBaseStylesheet)
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":      "TransformAction",
+		"instrument": map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": xsltPath},
+		"object":     map[string]interface{}{"@type": "DigitalDocument", "text": "<doc/>"},
+		"target":     catalog(server, "db"),
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "Missing stylesheet parameter") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+	if len(server.Requests()) != 0 {
+		t.Errorf("BaseX was called for an invalid transformation: %+v", server.Requests())
+	}
+}
+
+func TestTransformActionImportedDeclarations(t *testing.T) {
+	e, server := newTestService(t)
+	server.CreateDatabase("db")
+	dir := t.TempDir()
+	xsltPath := writeFile(t, dir, "main.xsl", `<xsl:stylesheet version="3.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:include href="lib/params.xsl"/>
+</xsl:stylesheet>`)
+	writeFile(t, dir, "lib/params.xsl", `<xsl:stylesheet version="3.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:output method="text"/>
+  <xsl:param name="lang" required="yes"/>
+</xsl:stylesheet>`)
+	server.RespondToQuery("xslt:transform-text", "done")
+
+	transform := func(params map[string]interface{}) map[string]interface{} {
+		return serveAction(t, e, map[string]interface{}{
+			"@type":      "TransformAction",
+			"instrument": map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": xsltPath},
+			"object":     map[string]interface{}{"@type": "DigitalDocument", "text": "<doc/>"},
+			"target":     catalog(server, "db"),
+			"parameters": params,
+		})
+	}
+
+	// The parameter required by the included module is checked up front
+	action := transform(nil)
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "lang") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+	if len(server.Requests()) != 0 {
+		t.Errorf("BaseX was called for an invalid transformation: %+v", server.Requests())
+	}
+
+	action = transform(map[string]interface{}{"lang": "en"})
+	requireStatus(t, action, "CompletedActionStatus")
+	if result := action["result"].(map[string]interface{}); result["encodingFormat"] != "text/plain" {
+		t.Errorf("encodingFormat = %v, want text/plain from the included xsl:output", result["encodingFormat"])
+	}
+}
+
+func TestTransformActionPipelineSameBasename(t *testing.T) {
+	e, server := newTestService(t)
+	server.CreateDatabase("db")
+	dir := t.TempDir()
+	first := writeFile(t, dir, "a/style.xsl", `<xsl:stylesheet version="3.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform"><!-- a --></xsl:stylesheet>`)
+	second := writeFile(t, dir, "b/style.xsl", `<xsl:stylesheet version="3.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform"><!-- b --></xsl:stylesheet>`)
+	server.RespondToQuery("xslt:transform-text", "done")
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type": "TransformAction",
+		"instrument": []interface{}{
+			map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": first},
+			map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": second},
+		},
+		"object": map[string]interface{}{"@type": "DigitalDocument", "text": "<doc/>"},
+		"target": catalog(server, "db"),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+
+	// Each stage runs its own stylesheet
+	for path, marker := range map[string]string{"a/style.xsl": "<!-- a -->", "b/style.xsl": "<!-- b -->"} {
+		if resource, ok := server.Resource("db", path); !ok || !strings.Contains(string(resource.Content), marker) {
+			t.Errorf("stylesheet %s = %+v", path, resource)
+		}
+	}
+	query := server.Queries()[0].Text
+	if !strings.Contains(query, `xslt:transform($stage0, doc("db/a/style.xsl")`) || !strings.Contains(query, `xslt:transform-text($stage1, doc("db/b/style.xsl")`) {
+		t.Errorf("pipeline query:\n%s", query)
+	}
+}
+
+func TestQueryAction(t *testing.T) {
+	e, server := newTestService(t)
+	server.HandleQuery("$scheme", func(q *basextest.Query) basextest.Response {
+		return basextest.Response{Body: "<concept scheme=\"" + q.Variable("scheme") + "\"/>"}
+	})
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":         "SearchAction",
+		"query":         "declare variable $scheme external; //concept[@scheme = $scheme]",
+		"variables":     map[string]interface{}{"scheme": "products"},
+		"serialization": map[string]interface{}{"method": "json", "indent": false},
+		"target":        catalog(server, "db"),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+
+	if got := resultOutput(action); got != `<concept scheme="products"/>` {
+		t.Errorf("output = %q", got)
+	}
+	if result := action["result"].(map[string]interface{}); result["encodingFormat"] != "application/json" {
+		t.Errorf("encodingFormat = %v", result["encodingFormat"])
+	}
+	q := server.Queries()[0]
+	if q.Database != "db" || q.Variables[0].Type != "xs:string" || len(q.Parameters) != 2 {
+		t.Errorf("query envelope = %+v", q)
+	}
+}
+
+func TestQueryActionPagination(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("subsequence($items", `5
+["<a/>","<b/>"]`)
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":         "SearchAction",
+		"query":         "declare namespace x = 'urn:x'; //item",
+		"limit":         2,
+		"offset":        1,
+		"serialization": map[string]interface{}{"indent": false},
+		"target":        catalog(server, "db"),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+
+	// The page is an ItemList like those of BrowseAction
+	var list struct {
+		Type            string   `json:"@type"`
+		NumberOfItems   int      `json:"numberOfItems"`
+		NextCursor      string   `json:"nextCursor"`
+		ItemListElement []string `json:"itemListElement"`
+	}
+	if err := json.Unmarshal([]byte(resultOutput(action)), &list); err != nil {
+		t.Fatalf("output %q: %v", resultOutput(action), err)
+	}
+	if list.Type != "ItemList" || list.NumberOfItems != 5 || strings.Join(list.ItemListElement, "|") != "<a/>|<b/>" {
+		t.Errorf("page list = %+v", list)
+	}
+	if offset, err := decodeCursor(list.NextCursor); err != nil || offset != 3 || action["nextCursor"] != list.NextCursor {
+		t.Errorf("nextCursor = %q, action %v (offset %d, %v)", list.NextCursor, action["nextCursor"], offset, err)
+	}
+	if action["numberOfItems"] != float64(5) {
+		t.Errorf("numberOfItems = %v", action["numberOfItems"])
+	}
+
+	// The query is evaluated once for the count and the page
+	if len(server.Queries()) != 1 {
+		t.Fatalf("%d queries, want 1", len(server.Queries()))
+	}
+	paged := server.Queries()[0].Text
+	for _, want := range []string{"declare namespace x = 'urn:x';", "count($items)", "subsequence($items, 2, 2) ! serialize(., map { \"indent\": \"no\" })"} {
+		if !strings.Contains(paged, want) {
+			t.Errorf("paged query lacks %q:\n%s", want, paged)
+		}
+	}
+}
+
+func TestQueryActionWritesTargetFile(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("//export", "<export/>")
+	target := filepath.Join(t.TempDir(), "exports", "result.xml")
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":     "SearchAction",
+		"query":     "//export",
+		"targetUrl": target,
+		"target":    catalog(server, "db"),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+
+	if got := resultOutput(action); got != target {
+		t.Errorf("output = %q, want the contentUrl %s", got, target)
+	}
+	data, err := os.ReadFile(target)
+	if err != nil || string(data) != "<export/>" {
+		t.Errorf("written result = %q, %v", data, err)
+	}
+}
+
+func TestQueryActionTimeout(t *testing.T) {
+	e, server := newTestService(t)
+	release := make(chan struct{})
+	server.HandleQuery("slow", func(*basextest.Query) basextest.Response {
+		<-release
+		return basextest.Response{}
+	})
+	server.HandleQuery("jobs:stop", func(*basextest.Query) basextest.Response {
+		close(release)
+		return basextest.Response{}
+	})
+	// The job is stopped asynchronously; keep the fake alive until it is
+	defer func() {
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+			t.Error("timed out query was not stopped")
+			close(release)
+		}
+	}()
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":              "SearchAction",
+		"query":              "slow",
+		"additionalProperty": map[string]interface{}{"timeout": "50ms"},
+		"target":             catalog(server, "db"),
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "timed out after 50ms") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+}
+
+func TestQueryActionReportsBaseXErrors(t *testing.T) {
+	e, server := newTestService(t)
+	server.HandleQuery("", func(*basextest.Query) basextest.Response {
+		return basextest.Response{Status: http.StatusBadRequest, Body: "[XPST0003] Unexpected end of query."}
+	})
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"query":  "1 +",
+		"target": catalog(server, "db"),
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "Failed to execute query") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+}
+
+func TestUploadActionUnwrapsJSONLD(t *testing.T) {
+	e, server := newTestService(t)
+	server.CreateDatabase("db")
+	path := writeFile(t, t.TempDir(), "doc.json", `{"@type": "Dataset", "result": "<doc/>"}`)
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":     "CreateAction",
+		"object":    map[string]interface{}{"@type": "DigitalDocument", "identifier": "doc.xml", "contentUrl": path},
+		"target":    catalog(server, "db"),
+		"targetUrl": "docs/doc.xml",
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+
+	if stored, ok := server.Resource("db", "docs/doc.xml"); !ok || string(stored.Content) != "<doc/>" {
+		t.Errorf("uploaded resource = %+v, want the XML extracted from the JSON-LD wrapper", stored)
+	}
+}
+
+func TestCreateAndDeleteDatabaseActions(t *testing.T) {
+	e, server := newTestService(t)
+
+	database := catalog(server, "db")
+	database["@type"] = "Database"
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "CreateAction",
+		"result": database,
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if !server.HasDatabase("db") {
+		t.Fatal("database was not created")
+	}
+
+	action = serveAction(t, e, map[string]interface{}{
+		"@type":  "DeleteAction",
+		"result": database,
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if server.HasDatabase("db") {
+		t.Fatal("database was not deleted")
+	}
+}
+
+func TestDeleteDocumentAction(t *testing.T) {
+	e, server := newTestService(t)
+	server.PutResource("db", "docs/doc.xml", "application/xml", []byte("<doc/>"))
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "DeleteAction",
+		"object": map[string]interface{}{"@type": "DigitalDocument", "identifier": "docs/doc.xml"},
+		"target": catalog(server, "db"),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if _, ok := server.Resource("db", "docs/doc.xml"); ok {
+		t.Error("document was not deleted")
+	}
+
+	server.FailNext("DELETE", "/rest/db/docs/other.xml", http.StatusForbidden, "Access denied.")
+	action = serveAction(t, e, map[string]interface{}{
+		"@type":  "DeleteAction",
+		"object": map[string]interface{}{"@type": "DigitalDocument", "identifier": "docs/other.xml"},
+		"target": catalog(server, "db"),
+	})
+	requireStatus(t, action, "FailedActionStatus")
+}
+
+func TestQueryActionOverProtocol(t *testing.T) {
+	e, _ := newTestService(t)
+	server := basextest.NewProtocolServer(t)
+	server.CreateDatabase("db")
+	server.HandleQuery("//concept", func(*basextest.Query) basextest.Response {
+		return basextest.Response{Items: []string{"<concept/>", "<concept/>"}}
+	})
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type": "SearchAction",
+		"query": "//concept",
+		"target": map[string]interface{}{
+			"@type":              "DataCatalog",
+			"identifier":         "db",
+			"url":                server.URL,
+			"additionalProperty": map[string]interface{}{"username": server.Username, "password": server.Password},
+		},
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if got := resultOutput(action); got != "<concept/><concept/>" {
+		t.Errorf("output = %q", got)
+	}
+	if q := server.Queries()[0]; q.Database != "db" {
+		t.Errorf("query ran against %q, want db", q.Database)
+	}
+}
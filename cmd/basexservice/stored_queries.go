@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"basexservice.evalgo.org/basex"
+	"eve.evalgo.org/semantic"
+	"github.com/labstack/echo/v4"
+)
+
+// storedQueryDatabase returns the BaseX system database holding the
+// stored-query registry
+func storedQueryDatabase() string {
+	if name := os.Getenv("BASEX_SYSTEM_DATABASE"); name != "" {
+		return name
+	}
+	return "basexservice-system"
+}
+
+// storedQuery is a named, versioned XQuery registered by an operator.
+// It is stored as queries/{name}/{version}.xml in the system database and
+// exposed as a schema.org SoftwareSourceCode.
+type storedQuery struct {
+	XMLName     xml.Name               `xml:"storedQuery" json:"-"`
+	Type        string                 `xml:"-" json:"@type,omitempty"`
+	Name        string                 `xml:"name,attr" json:"identifier"`
+	Version     string                 `xml:"version,attr" json:"version"`
+	Created     string                 `xml:"created,attr" json:"dateCreated,omitempty"`
+	Language    string                 `xml:"-" json:"programmingLanguage,omitempty"`
+	Description string                 `xml:"description,omitempty" json:"description,omitempty"`
+	Parameters  []storedQueryParameter `xml:"parameter" json:"parameters,omitempty"`
+	Text        string                 `xml:"text" json:"text"`
+}
+
+// createdAt returns the registration time. RFC 3339 timestamps with
+// fractional seconds do not sort as strings.
+func (q *storedQuery) createdAt() time.Time {
+	created, _ := time.Parse(time.RFC3339Nano, q.Created)
+	return created
+}
+
+// storedQueryParameter declares an external variable of a stored query
+type storedQueryParameter struct {
+	Name     string `xml:"name,attr" json:"name"`
+	Type     string `xml:"type,attr,omitempty" json:"type,omitempty"`
+	Required bool   `xml:"required,attr,omitempty" json:"required,omitempty"`
+	Default  string `xml:"default,attr,omitempty" json:"default,omitempty"`
+}
+
+// storedQueryNamePattern restricts names and versions to safe resource paths
+var storedQueryNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// executeRegisterStoredQueryAction handles CreateAction on a SoftwareSourceCode
+func executeRegisterStoredQueryAction(c echo.Context, action *semantic.SemanticAction) error {
+	data, err := json.Marshal(actionProperty(action, "object"))
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to parse stored query", err)
+	}
+	query := &storedQuery{}
+	if err := json.Unmarshal(data, query); err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to parse stored query", err)
+	}
+
+	if !storedQueryNamePattern.MatchString(query.Name) {
+		return semantic.ReturnActionError(c, action, "Stored query identifier is required and may only contain letters, digits, '_', '.' and '-'", nil)
+	}
+	if !storedQueryNamePattern.MatchString(query.Version) {
+		return semantic.ReturnActionError(c, action, "Stored query version is required and may only contain letters, digits, '_', '.' and '-'", nil)
+	}
+	if query.Text == "" {
+		return semantic.ReturnActionError(c, action, "Stored query text is required", nil)
+	}
+	for _, param := range query.Parameters {
+		if param.Name == "" {
+			return semantic.ReturnActionError(c, action, "Stored query parameters require a name", nil)
+		}
+	}
+
+	database, err := semantic.GetXMLDatabaseFromAction(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database", err)
+	}
+
+	// Registrations queue up behind the other writes to the registry
+	unlock, err := lockSystemDatabase(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	defer unlock()
+
+	client, err := clientForDatabase(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	if err := checkWritable(client); err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to register stored query", err)
+	}
+
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
+	query.Created = time.Now().UTC().Format(time.RFC3339Nano)
+	if err := registerStoredQuery(ctx, client, query); err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to register stored query", err)
+	}
+
+	semantic.SetSuccessOnAction(action)
+	return c.JSON(http.StatusOK, action)
+}
+
+// executeDeleteStoredQueryAction handles DeleteAction on a SoftwareSourceCode.
+// Without a version all versions of the stored query are removed.
+func executeDeleteStoredQueryAction(c echo.Context, action *semantic.SemanticAction) error {
+	ref := getStoredQueryReference(action, "object")
+	if ref == nil {
+		return semantic.ReturnActionError(c, action, "Stored query identifier is required", nil)
+	}
+
+	database, err := semantic.GetXMLDatabaseFromAction(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database", err)
+	}
+
+	// Deletions queue up behind the other writes to the registry
+	unlock, err := lockSystemDatabase(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	defer unlock()
+
+	client, err := clientForDatabase(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+	if err := checkWritable(client); err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to delete stored query", err)
+	}
+
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
+	if err := deleteStoredQuery(ctx, client, ref.Name, ref.Version); err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to delete stored query", err)
+	}
+
+	semantic.SetSuccessOnAction(action)
+	return c.JSON(http.StatusOK, action)
+}
+
+// executeSearchStoredQueriesAction handles SearchAction on a SoftwareSourceCode.
+// It lists the registry, optionally filtered by identifier and version.
+func executeSearchStoredQueriesAction(c echo.Context, action *semantic.SemanticAction) error {
+	database, err := semantic.GetXMLDatabaseFromAction(action)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database", err)
+	}
+
+	client, err := clientForDatabase(database)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to extract database credentials", err)
+	}
+
+	// Bound the BaseX requests by the action timeout and the client connection
+	ctx, cancel, timeout, err := baseXContext(c, action, client)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Invalid timeout", err)
+	}
+	defer cancel()
+
+	var name, version string
+	if ref := getStoredQueryReference(action, "object"); ref != nil {
+		name, version = ref.Name, ref.Version
+	}
+
+	queries, err := listStoredQueries(ctx, client, name)
+	if err != nil {
+		return returnBaseXError(c, action, ctx, timeout, "Failed to list stored queries", err)
+	}
+
+	matches := make([]*storedQuery, 0, len(queries))
+	for _, query := range queries {
+		if version == "" || query.Version == version {
+			query.Type = "SoftwareSourceCode"
+			query.Language = "XQuery"
+			matches = append(matches, query)
+		}
+	}
+	if name != "" && len(matches) == 0 {
+		return semantic.ReturnActionError(c, action, fmt.Sprintf("Stored query %q not found", name), nil)
+	}
+
+	output, err := json.Marshal(matches)
+	if err != nil {
+		return semantic.ReturnActionError(c, action, "Failed to encode stored queries", err)
+	}
+
+	action.Result = &semantic.SemanticResult{
+		Type:   "ItemList",
+		Format: "application/ld+json",
+		Output: string(output),
+	}
+	semantic.SetSuccessOnAction(action)
+	return c.JSON(http.StatusOK, action)
+}
+
+// getStoredQueryReference returns the identifier and optional version of the
+// SoftwareSourceCode in the given action property, or nil if there is none
+func getStoredQueryReference(action *semantic.SemanticAction, key string) *storedQuery {
+	code, ok := actionProperty(action, key).(map[string]interface{})
+	if !ok || code["@type"] != "SoftwareSourceCode" {
+		return nil
+	}
+	name, _ := code["identifier"].(string)
+	if name == "" {
+		return nil
+	}
+	version, _ := code["version"].(string)
+	return &storedQuery{Name: name, Version: version}
+}
+
+// bindStoredQueryVariables checks the supplied values against the declared
+// parameters of a stored query. Declared types apply to untyped values,
+// defaults fill in missing optional values, and missing required or
+// undeclared parameters are rejected.
+func bindStoredQueryVariables(query *storedQuery, values map[string]interface{}) ([]basex.Variable, error) {
+	bound := make(map[string]interface{}, len(query.Parameters))
+	declared := make(map[string]bool, len(query.Parameters))
+	for _, param := range query.Parameters {
+		declared[param.Name] = true
+
+		value, ok := values[param.Name]
+		if !ok {
+			value, ok = values["$"+param.Name]
+		}
+		if !ok {
+			if param.Default != "" {
+				value, ok = param.Default, true
+			} else if param.Required {
+				return nil, fmt.Errorf("stored query %s requires parameter %q", query.Name, param.Name)
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		if _, typed := value.(map[string]interface{}); !typed && param.Type != "" {
+			value = map[string]interface{}{"value": value, "type": param.Type}
+		}
+		bound[param.Name] = value
+	}
+
+	for name := range values {
+		if !declared[strings.TrimPrefix(name, "$")] {
+			return nil, fmt.Errorf("stored query %s does not declare parameter %q", query.Name, name)
+		}
+	}
+
+	return parseQueryVariables(bound)
+}
+
+// ============================================================================
+// Stored Query Registry Functions
+// ============================================================================
+
+// registerQuery stores a stored query version in one XQuery Update, creating
+// the system database on first use. It raises bxs:exists if the version is
+// already registered, so concurrent registrations cannot overwrite it.
+const registerQuery = `declare namespace bxs = 'urn:basexservice';
+declare variable $db external;
+declare variable $path external;
+declare variable $stored-query external;
+if (not(db:exists($db))) then db:create($db, parse-xml($stored-query), $path)
+else if (db:exists($db, $path)) then error(xs:QName('bxs:exists'), 'version already registered')
+else db:put($db, parse-xml($stored-query), $path)`
+
+// registerStoredQuery stores a new version of a query in the system
+// database. Registered versions are immutable.
+func registerStoredQuery(ctx context.Context, client basex.Client, query *storedQuery) error {
+	data, err := xml.Marshal(query)
+	if err != nil {
+		return fmt.Errorf("failed to encode stored query: %w", err)
+	}
+
+	variables := []basex.Variable{
+		{Name: "db", Value: storedQueryDatabase()},
+		{Name: "path", Value: storedQueryPath(query.Name, query.Version)},
+		{Name: "stored-query", Value: string(data)},
+	}
+	_, err = client.Query(ctx, "", &basex.Query{Text: registerQuery, Variables: variables})
+	var basexErr *basex.Error
+	if errors.As(err, &basexErr) && basexErr.Code == "bxs:exists" {
+		return fmt.Errorf("version %s of stored query %s is already registered", query.Version, query.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to register stored query: %w", err)
+	}
+	return nil
+}
+
+// loadStoredQuery returns a version of a stored query, or the most recently
+// registered version if version is empty
+func loadStoredQuery(ctx context.Context, client basex.Client, name, version string) (*storedQuery, error) {
+	queries, err := listStoredQueries(ctx, client, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *storedQuery
+	for _, query := range queries {
+		if version != "" {
+			if query.Version == version {
+				return query, nil
+			}
+			continue
+		}
+		if found == nil || query.createdAt().After(found.createdAt()) {
+			found = query
+		}
+	}
+
+	if found == nil {
+		if version != "" {
+			return nil, fmt.Errorf("version %s of stored query %s not found", version, name)
+		}
+		return nil, fmt.Errorf("stored query %s not found", name)
+	}
+	return found, nil
+}
+
+// listStoredQueries returns all registered versions of a stored query, or of
+// all stored queries if name is empty
+func listStoredQueries(ctx context.Context, client basex.Client, name string) ([]*storedQuery, error) {
+	listQuery := `declare variable $db external;
+declare variable $path external;
+<storedQueries>{
+  if (db:exists($db)) then collection($db || "/" || $path)/storedQuery else ()
+}</storedQueries>`
+
+	path := "queries/"
+	if name != "" {
+		path = storedQueryPath(name, "")
+	}
+	variables := []basex.Variable{{Name: "db", Value: storedQueryDatabase()}, {Name: "path", Value: path}}
+
+	result, err := client.Query(ctx, "", &basex.Query{Text: listQuery, Variables: variables})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored queries: %w", err)
+	}
+
+	var registry struct {
+		Queries []*storedQuery `xml:"storedQuery"`
+	}
+	if err := xml.Unmarshal(result, &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse stored queries: %w", err)
+	}
+	return registry.Queries, nil
+}
+
+// unregisterQuery deletes a stored query version, or all versions if $path
+// is a directory, in one XQuery Update. It raises bxs:missing if there is
+// nothing to delete.
+const unregisterQuery = `declare namespace bxs = 'urn:basexservice';
+declare variable $db external;
+declare variable $path external;
+if (db:exists($db) and exists(db:list($db, $path)[. = $path or ends-with($path, '/')]))
+then db:delete($db, $path)
+else error(xs:QName('bxs:missing'), 'stored query not found')`
+
+// deleteStoredQuery removes a version of a stored query, or all versions if
+// version is empty
+func deleteStoredQuery(ctx context.Context, client basex.Client, name, version string) error {
+	variables := []basex.Variable{{Name: "db", Value: storedQueryDatabase()}, {Name: "path", Value: storedQueryPath(name, version)}}
+
+	_, err := client.Query(ctx, "", &basex.Query{Text: unregisterQuery, Variables: variables})
+	var basexErr *basex.Error
+	if errors.As(err, &basexErr) && basexErr.Code == "bxs:missing" {
+		if version != "" {
+			return fmt.Errorf("version %s of stored query %s not found", version, name)
+		}
+		return fmt.Errorf("stored query %s not found", name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete stored query: %w", err)
+	}
+	return nil
+}
+
+// storedQueryPath returns the resource path of a stored query version, or
+// the directory holding all its versions if version is empty
+func storedQueryPath(name, version string) string {
+	if version == "" {
+		return "queries/" + name + "/"
+	}
+	return "queries/" + name + "/" + version + ".xml"
+}
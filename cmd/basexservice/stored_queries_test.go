@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"basexservice.evalgo.org/basex/basextest"
+)
+
+// scriptStoredQueryRegistry answers the registry queries of stored_queries.go
+// from the resources of the fake server
+func scriptStoredQueryRegistry(server *basextest.Server) {
+	// Registry updates check and write atomically, like a BaseX query would
+	var mu sync.Mutex
+	server.HandleQuery("declare variable $stored-query external;", func(q *basextest.Query) basextest.Response {
+		mu.Lock()
+		defer mu.Unlock()
+		db, path := q.Variable("db"), q.Variable("path")
+		if _, ok := server.Resource(db, path); ok {
+			return basextest.Response{Status: http.StatusBadRequest, Body: "Stopped at ., 1/1:\n[bxs:exists] version already registered"}
+		}
+		if !server.HasDatabase(db) {
+			server.CreateDatabase(db)
+		}
+		server.PutResource(db, path, "application/xml", []byte(q.Variable("stored-query")))
+		return basextest.Response{}
+	})
+	server.HandleQuery("collection($db", func(q *basextest.Query) basextest.Response {
+		var registry strings.Builder
+		registry.WriteString("<storedQueries>")
+		for _, path := range server.Resources(q.Variable("db")) {
+			if strings.HasPrefix(path, q.Variable("path")) {
+				resource, _ := server.Resource(q.Variable("db"), path)
+				registry.Write(resource.Content)
+			}
+		}
+		registry.WriteString("</storedQueries>")
+		return basextest.Response{Body: registry.String()}
+	})
+	server.HandleQuery("db:delete($db, $path)", func(q *basextest.Query) basextest.Response {
+		if server.DeleteResource(q.Variable("db"), q.Variable("path")) == 0 {
+			return basextest.Response{Status: http.StatusBadRequest, Body: "Stopped at ., 1/1:\n[bxs:missing] stored query not found"}
+		}
+		return basextest.Response{}
+	})
+}
+
+// putStoredQuery registers a stored query directly on the fake server
+func putStoredQuery(t *testing.T, server *basextest.Server, query *storedQuery) {
+	t.Helper()
+	data, err := xml.Marshal(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.PutResource(storedQueryDatabase(), storedQueryPath(query.Name, query.Version), "application/xml", data)
+}
+
+// registryCatalog returns the DataCatalog addressing the stored-query registry
+func registryCatalog(server *basextest.Server) map[string]interface{} {
+	return catalog(server, storedQueryDatabase())
+}
+
+func TestStoredQueryLifecycle(t *testing.T) {
+	e, server := newTestService(t)
+	scriptStoredQueryRegistry(server)
+
+	register := func(version, text string) map[string]interface{} {
+		return serveAction(t, e, map[string]interface{}{
+			"@type": "CreateAction",
+			"object": map[string]interface{}{
+				"@type":      "SoftwareSourceCode",
+				"identifier": "by-scheme",
+				"version":    version,
+				"text":       text,
+				"parameters": []map[string]interface{}{{"name": "scheme", "required": true}},
+			},
+			"target": registryCatalog(server),
+		})
+	}
+
+	requireStatus(t, register("1", "declare variable $scheme external; //v1[@scheme = $scheme]"), "CompletedActionStatus")
+	requireStatus(t, register("2", "declare variable $scheme external; //v2[@scheme = $scheme]"), "CompletedActionStatus")
+	if _, ok := server.Resource(storedQueryDatabase(), "queries/by-scheme/2.xml"); !ok {
+		t.Fatalf("stored query was not stored, resources: %v", server.Resources(storedQueryDatabase()))
+	}
+
+	// Registered versions are immutable
+	action := register("1", "//other")
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "already registered") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+
+	// Browse the registry
+	action = serveAction(t, e, map[string]interface{}{
+		"@type":  "SearchAction",
+		"object": map[string]interface{}{"@type": "SoftwareSourceCode", "identifier": "by-scheme"},
+		"target": registryCatalog(server),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	var listed []storedQuery
+	if err := json.Unmarshal([]byte(resultOutput(action)), &listed); err != nil || len(listed) != 2 {
+		t.Fatalf("listed stored queries = %s, %v", resultOutput(action), err)
+	}
+
+	// Execute the latest version with a checked parameter
+	server.HandleQuery("//v2", func(q *basextest.Query) basextest.Response {
+		return basextest.Response{Body: "<v2 scheme=\"" + q.Variable("scheme") + "\"/>"}
+	})
+	run := func(variables map[string]interface{}) map[string]interface{} {
+		return serveAction(t, e, map[string]interface{}{
+			"@type":      "SearchAction",
+			"instrument": map[string]interface{}{"@type": "SoftwareSourceCode", "identifier": "by-scheme"},
+			"variables":  variables,
+			"target":     registryCatalog(server),
+		})
+	}
+	action = run(map[string]interface{}{"scheme": "products"})
+	requireStatus(t, action, "CompletedActionStatus")
+	if got := resultOutput(action); got != `<v2 scheme="products"/>` {
+		t.Errorf("output = %q", got)
+	}
+	requireStatus(t, run(map[string]interface{}{}), "FailedActionStatus")
+	requireStatus(t, run(map[string]interface{}{"scheme": "x", "other": "y"}), "FailedActionStatus")
+
+	// Delete a single version, then the rest
+	action = serveAction(t, e, map[string]interface{}{
+		"@type":  "DeleteAction",
+		"object": map[string]interface{}{"@type": "SoftwareSourceCode", "identifier": "by-scheme", "version": "2"},
+		"target": registryCatalog(server),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if got := server.Resources(storedQueryDatabase()); len(got) != 1 || got[0] != "queries/by-scheme/1.xml" {
+		t.Errorf("resources after deleting version 2: %v", got)
+	}
+
+	action = serveAction(t, e, map[string]interface{}{
+		"@type":  "DeleteAction",
+		"object": map[string]interface{}{"@type": "SoftwareSourceCode", "identifier": "by-scheme"},
+		"target": registryCatalog(server),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if got := server.Resources(storedQueryDatabase()); len(got) != 0 {
+		t.Errorf("resources after deleting all versions: %v", got)
+	}
+}
+
+func TestStoredQueryConcurrentRegistration(t *testing.T) {
+	e, server := newTestService(t)
+	scriptStoredQueryRegistry(server)
+
+	// Registrations of the same version race; only one of them may win
+	var wg sync.WaitGroup
+	statuses := make(chan interface{}, 8)
+	for i := 0; i < cap(statuses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- serveAction(t, e, map[string]interface{}{
+				"@type":  "CreateAction",
+				"object": map[string]interface{}{"@type": "SoftwareSourceCode", "identifier": "race", "version": "1", "text": "1"},
+				"target": registryCatalog(server),
+			})["actionStatus"]
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	completed := 0
+	for status := range statuses {
+		if status == "CompletedActionStatus" {
+			completed++
+		}
+	}
+	if completed != 1 {
+		t.Errorf("%d registrations completed, want 1", completed)
+	}
+
+	// Deleting a missing version fails in the delete query itself
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "DeleteAction",
+		"object": map[string]interface{}{"@type": "SoftwareSourceCode", "identifier": "race", "version": "2"},
+		"target": registryCatalog(server),
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "version 2 of stored query race not found") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+}
+
+func TestRegisterStoredQueryValidation(t *testing.T) {
+	e, server := newTestService(t)
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":  "CreateAction",
+		"object": map[string]interface{}{"@type": "SoftwareSourceCode", "identifier": "../escape", "version": "1", "text": "1"},
+		"target": registryCatalog(server),
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if len(server.Requests()) != 0 {
+		t.Errorf("BaseX was called for an invalid stored query: %+v", server.Requests())
+	}
+}
+
+func TestBindStoredQueryVariables(t *testing.T) {
+	query := &storedQuery{
+		Name: "q",
+		Parameters: []storedQueryParameter{
+			{Name: "n", Type: "xs:integer", Required: true},
+			{Name: "lang", Default: "en"},
+		},
+	}
+
+	variables, err := bindStoredQueryVariables(query, map[string]interface{}{"$n": 3})
+	if err != nil {
+		t.Fatalf("bindStoredQueryVariables: %v", err)
+	}
+	byName := map[string]string{}
+	for _, v := range variables {
+		byName[v.Name] = v.Value + " " + v.Type
+	}
+	if byName["n"] != "3 xs:integer" || byName["lang"] != "en xs:string" {
+		t.Errorf("variables = %+v", variables)
+	}
+
+	if _, err := bindStoredQueryVariables(query, nil); err == nil {
+		t.Error("missing required parameter was accepted")
+	}
+	if _, err := bindStoredQueryVariables(query, map[string]interface{}{"n": 1, "x": 2}); err == nil {
+		t.Error("undeclared parameter was accepted")
+	}
+}
+
+func TestLoadLatestStoredQuery(t *testing.T) {
+	_, server := newTestService(t)
+	scriptStoredQueryRegistry(server)
+	// The later version's timestamp sorts first as a string
+	for version, created := range map[string]string{"1.0": "2025-01-01T10:00:00Z", "1.1": "2025-01-01T10:00:00.1Z"} {
+		putStoredQuery(t, server, &storedQuery{Name: "q", Version: version, Created: created, Text: "1"})
+	}
+
+	query, err := loadStoredQuery(context.Background(), server.Client(), "q", "")
+	if err != nil || query.Version != "1.1" {
+		t.Fatalf("latest version = %+v, %v", query, err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"basexservice.evalgo.org/basex"
+	"github.com/labstack/echo/v4"
+)
+
+// Stream modes of REST queries
+const (
+	// streamModeChunked pipes the serialized result to the client as is
+	streamModeChunked = "chunked"
+	// streamModeNDJSON writes one JSON value per result item and line
+	streamModeNDJSON = "ndjson"
+)
+
+// ndjsonMediaType is the content type of newline-delimited JSON
+const ndjsonMediaType = "application/x-ndjson"
+
+// buildNDJSONQuery wraps a query so every item of its result is serialized
+// as one line of JSON. Maps, arrays and atomic values are serialized as JSON
+// values, nodes as JSON strings holding their XML serialization. Database
+// options of the original serialization are kept.
+func buildNDJSONQuery(query string, serialization *basex.Serialization) (string, *basex.Serialization) {
+	prolog, body := splitProlog(query)
+	wrapped := fmt.Sprintf(`%sfor $item in (
+%s
+)
+let $value := if ($item instance of node()) then serialize($item) else $item
+return serialize($value, map { 'method': 'json', 'indent': 'no' })`, prolog, body)
+
+	ndjson := &basex.Serialization{
+		Parameters: []basex.Parameter{
+			{Name: "method", Value: "text"},
+			{Name: "item-separator", Value: "\n"},
+		},
+	}
+	if serialization != nil {
+		ndjson.Options = serialization.Options
+	}
+	return wrapped, ndjson
+}
+
+// setPageHeaders reports the pagination state of a raw query result
+func setPageHeaders(c echo.Context, total int, nextCursor string) {
+	c.Response().Header().Set("X-Total-Count", strconv.Itoa(total))
+	if nextCursor != "" {
+		c.Response().Header().Set("X-Next-Cursor", nextCursor)
+	}
+}
+
+// streamQueryResult pipes a query result to the client. No content length
+// is known in advance, so the response uses chunked transfer encoding.
+func streamQueryResult(c echo.Context, format string, stream io.Reader) error {
+	c.Response().Header().Set(echo.HeaderContentType, format)
+	c.Response().WriteHeader(http.StatusOK)
+
+	ndjson := format == ndjsonMediaType
+	written, err := io.Copy(&flushWriter{c.Response()}, stream)
+	if err != nil {
+		// The status line is already sent, so the error can only be logged
+		c.Logger().Errorf("streaming query result failed: %v", err)
+		return nil
+	}
+	if ndjson && written > 0 {
+		_, _ = c.Response().Write([]byte("\n"))
+	}
+	return nil
+}
+
+// flushWriter flushes every write so chunks reach the client while BaseX
+// is still serializing
+type flushWriter struct {
+	response *echo.Response
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	n, err := w.response.Write(p)
+	w.response.Flush()
+	return n, err
+}
+
+// writeQueryStream writes a query result to a local file (absolute path or
+// file:// URL) or an S3 object (s3:// URL) and returns the number of bytes
+// written
+func writeQueryStream(targetURL, format string, stream io.Reader) (int64, error) {
+	switch {
+	case strings.HasPrefix(targetURL, "s3://"):
+		// s3service uploads from the local filesystem
+		tmp, err := os.CreateTemp("", "basexservice-result-*")
+		if err != nil {
+			return 0, fmt.Errorf("failed to create temporary file: %w", err)
+		}
+		defer func() { _ = os.Remove(tmp.Name()) }()
+
+		size, err := io.Copy(tmp, stream)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to write temporary file: %w", err)
+		}
+
+		if err := uploadToS3(tmp.Name(), targetURL, format); err != nil {
+			return 0, err
+		}
+		return size, nil
+
+	case isLocalFileURL(targetURL):
+		path, err := localTargetPath(targetURL)
+		if err != nil {
+			return 0, err
+		}
+		file, err := createLocalFile(path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create file: %w", err)
+		}
+
+		size, err := io.Copy(file, stream)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = removeLocalFile(path)
+			return 0, fmt.Errorf("failed to write file: %w", err)
+		}
+		return size, nil
+	}
+
+	return 0, fmt.Errorf("unsupported targetUrl %q, expected an absolute path, file:// or s3:// URL", targetURL)
+}
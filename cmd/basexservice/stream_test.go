@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"basexservice.evalgo.org/basex"
+)
+
+func TestQueryRESTStreamsChunked(t *testing.T) {
+	e, server := newTestService(t)
+	server.RespondToQuery("subsequence($items", "3\n<a/>\n<b/>")
+
+	rec := serve(t, e, http.MethodPost, "/v1/api/queries", restCredentials(server, map[string]interface{}{
+		"query":  "//item",
+		"limit":  2,
+		"stream": "chunked",
+	}))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "<a/>\n<b/>" {
+		t.Fatalf("response = %d %q", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/xml") {
+		t.Errorf("Content-Type = %s", rec.Header().Get("Content-Type"))
+	}
+	if rec.Header().Get("X-Total-Count") != "3" || rec.Header().Get("X-Next-Cursor") == "" {
+		t.Errorf("page headers = %v", rec.Header())
+	}
+}
+
+func TestBuildNDJSONQuery(t *testing.T) {
+	options := []basex.Parameter{{Name: "stripws", Value: "yes"}}
+	query, serialization := buildNDJSONQuery("declare namespace x = 'urn:x';\n//x:item", &basex.Serialization{
+		Parameters: []basex.Parameter{{Name: "method", Value: "xml"}},
+		Options:    options,
+	})
+	if !strings.HasPrefix(query, "declare namespace x = 'urn:x';") || !strings.Contains(query, "for $item in (\n//x:item\n)") {
+		t.Errorf("query:\n%s", query)
+	}
+	if serialization.Parameter("method") != "text" || serialization.Parameter("item-separator") != "\n" || len(serialization.Options) != 1 {
+		t.Errorf("serialization = %+v", serialization)
+	}
+
+	// Empty results stream no trailing newline
+	e, server := newTestService(t)
+	server.RespondToQuery("'method': 'json'", "")
+	rec := serve(t, e, http.MethodPost, "/v1/api/queries", restCredentials(server, map[string]interface{}{
+		"query":  "()",
+		"stream": "ndjson",
+	}))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("empty stream = %d %q", rec.Code, rec.Body.String())
+	}
+}
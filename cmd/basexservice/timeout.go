@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"basexservice.evalgo.org/basex"
+	"eve.evalgo.org/semantic"
+	"github.com/labstack/echo/v4"
+)
+
+// defaultBaseXTimeout applies when BASEX_TIMEOUT is unset
+const defaultBaseXTimeout = 5 * time.Minute
+
+// baseXTimeout returns the default timeout of BaseX requests, taken from
+// BASEX_TIMEOUT (a duration such as "30s" or a number of seconds). Zero
+// disables the timeout.
+func baseXTimeout() time.Duration {
+	value := os.Getenv("BASEX_TIMEOUT")
+	if value == "" {
+		return defaultBaseXTimeout
+	}
+	timeout, err := parseTimeout(value)
+	if err != nil {
+		return defaultBaseXTimeout
+	}
+	return timeout
+}
+
+// parseTimeout parses a duration such as "30s" or a number of seconds
+func parseTimeout(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case float64:
+		if v < 0 {
+			return 0, fmt.Errorf("timeout must not be negative")
+		}
+		return time.Duration(v * float64(time.Second)), nil
+	case string:
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+			return parseTimeout(seconds)
+		}
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout < 0 {
+			return 0, fmt.Errorf("invalid timeout %q", v)
+		}
+		return timeout, nil
+	}
+	return 0, fmt.Errorf("invalid timeout %v", value)
+}
+
+// baseXContext returns the context for the BaseX requests of an action. It
+// ends when the client disconnects or the timeout passes. The timeout is
+// taken from the action's timeout property or additionalProperty.timeout,
+// falling back to the timeout of the connection profile of client and then
+// to the default.
+func baseXContext(c echo.Context, action *semantic.SemanticAction, client basex.Client) (context.Context, context.CancelFunc, time.Duration, error) {
+	return baseXContextWithDefault(c, action, client, baseXTimeout())
+}
+
+// baseXStreamContext is baseXContext for actions streaming their result to
+// the client or writing it to a targetUrl. Transfers of large results take
+// as long as they take, so only the timeout of the action or the connection
+// profile bounds them, not BASEX_TIMEOUT.
+func baseXStreamContext(c echo.Context, action *semantic.SemanticAction, client basex.Client) (context.Context, context.CancelFunc, time.Duration, error) {
+	return baseXContextWithDefault(c, action, client, 0)
+}
+
+// baseXContextWithDefault returns the context of baseXContext, falling back
+// to timeout if neither the action nor the connection profile sets one
+func baseXContextWithDefault(c echo.Context, action *semantic.SemanticAction, client basex.Client, timeout time.Duration) (context.Context, context.CancelFunc, time.Duration, error) {
+	if p, ok := client.(*profileClient); ok && p.profile.Timeout != nil {
+		timeout = *p.profile.Timeout
+	}
+
+	value := actionProperty(action, "timeout")
+	if value == nil {
+		value = actionAdditionalProperty(action, "timeout")
+	}
+	if value != nil {
+		var err error
+		if timeout, err = parseTimeout(value); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	ctx := c.Request().Context()
+	if timeout == 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, 0, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, timeout, nil
+}
+
+// returnBaseXError fails the action like semantic.ReturnActionError, naming
+// the timeout or the cancellation if the context ended the BaseX request
+func returnBaseXError(c echo.Context, action *semantic.SemanticAction, ctx context.Context, timeout time.Duration, message string, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		message = fmt.Sprintf("%s: timed out after %s", message, timeout)
+	case errors.Is(ctx.Err(), context.Canceled):
+		message = fmt.Sprintf("%s: cancelled by client", message)
+	}
+	return semantic.ReturnActionError(c, action, message, err)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"basexservice.evalgo.org/basex/basextest"
+)
+
+func TestParseTimeout(t *testing.T) {
+	for value, want := range map[interface{}]time.Duration{
+		float64(2): 2 * time.Second,
+		0.25:       250 * time.Millisecond,
+		"30":       30 * time.Second,
+		"1m30s":    90 * time.Second,
+		"0":        0,
+	} {
+		if got, err := parseTimeout(value); err != nil || got != want {
+			t.Errorf("%v: timeout = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []interface{}{float64(-1), "-5s", "soon", true} {
+		if _, err := parseTimeout(value); err == nil {
+			t.Errorf("%v: no error", value)
+		}
+	}
+
+	// BASEX_TIMEOUT sets the default, which stays in place when invalid
+	for value, want := range map[string]time.Duration{"": defaultBaseXTimeout, "90": 90 * time.Second, "0": 0, "never": defaultBaseXTimeout} {
+		t.Setenv("BASEX_TIMEOUT", value)
+		if got := baseXTimeout(); got != want {
+			t.Errorf("BASEX_TIMEOUT=%q: timeout = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestQueryActionInvalidTimeout(t *testing.T) {
+	e, server := newTestService(t)
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":   "SearchAction",
+		"query":   "1",
+		"timeout": "soon",
+		"target":  catalog(server, "db"),
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "Invalid timeout") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+	if len(server.Requests()) != 0 {
+		t.Errorf("BaseX was called with an invalid timeout: %+v", server.Requests())
+	}
+}
+
+func TestQueryActionTargetURLDefaultTimeout(t *testing.T) {
+	e, server := newTestService(t)
+	t.Setenv("BASEX_TIMEOUT", "50ms")
+	server.HandleQuery("//export", func(*basextest.Query) basextest.Response {
+		time.Sleep(200 * time.Millisecond)
+		return basextest.Response{Body: "<export/>"}
+	})
+	server.RespondToQuery("jobs:stop", "")
+	query := func(properties map[string]interface{}) map[string]interface{} {
+		action := map[string]interface{}{"@type": "SearchAction", "query": "//export", "target": catalog(server, "db")}
+		for key, value := range properties {
+			action[key] = value
+		}
+		return serveAction(t, e, action)
+	}
+
+	// Buffered results are bound by BASEX_TIMEOUT
+	action := query(nil)
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "timed out after 50ms") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+
+	// Results written to a targetUrl only by their own timeout
+	target := filepath.Join(t.TempDir(), "export.xml")
+	requireStatus(t, query(map[string]interface{}{"targetUrl": target}), "CompletedActionStatus")
+	if content, err := os.ReadFile(target); err != nil || string(content) != "<export/>" {
+		t.Errorf("written result = %q, %v", content, err)
+	}
+	action = query(map[string]interface{}{"targetUrl": target, "timeout": "50ms"})
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "timed out after 50ms") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"basexservice.evalgo.org/basex"
+	"eve.evalgo.org/semantic"
+)
+
+// getStylesheetsFromAction returns the stylesheets of the action instrument.
+// The instrument is either a single stylesheet, an ordered list of stylesheets
+// or an ItemList whose itemListElement holds the pipeline stages.
+func getStylesheetsFromAction(action *semantic.SemanticAction) ([]*semantic.XSLTStylesheet, error) {
+	var stages []interface{}
+	switch instrument := actionProperty(action, "instrument").(type) {
+	case []interface{}:
+		stages = instrument
+	case map[string]interface{}:
+		if instrument["@type"] == "ItemList" {
+			stages, _ = instrument["itemListElement"].([]interface{})
+		}
+	}
+
+	if stages == nil {
+		xslt, err := semantic.GetXSLTStylesheetFromAction(action)
+		if err != nil {
+			return nil, err
+		}
+		return []*semantic.XSLTStylesheet{xslt}, nil
+	}
+
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("stylesheet pipeline is empty")
+	}
+
+	stylesheets := make([]*semantic.XSLTStylesheet, 0, len(stages))
+	for i, stage := range stages {
+		// ListItem entries wrap the stylesheet in item
+		if listItem, ok := stage.(map[string]interface{}); ok && listItem["@type"] == "ListItem" {
+			stage = listItem["item"]
+		}
+
+		data, err := json.Marshal(stage)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stylesheet at stage %d: %w", i+1, err)
+		}
+		xslt := &semantic.XSLTStylesheet{}
+		if err := json.Unmarshal(data, xslt); err != nil {
+			return nil, fmt.Errorf("invalid stylesheet at stage %d: %w", i+1, err)
+		}
+		stylesheets = append(stylesheets, xslt)
+	}
+
+	return stylesheets, nil
+}
+
+// transformSource is the XML input of a transformation: either a resource
+// stored in the target database or an XML document passed by value
+type transformSource struct {
+	Resource string
+	XML      string
+}
+
+// resolveTransformSource extracts the source document from the action object.
+// Inline text and local or S3 contentUrls are read by value, any other
+// contentUrl or identifier is treated as a resource path in the database.
+func resolveTransformSource(action *semantic.SemanticAction) (*transformSource, error) {
+	if action.Object == nil || action.Object.Type == "Database" || action.Object.Type == "DataCatalog" {
+		return nil, fmt.Errorf("object must name the source document")
+	}
+
+	if object, ok := actionProperty(action, "object").(map[string]interface{}); ok {
+		if text, ok := object["text"].(string); ok && text != "" {
+			return &transformSource{XML: text}, nil
+		}
+	}
+
+	xmlDoc, err := semantic.GetXMLDocumentFromAction(action)
+	if err != nil {
+		return nil, err
+	}
+
+	contentURL := xmlDoc.ContentUrl
+	switch {
+	case strings.HasPrefix(contentURL, "s3://"):
+		downloadedPath, err := downloadFromS3(contentURL, xmlDoc.EncodingFormat)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download from S3: %w", err)
+		}
+		defer func() {
+			_ = os.Remove(downloadedPath)
+		}()
+		data, err := os.ReadFile(downloadedPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read source document: %w", err)
+		}
+		return &transformSource{XML: string(data)}, nil
+	case isLocalFileURL(contentURL):
+		path, err := localFilePath(contentURL)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readLocalFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read source document: %w", err)
+		}
+		return &transformSource{XML: string(data)}, nil
+	case contentURL != "":
+		return &transformSource{Resource: contentURL}, nil
+	case xmlDoc.Identifier != "":
+		return &transformSource{Resource: xmlDoc.Identifier}, nil
+	}
+
+	return nil, fmt.Errorf("source document requires text, contentUrl or identifier")
+}
+
+// stylesheetParameter is a typed xsl:param value
+type stylesheetParameter struct {
+	Type  string
+	Value string
+}
+
+// getStylesheetParameters returns the xsl:param values of the action, taken
+// from its parameters property or additionalProperty.parameters. Values are
+// plain JSON scalars or objects of the form
+// {"value": ..., "type": "string|number|integer|boolean|node"}.
+func getStylesheetParameters(action *semantic.SemanticAction) (map[string]stylesheetParameter, error) {
+	raw, ok := actionProperty(action, "parameters").(map[string]interface{})
+	if !ok {
+		raw, _ = actionAdditionalProperty(action, "parameters").(map[string]interface{})
+	}
+
+	params := make(map[string]stylesheetParameter, len(raw))
+	for name, value := range raw {
+		hint, text, err := typedValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for parameter %q: %w", name, err)
+		}
+		if hint == "nodeset" || hint == "node-set" {
+			hint = "node"
+		}
+		if _, ok := valueTypes[hint]; !ok {
+			return nil, fmt.Errorf("unsupported type %q for parameter %q", hint, name)
+		}
+		params[name] = stylesheetParameter{Type: hint, Value: text}
+	}
+	return params, nil
+}
+
+// transformParams converts xsl:param values to the typed params of a
+// transform. Node sets may have several roots, so they are parsed as fragments.
+func transformParams(params map[string]stylesheetParameter) map[string]basex.Param {
+	converted := make(map[string]basex.Param, len(params))
+	for name, param := range params {
+		converted[name] = basex.Param{
+			Value:    param.Value,
+			Type:     valueTypes[param.Type],
+			Fragment: param.Type == "node",
+		}
+	}
+	return converted
+}
+
+// checkRequiredParameters returns an error naming the first required
+// parameter of a stylesheet that has no value
+func checkRequiredParameters(xsltPath string, info *stylesheetInfo, params map[string]stylesheetParameter) error {
+	for _, name := range info.RequiredParams {
+		if _, ok := params[name]; !ok {
+			return fmt.Errorf("stylesheet %s requires parameter %q", filepath.Base(xsltPath), name)
+		}
+	}
+	return nil
+}
+
+// stylesheetResource maps a local stylesheet file to its resource path in
+// the database
+type stylesheetResource struct {
+	Path     string
+	Resource string
+}
+
+// stylesheetGraph is a stylesheet together with everything it imports
+type stylesheetGraph struct {
+	Root      string
+	Resources []stylesheetResource
+}
+
+// resolveStylesheetGraphs follows the xsl:import and xsl:include hrefs of
+// the stylesheets of a pipeline recursively, relative to each importing file.
+// Resource paths are relative to the closest directory containing all the
+// graphs, so relative hrefs keep resolving once the files are stored in the
+// database, and stylesheets of different stages never share a resource path.
+// A single stylesheet without dependencies is stored under its basename.
+func resolveStylesheetGraphs(xsltPaths []string) ([]*stylesheetGraph, error) {
+	closures := make([][]string, 0, len(xsltPaths))
+	for _, xsltPath := range xsltPaths {
+		root, err := filepath.Abs(strings.TrimPrefix(xsltPath, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		paths, err := stylesheetClosure(root)
+		if err != nil {
+			return nil, err
+		}
+		closures = append(closures, paths)
+	}
+
+	// Find the closest directory containing every stylesheet of the graphs
+	baseDir := filepath.Dir(closures[0][0])
+	for _, paths := range closures {
+		for _, path := range paths {
+			for !isWithinDir(baseDir, path) {
+				parent := filepath.Dir(baseDir)
+				if parent == baseDir {
+					break
+				}
+				baseDir = parent
+			}
+		}
+	}
+
+	graphs := make([]*stylesheetGraph, 0, len(closures))
+	for _, paths := range closures {
+		graph := &stylesheetGraph{}
+		for _, path := range paths {
+			rel, err := filepath.Rel(baseDir, path)
+			if err != nil {
+				return nil, err
+			}
+			graph.Resources = append(graph.Resources, stylesheetResource{Path: path, Resource: filepath.ToSlash(rel)})
+		}
+		graph.Root = graph.Resources[0].Resource
+		graphs = append(graphs, graph)
+	}
+	return graphs, nil
+}
+
+// stylesheetClosure returns the absolute paths of a stylesheet and of all
+// the local stylesheets it imports or includes, the stylesheet first
+func stylesheetClosure(root string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	var visit func(path string) error
+	visit = func(path string) error {
+		if seen[path] {
+			return nil
+		}
+		seen[path] = true
+		paths = append(paths, path)
+
+		hrefs, err := stylesheetDependencies(path)
+		if err != nil {
+			return err
+		}
+		for _, href := range hrefs {
+			dependency, ok := resolveStylesheetHref(path, href)
+			if !ok {
+				continue
+			}
+			dependency, err := checkFileRoots(dependency, true)
+			if err != nil {
+				return fmt.Errorf("stylesheet %s imports %s: %w", filepath.Base(path), href, err)
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(root); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// stylesheetDependencies returns the hrefs of all xsl:import and xsl:include
+// elements of a stylesheet
+func stylesheetDependencies(xsltPath string) ([]string, error) {
+	data, err := readLocalFile(xsltPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stylesheet %s: %w", xsltPath, err)
+	}
+
+	var hrefs []string
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return hrefs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse stylesheet %s: %w", xsltPath, err)
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok || element.Name.Space != xslNamespace {
+			continue
+		}
+		if element.Name.Local != "import" && element.Name.Local != "include" {
+			continue
+		}
+		for _, attr := range element.Attr {
+			if attr.Name.Local == "href" && attr.Value != "" {
+				hrefs = append(hrefs, attr.Value)
+			}
+		}
+	}
+}
+
+// resolveStylesheetHref resolves an import href against the importing file.
+// Remote URIs are left to the XSLT processor and reported as not local.
+func resolveStylesheetHref(importingPath, href string) (string, bool) {
+	if u, err := url.Parse(href); err == nil && u.Scheme != "" {
+		if u.Scheme != "file" {
+			return "", false
+		}
+		href = u.Path
+	}
+	if filepath.IsAbs(href) {
+		return filepath.Clean(href), true
+	}
+	return filepath.Join(filepath.Dir(importingPath), filepath.FromSlash(href)), true
+}
+
+// isWithinDir reports whether path is located below dir
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// stylesheetInfo describes the declarations of a stylesheet that matter to
+// the service: the format of its output and its required top-level params
+type stylesheetInfo struct {
+	Format         string
+	RequiredParams []string
+}
+
+// readStylesheetGraphInfo combines the declarations of the stylesheets of a
+// graph. Parameters required by any imported or included module are
+// required, and the xsl:output of the stylesheet itself takes precedence
+// over those of the modules it imports. The format defaults to
+// application/xml.
+func readStylesheetGraphInfo(graph *stylesheetGraph) *stylesheetInfo {
+	info := &stylesheetInfo{}
+	required := make(map[string]bool)
+	for _, resource := range graph.Resources {
+		module := readStylesheetInfo(resource.Path)
+		if info.Format == "" {
+			info.Format = module.Format
+		}
+		for _, name := range module.RequiredParams {
+			if !required[name] {
+				required[name] = true
+				info.RequiredParams = append(info.RequiredParams, name)
+			}
+		}
+	}
+	if info.Format == "" {
+		info.Format = "application/xml"
+	}
+	return info
+}
+
+// readStylesheetInfo inspects the top-level xsl:output and xsl:param elements
+// of a single stylesheet module. The output format is taken from the
+// media-type or method of xsl:output and is empty without one.
+func readStylesheetInfo(xsltPath string) *stylesheetInfo {
+	info := &stylesheetInfo{}
+
+	data, err := readLocalFile(xsltPath)
+	if err != nil {
+		return info
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return info
+		}
+
+		switch element := token.(type) {
+		case xml.EndElement:
+			depth--
+		case xml.StartElement:
+			depth++
+			// Only children of xsl:stylesheet / xsl:transform are declarations
+			if depth != 2 || element.Name.Space != xslNamespace {
+				continue
+			}
+
+			switch element.Name.Local {
+			case "output":
+				var method, mediaType string
+				for _, attr := range element.Attr {
+					switch attr.Name.Local {
+					case "media-type":
+						mediaType = attr.Value
+					case "method":
+						method = attr.Value
+					}
+				}
+				if format, ok := xsltMethodFormats[method]; ok {
+					info.Format = format
+				}
+				if mediaType != "" {
+					info.Format = mediaType
+				}
+			case "param":
+				var name string
+				var required bool
+				for _, attr := range element.Attr {
+					switch attr.Name.Local {
+					case "name":
+						name = attr.Value
+					case "required":
+						required = attr.Value == "yes" || attr.Value == "true" || attr.Value == "1"
+					}
+				}
+				if required && name != "" {
+					info.RequiredParams = append(info.RequiredParams, name)
+				}
+			}
+		}
+	}
+}
+
+// xslNamespace is the XSLT namespace URI
+const xslNamespace = "http://www.w3.org/1999/XSL/Transform"
+
+// xsltMethodFormats maps xsl:output methods to MIME types
+var xsltMethodFormats = map[string]string{
+	"xml":   "application/xml",
+	"html":  "text/html",
+	"xhtml": "application/xhtml+xml",
+	"text":  "text/plain",
+	"json":  "application/json",
+}
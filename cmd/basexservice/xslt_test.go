@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"eve.evalgo.org/semantic"
+)
+
+func TestTransformActionSources(t *testing.T) {
+	e, server := newTestService(t)
+	server.CreateDatabase("db")
+	dir := t.TempDir()
+	xsltPath := writeFile(t, dir, "main.xsl", testBaseStylesheet)
+	sourcePath := writeFile(t, dir, "source.xml", "<local/>")
+	server.RespondToQuery("xslt:transform-text", "done")
+
+	for _, tc := range []struct {
+		object        map[string]interface{}
+		input, source string
+	}{
+		{map[string]interface{}{"@type": "DigitalDocument", "text": "<inline/>"}, "parse-xml($source)", "<inline/>"},
+		{map[string]interface{}{"@type": "DigitalDocument", "contentUrl": sourcePath}, "parse-xml($source)", "<local/>"},
+		{map[string]interface{}{"@type": "DigitalDocument", "contentUrl": "file://" + sourcePath}, "parse-xml($source)", "<local/>"},
+		{map[string]interface{}{"@type": "DigitalDocument", "contentUrl": "docs/stored.xml"}, `doc("db/docs/stored.xml")`, ""},
+		{map[string]interface{}{"@type": "DigitalDocument", "identifier": "docs/stored.xml"}, `doc("db/docs/stored.xml")`, ""},
+	} {
+		action := serveAction(t, e, map[string]interface{}{
+			"@type":      "TransformAction",
+			"instrument": map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": xsltPath},
+			"object":     tc.object,
+			"target":     catalog(server, "db"),
+		})
+		requireStatus(t, action, "CompletedActionStatus")
+		queries := server.Queries()
+		q := queries[len(queries)-1]
+		if !strings.Contains(q.Text, "let $stage0 := "+tc.input) || q.Variable("source") != tc.source {
+			t.Errorf("%v: source = %q:\n%s", tc.object, q.Variable("source"), q.Text)
+		}
+	}
+
+	// A database is not a source document
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":      "TransformAction",
+		"instrument": map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": xsltPath},
+		"object":     catalog(server, "db"),
+		"target":     catalog(server, "db"),
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "source document") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+}
+
+func TestTransformActionTrace(t *testing.T) {
+	e, server := newTestService(t)
+	server.CreateDatabase("db")
+	dir := t.TempDir()
+	first := writeFile(t, dir, "a.xsl", testBaseStylesheet)
+	second := writeFile(t, dir, "b.xsl", testBaseStylesheet)
+	server.RespondToQuery("return <trace>", `<trace><stage position="1" stylesheet="a.xsl">&lt;a/&gt;</stage><stage position="2" stylesheet="b.xsl">final</stage></trace>`)
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type": "TransformAction",
+		"instrument": []interface{}{
+			map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": first},
+			map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": second},
+		},
+		"object":             map[string]interface{}{"@type": "DigitalDocument", "text": "<doc/>"},
+		"target":             catalog(server, "db"),
+		"additionalProperty": map[string]interface{}{"trace": true},
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+	if output := resultOutput(action); output != "final" {
+		t.Errorf("output = %q, want the last stage", output)
+	}
+	stages, _ := action["trace"].([]interface{})
+	if len(stages) != 2 {
+		t.Fatalf("trace = %v", action["trace"])
+	}
+	for i, want := range []map[string]interface{}{
+		{"position": float64(1), "stylesheet": "a.xsl", "output": "<a/>"},
+		{"position": float64(2), "stylesheet": "b.xsl", "output": "final"},
+	} {
+		stage, _ := stages[i].(map[string]interface{})
+		for key, value := range want {
+			if stage[key] != value {
+				t.Errorf("stage %d: %s = %v, want %v", i+1, key, stage[key], value)
+			}
+		}
+	}
+}
+
+func TestGetStylesheetParameters(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		want  stylesheetParameter
+		err   string
+	}{
+		{"draft", stylesheetParameter{Type: "string", Value: "draft"}, ""},
+		{float64(3), stylesheetParameter{Type: "integer", Value: "3"}, ""},
+		{1.5, stylesheetParameter{Type: "number", Value: "1.5"}, ""},
+		{true, stylesheetParameter{Type: "boolean", Value: "true"}, ""},
+		{map[string]interface{}{"value": "42", "type": "integer"}, stylesheetParameter{Type: "integer", Value: "42"}, ""},
+		{map[string]interface{}{"value": "<a/><b/>", "type": "nodeset"}, stylesheetParameter{Type: "node", Value: "<a/><b/>"}, ""},
+		{map[string]interface{}{"value": "<a/>", "type": "node-set"}, stylesheetParameter{Type: "node", Value: "<a/>"}, ""},
+		{map[string]interface{}{"value": "x", "type": "integer"}, stylesheetParameter{}, "not an integer"},
+		{map[string]interface{}{"value": "2024-01-01", "type": "xs:date"}, stylesheetParameter{}, "unsupported type"},
+		{map[string]interface{}{"type": "string"}, stylesheetParameter{}, "value is required"},
+	} {
+		for _, key := range []string{"parameters", "additionalProperty"} {
+			properties := map[string]interface{}{"parameters": map[string]interface{}{"p": tc.value}}
+			if key == "additionalProperty" {
+				properties = map[string]interface{}{"additionalProperty": properties}
+			}
+			params, err := getStylesheetParameters(&semantic.SemanticAction{Type: "TransformAction", Properties: properties})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("%s %v: error = %v, want %q", key, tc.value, err, tc.err)
+				}
+				continue
+			}
+			if err != nil || params["p"] != tc.want {
+				t.Errorf("%s %v: params = %+v, %v", key, tc.value, params, err)
+			}
+		}
+	}
+
+	// Node sets are bound as fragments, other values keep their XQuery type
+	params := transformParams(map[string]stylesheetParameter{
+		"nodes": {Type: "node", Value: "<a/><b/>"},
+		"count": {Type: "integer", Value: "2"},
+	})
+	if param := params["nodes"]; !param.Fragment || param.Type != "document-node()" {
+		t.Errorf("node set param = %+v", param)
+	}
+	if param := params["count"]; param.Fragment || param.Type != "xs:integer" {
+		t.Errorf("integer param = %+v", param)
+	}
+}
+
+func TestTransformActionNestedImports(t *testing.T) {
+	e, server := newTestService(t)
+	server.CreateDatabase("db")
+	dir := t.TempDir()
+	stylesheet := func(body string) string {
+		return `<xsl:stylesheet version="3.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">` + body + `</xsl:stylesheet>`
+	}
+	xsltPath := writeFile(t, dir, "stages/main.xsl", stylesheet(`<xsl:import href="../lib/a.xsl"/>`))
+	writeFile(t, dir, "lib/a.xsl", stylesheet(`<xsl:include href="b.xsl"/><xsl:import href="https://example.org/remote.xsl"/>`))
+	writeFile(t, dir, "lib/b.xsl", stylesheet(`<xsl:import href="../stages/main.xsl"/><xsl:include href="a.xsl"/>`))
+	server.RespondToQuery("xslt:transform-text", "done")
+
+	action := serveAction(t, e, map[string]interface{}{
+		"@type":      "TransformAction",
+		"instrument": map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": xsltPath},
+		"object":     map[string]interface{}{"@type": "DigitalDocument", "text": "<doc/>"},
+		"target":     catalog(server, "db"),
+	})
+	requireStatus(t, action, "CompletedActionStatus")
+
+	// Cycles are uploaded once and remote imports are left to BaseX
+	uploaded, _ := action["uploadedResources"].([]interface{})
+	if got := fmt.Sprint(uploaded); got != "[stages/main.xsl lib/a.xsl lib/b.xsl]" {
+		t.Errorf("uploadedResources = %s", got)
+	}
+	if got := strings.Join(server.Resources("db"), ","); got != "lib/a.xsl,lib/b.xsl,stages/main.xsl" {
+		t.Errorf("stored resources = %s", got)
+	}
+	if query := server.Queries()[0].Text; !strings.Contains(query, `doc("db/stages/main.xsl")`) {
+		t.Errorf("transform query:\n%s", query)
+	}
+
+	// A missing import fails before anything is uploaded
+	writeFile(t, dir, "broken.xsl", stylesheet(`<xsl:import href="missing.xsl"/>`))
+	action = serveAction(t, e, map[string]interface{}{
+		"@type":      "TransformAction",
+		"instrument": map[string]interface{}{"@type": "XSLTStylesheet", "contentUrl": filepath.Join(dir, "broken.xsl")},
+		"object":     map[string]interface{}{"@type": "DigitalDocument", "text": "<doc/>"},
+		"target":     catalog(server, "db"),
+	})
+	requireStatus(t, action, "FailedActionStatus")
+	if !strings.Contains(errorMessage(action), "stylesheet dependencies") {
+		t.Errorf("error = %q", errorMessage(action))
+	}
+	if len(server.Resources("db")) != 3 {
+		t.Errorf("stored resources = %v", server.Resources("db"))
+	}
+}
@@ -5,6 +5,7 @@ go 1.25.3
 require (
 	eve.evalgo.org v0.0.48
 	github.com/labstack/echo/v4 v4.13.4
+	go.yaml.in/yaml/v2 v2.4.2
 )
 
 require (
@@ -74,7 +75,6 @@ require (
 	go.opentelemetry.io/otel/metric v1.38.0 // indirect
 	go.opentelemetry.io/otel/trace v1.38.0 // indirect
 	go.opentelemetry.io/proto/otlp v1.9.0 // indirect
-	go.yaml.in/yaml/v2 v2.4.2 // indirect
 	golang.org/x/crypto v0.43.0 // indirect
 	golang.org/x/net v0.46.0 // indirect
 	golang.org/x/sys v0.37.0 // indirect